@@ -17,6 +17,16 @@ func SwaggerSpecsHandlerFunc() http.HandlerFunc {
 	}
 }
 
+//go:embed mesh_node_data.proto
+var MeshNodeDataProto []byte
+
+func MeshNodeDataProtoHandlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(MeshNodeDataProto)
+	}
+}
+
 // SwaggerUIOpts configures the Swaggerui middlewares
 type SwaggerUIOpts struct {
 	// BasePath for the UI path, defaults to: /
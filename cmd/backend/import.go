@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/mdma-backend/mdma-backend/internal/pkg/importer"
+	"github.com/mdma-backend/mdma-backend/internal/pkg/storage/postgres"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+// runImport imports a CSV file of measurements directly into the database
+// and prints the rows that were rejected. Interrupted imports are resumed
+// with -resume and the id of the import.
+func runImport(args []string) error {
+	mapping := types.DefaultDataImportMapping()
+
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s import [flags] <file.csv>\n       %s import -resume <id>\n", os.Args[0], os.Args[0])
+		flags.PrintDefaults()
+	}
+	name := flags.String("name", "", "name of the import, defaults to the file name")
+	resume := flags.Uint("resume", 0, "id of an import to resume")
+	flags.BoolVar(&mapping.Header, "header", mapping.Header, "whether the first row is a header")
+	flags.StringVar(&mapping.MeshNodeUUID, "mesh-node-uuid", mapping.MeshNodeUUID, "header name or number of the mesh node uuid column")
+	flags.StringVar(&mapping.Type, "type", mapping.Type, "header name or number of the type column")
+	flags.StringVar(&mapping.MeasuredAt, "measured-at", mapping.MeasuredAt, "header name or number of the measured at column")
+	flags.StringVar(&mapping.Value, "value", mapping.Value, "header name or number of the value column")
+	flags.StringVar(&mapping.TimeFormat, "time-format", mapping.TimeFormat, "layout of the measured at column")
+	flags.Parse(args)
+
+	db, err := postgres.New(databaseDSN)
+	if err != nil {
+		return fmt.Errorf("connecting to postgres: %w", err)
+	}
+	db.AutoCreateDataTypes = autoCreateDataTypes
+
+	id := types.DataImportID(*resume)
+	if id == 0 {
+		if flags.NArg() != 1 {
+			flags.Usage()
+			os.Exit(2)
+		}
+
+		if err := mapping.Validate(); err != nil {
+			return err
+		}
+
+		file := flags.Arg(0)
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		dataImport := types.DataImport{
+			Name:    *name,
+			Mapping: mapping,
+			Data:    data,
+		}
+		if dataImport.Name == "" {
+			dataImport.Name = filepath.Base(file)
+		}
+
+		if err := db.CreateDataImport(&dataImport); err != nil {
+			return fmt.Errorf("creating import: %w", err)
+		}
+		id = dataImport.ID
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	fmt.Fprintf(os.Stderr, "running import %d\n", id)
+
+	runErr := importer.New(db).Run(ctx, id)
+	if errors.Is(runErr, context.Canceled) {
+		return fmt.Errorf("import %d interrupted, resume it with -resume %d", id, id)
+	}
+
+	dataImport, err := db.DataImportByID(id)
+	if err != nil {
+		return err
+	}
+
+	importErrors, err := db.DataImportErrors(id)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range importErrors {
+		fmt.Printf("line %d: %s\n", e.Line, e.Message)
+	}
+
+	fmt.Fprintf(os.Stderr, "import %d %s: %d rows imported, %d rows rejected\n", id, dataImport.Status, dataImport.ImportedRows, dataImport.RejectedRows)
+
+	return runErr
+}
@@ -1,57 +1,50 @@
 package area
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
+	"github.com/mdma-backend/mdma-backend/internal/api/auth"
 	"github.com/mdma-backend/mdma-backend/internal/types"
+	"github.com/mdma-backend/mdma-backend/internal/types/permission"
 )
 
-type Area struct {
-	AreaID        int      `json:"areaId"`
-	MeshNodeUUIDs []string `json:"meshNodeUUIDs"`
-}
-
-var areas = map[uint]Area{
-	1: {
-		AreaID: 1,
-		MeshNodeUUIDs: []string{
-			"a53b3f71-f073-4578-9557-92fd19d93bb9",
-			"c33ea7b6-68a7-4bc6-b1e9-0c365db74081",
-			"f1aef837-04ac-4316-ae1f-0465bc2eb2fa",
-		},
-	},
-	2: {
-		AreaID: 2,
-		MeshNodeUUIDs: []string{
-			"f1aef837-04ac-4316-ae1f-0465bc2eb2fa",
-			"a8957622-acc5-4ddb-bb1f-17e63d3a514f",
-		},
-	},
-	3: {
-		AreaID: 3,
-		MeshNodeUUIDs: []string{
-			"a53b3f71-f073-4578-9557-92fd19d93bb9",
-			"c33ea7b6-68a7-4bc6-b1e9-0c365db74081",
-			"f1aef837-04ac-4316-ae1f-0465bc2eb2fa",
-			"a8957622-acc5-4ddb-bb1f-17e63d3a514f",
-		},
-	},
+type AreaStore interface {
+	AreaByID(types.AreaID) (types.Area, error)
+	Areas() ([]types.Area, error)
+	DescendantAreas(types.AreaID) ([]types.Area, error)
+	AreaSummary(id types.AreaID, meshNodeUUIDs []string, startTime time.Time, endTime time.Time) ([]DataSummary, error)
+	CreateArea(*types.Area) error
+	UpdateArea(types.AreaID, *types.Area) error
+	DeleteArea(types.AreaID) error
 }
 
 type service struct {
-	handler http.Handler
+	handler   http.Handler
+	areaStore AreaStore
 }
 
-func NewService() http.Handler {
+func NewService(areaStore AreaStore) http.Handler {
 	r := chi.NewRouter()
 	s := service{
-		handler: r,
+		handler:   r,
+		areaStore: areaStore,
 	}
 
-	r.Get("/", s.getAreas())
-	r.Get("/{id}", s.getArea())
+	r.Get("/", auth.RestrictHandlerFunc(s.getAreas(), permission.AreaRead))
+	r.Get("/{id}", auth.RestrictHandlerFunc(s.getArea(), permission.AreaRead))
+	r.Get("/{id}/children", auth.RestrictHandlerFunc(s.getChildAreas(), permission.AreaRead))
+	r.Get("/{id}/tree", auth.RestrictHandlerFunc(s.getAreaTree(), permission.AreaRead))
+	r.Get("/{id}/summary", auth.RestrictHandlerFunc(s.getAreaSummary(), permission.AreaRead, permission.DataRead))
+	// Roles scoped to areas could widen their scope through the boundary,
+	// the overrides or the parent of an area
+	r.Post("/", auth.RestrictUnscopedHandlerFunc(s.postArea(), permission.AreaCreate))
+	r.Put("/{id}", auth.RestrictUnscopedHandlerFunc(s.putArea(), permission.AreaUpdate))
+	r.Delete("/{id}", auth.RestrictUnscopedHandlerFunc(s.deleteArea(), permission.AreaDelete))
 
 	return s
 }
@@ -60,32 +53,205 @@ func (s service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.handler.ServeHTTP(w, r)
 }
 
+func (s service) getAreas() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		areas, err := s.areaStore.Areas()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		render.JSON(w, r, areas)
+	}
+}
+
 func (s service) getArea() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		idParam := chi.URLParam(r, "id")
-		id, err := types.IDFromString[uint](idParam)
+		id := chi.URLParam(r, "id")
+		areaID, err := types.IDFromString[types.AreaID](id)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		area, ok := areas[id]
-		if !ok {
+		area, err := s.areaStore.AreaByID(areaID)
+		if errors.Is(err, types.ErrNotFound) {
 			w.WriteHeader(http.StatusNotFound)
 			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
 		render.JSON(w, r, area)
 	}
 }
 
-func (s service) getAreas() http.HandlerFunc {
+func (s service) getChildAreas() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		areaID, err := types.IDFromString[types.AreaID](id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		descendants, err := s.areaStore.DescendantAreas(areaID)
+		if errors.Is(err, types.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		children := []types.Area{}
+		for _, a := range descendants {
+			if *a.ParentID == areaID {
+				children = append(children, a)
+			}
+		}
+
+		render.JSON(w, r, children)
+	}
+}
+
+func (s service) getAreaTree() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		areaID, err := types.IDFromString[types.AreaID](id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		area, err := s.areaStore.AreaByID(areaID)
+		if errors.Is(err, types.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		descendants, err := s.areaStore.DescendantAreas(areaID)
+		if errors.Is(err, types.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		render.JSON(w, r, newAreaTree(area, descendants))
+	}
+}
+
+// newAreaTree nests the descendants below the area by their parents.
+func newAreaTree(area types.Area, descendants []types.Area) types.AreaTree {
+	children := map[types.AreaID][]types.Area{}
+	for _, a := range descendants {
+		children[*a.ParentID] = append(children[*a.ParentID], a)
+	}
+
+	var tree func(types.Area) types.AreaTree
+	tree = func(a types.Area) types.AreaTree {
+		t := types.AreaTree{
+			Area:     a,
+			Children: []types.AreaTree{},
+		}
+		for _, child := range children[a.ID] {
+			t.Children = append(t.Children, tree(child))
+		}
+
+		return t
+	}
+
+	return tree(area)
+}
+
+func (s service) postArea() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var area types.Area
+		if err := json.NewDecoder(r.Body).Decode(&area); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := area.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := s.areaStore.CreateArea(&area); errors.Is(err, types.ErrInvalidValue) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		render.Status(r, http.StatusCreated)
+		render.JSON(w, r, area)
+	}
+}
+
+func (s service) putArea() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var aa []Area
-		for _, a := range areas {
-			aa = append(aa, a)
+		id := chi.URLParam(r, "id")
+		areaID, err := types.IDFromString[types.AreaID](id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var area types.Area
+		if err := json.NewDecoder(r.Body).Decode(&area); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := area.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := s.areaStore.UpdateArea(areaID, &area); errors.Is(err, types.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if errors.Is(err, types.ErrInvalidValue) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		area.ID = areaID
+
+		render.JSON(w, r, area)
+	}
+}
+
+func (s service) deleteArea() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		areaID, err := types.IDFromString[types.AreaID](id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := s.areaStore.DeleteArea(areaID); errors.Is(err, types.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if errors.Is(err, types.ErrInvalidValue) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
-		render.JSON(w, r, aa)
+		w.WriteHeader(http.StatusNoContent)
 	}
 }
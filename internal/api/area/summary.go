@@ -0,0 +1,104 @@
+package area
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/mdma-backend/mdma-backend/internal/api/auth"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+// Summary summarizes the data measured by the mesh nodes of an area and of
+// all areas below it within a time window.
+type Summary struct {
+	AreaID        types.AreaID  `json:"areaId"`
+	MeasuredStart string        `json:"measuredStart"`
+	MeasuredEnd   string        `json:"measuredEnd"`
+	DataTypes     []DataSummary `json:"types"`
+}
+
+// DataSummary summarizes the data of one type. Minimum, Maximum and Average
+// are null for types that are neither numeric nor boolean.
+type DataSummary struct {
+	DataType string      `json:"type"`
+	Count    int         `json:"count"`
+	Latest   Measurement `json:"latest"`
+	Minimum  *float64    `json:"minimum"`
+	Maximum  *float64    `json:"maximum"`
+	Average  *float64    `json:"average"`
+}
+
+// Measurement is the last value measured within the window.
+type Measurement struct {
+	MeshNodeUUID string `json:"meshNodeUUID"`
+	MeasuredAt   string `json:"measuredAt"`
+	Value        string `json:"value"`
+}
+
+func (s service) getAreaSummary() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		areaID, err := types.IDFromString[types.AreaID](id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		endTime := time.Now()
+		measuredEnd := r.URL.Query().Get("measuredEnd")
+		if measuredEnd != "" {
+			endTime, err = time.Parse(time.RFC3339, measuredEnd)
+			if err != nil {
+				http.Error(w, "measuredEnd in wrong time format", http.StatusBadRequest)
+				return
+			}
+		}
+
+		// The window defaults to the day before its end
+		startTime := endTime.AddDate(0, 0, -1)
+		if measuredStart := r.URL.Query().Get("measuredStart"); measuredStart != "" {
+			startTime, err = time.Parse(time.RFC3339, measuredStart)
+			if err != nil {
+				http.Error(w, "measuredStart in wrong time format", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if !startTime.Before(endTime) {
+			http.Error(w, "measuredStart must be before measuredEnd", http.StatusBadRequest)
+			return
+		}
+
+		// Scoped roles only see the data of the mesh nodes in their areas
+		var meshNodeUUIDs []string
+		if scope, scoped := auth.MeshNodesInScope(r); scoped {
+			meshNodeUUIDs = []string{}
+			for uuid := range scope {
+				meshNodeUUIDs = append(meshNodeUUIDs, uuid)
+			}
+		}
+
+		dataSummaries, err := s.areaStore.AreaSummary(areaID, meshNodeUUIDs, startTime, endTime)
+		if errors.Is(err, types.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if dataSummaries == nil {
+			dataSummaries = []DataSummary{}
+		}
+
+		render.JSON(w, r, Summary{
+			AreaID:        areaID,
+			MeasuredStart: startTime.Format(time.RFC3339Nano),
+			MeasuredEnd:   endTime.Format(time.RFC3339Nano),
+			DataTypes:     dataSummaries,
+		})
+	}
+}
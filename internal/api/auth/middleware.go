@@ -101,6 +101,83 @@ func RestrictHandlerFunc(next http.HandlerFunc, permissions ...permission.Permis
 	}
 }
 
+// MeshNodeFunc returns the UUID of the mesh node a request targets or an
+// empty string if it does not target a mesh node.
+type MeshNodeFunc func(*http.Request) (string, error)
+
+// RestrictMeshNodeHandlerFunc is like RestrictHandlerFunc, but additionally
+// requires the role of the account to apply to the mesh node the request
+// targets. Roles scoped to areas only apply to the mesh nodes of these areas.
+// Requests that do not target a mesh node are left to next.
+func RestrictMeshNodeHandlerFunc(next http.HandlerFunc, meshNode MeshNodeFunc, permissions ...permission.Permission) http.HandlerFunc {
+	return RestrictHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		meshNodeUUID, err := meshNode(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if meshNodeUUID != "" && !MeshNodeInScope(r, meshNodeUUID) {
+			http.Error(w, fmt.Sprintf("mesh node %s is not in the areas of the role", meshNodeUUID), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}, permissions...)
+}
+
+// RestrictUnscopedHandlerFunc is like RestrictHandlerFunc, but additionally
+// rejects accounts whose role is scoped to areas. It guards changes that
+// could widen the scope of a role, like the boundaries of areas.
+func RestrictUnscopedHandlerFunc(next http.HandlerFunc, permissions ...permission.Permission) http.HandlerFunc {
+	return RestrictHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, ok := r.Context().Value(AccountInfoCtxKey).(types.AccountInfo)
+		if !ok || info.Role.IsScoped() {
+			http.Error(w, "role is scoped to areas", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}, permissions...)
+}
+
+// MeshNodeInScope reports whether the role of the account of the request
+// applies to the mesh node.
+func MeshNodeInScope(r *http.Request, meshNodeUUID string) bool {
+	info, ok := r.Context().Value(AccountInfoCtxKey).(types.AccountInfo)
+	if !ok {
+		return false
+	}
+
+	id, err := types.UUIDFromString(meshNodeUUID)
+	if err != nil {
+		return false
+	}
+
+	return info.Role.AppliesTo(id)
+}
+
+// MeshNodesInScope returns the UUIDs of the mesh nodes the role of the
+// account of the request applies to. It returns false if the role applies to
+// all mesh nodes.
+func MeshNodesInScope(r *http.Request) (map[string]bool, bool) {
+	info, ok := r.Context().Value(AccountInfoCtxKey).(types.AccountInfo)
+	if !ok {
+		return map[string]bool{}, true
+	}
+
+	if !info.Role.IsScoped() {
+		return nil, false
+	}
+
+	meshNodeUUIDs := make(map[string]bool, len(info.Role.MeshNodeUUIDs))
+	for _, id := range info.Role.MeshNodeUUIDs {
+		meshNodeUUIDs[id.String()] = true
+	}
+
+	return meshNodeUUIDs, true
+}
+
 func RestrictHandler(next http.Handler, permissions ...permission.Permission) http.Handler {
 	return http.HandlerFunc(RestrictHandlerFunc(next.ServeHTTP, permissions...))
 }
@@ -0,0 +1,120 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/mdma-backend/mdma-backend/internal/api/auth"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+// Status of the data of a mesh node in a batch. Only failed data should be
+// sent again.
+const (
+	BatchStored   = "stored"
+	BatchRejected = "rejected"
+	BatchFailed   = "failed"
+)
+
+// BatchData is the data of a mesh node in a batch.
+type BatchData struct {
+	MeshNodeUUID string `json:"meshNodeUUID"`
+	Data         []Data `json:"data"`
+}
+
+// BatchResult reports whether the data of a mesh node in a batch was stored.
+type BatchResult struct {
+	MeshNodeUUID string         `json:"meshNodeUUID"`
+	Status       string         `json:"status"`
+	Error        string         `json:"error,omitempty"`
+	Data         []IngestedData `json:"data,omitempty"`
+}
+
+// maxBatchSize limits the size of a batch.
+const maxBatchSize = 32 << 20
+
+// postBatch stores the data of many mesh nodes. The data of each mesh node
+// is stored on its own, so the result of each mesh node tells which ones to
+// send again. With an idempotency key the UUIDs of the data are derived from
+// their content, so only the failed entries can be sent again with the same
+// key.
+func (s service) postBatch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var batch []BatchData
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchSize)).Decode(&batch)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte("413 " + err.Error()))
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 " + err.Error()))
+			return
+		}
+
+		key := r.Header.Get(IdempotencyKeyHeader)
+		// Data with the same content is told apart by its occurrence
+		occurrences := map[string]int{}
+		status := http.StatusCreated
+		results := make([]BatchResult, 0, len(batch))
+		for _, b := range batch {
+			result := s.storeBatchData(r, b, key, occurrences)
+			if result.Status != BatchStored {
+				status = http.StatusMultiStatus
+			}
+			results = append(results, result)
+		}
+
+		render.Status(r, status)
+		render.JSON(w, r, results)
+	}
+}
+
+func (s service) storeBatchData(r *http.Request, b BatchData, key string, occurrences map[string]int) BatchResult {
+	result := BatchResult{
+		MeshNodeUUID: b.MeshNodeUUID,
+		Status:       BatchRejected,
+	}
+
+	meshNodeUUID, err := types.UUIDFromString(b.MeshNodeUUID)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if !auth.MeshNodeInScope(r, meshNodeUUID.String()) {
+		result.Error = fmt.Sprintf("mesh node %s is not in the areas of the role", meshNodeUUID)
+		return result
+	}
+
+	for i := range b.Data {
+		b.Data[i].MeshNodeUUID = meshNodeUUID.String()
+		if key != "" && b.Data[i].UUID == "" {
+			content := IdempotentDataUUID(key, b.Data[i], 0)
+			b.Data[i].UUID = IdempotentDataUUID(key, b.Data[i], occurrences[content])
+			occurrences[content]++
+		}
+	}
+
+	err = s.dataStore.CreateData(b.Data)
+	if errors.Is(err, types.ErrInvalidValue) || errors.Is(err, types.ErrNotFound) {
+		result.Error = err.Error()
+		return result
+	} else if err != nil {
+		result.Status = BatchFailed
+		result.Error = err.Error()
+		return result
+	}
+	s.dataHub.Publish(b.Data...)
+
+	result.Status = BatchStored
+	for _, d := range b.Data {
+		result.Data = append(result.Data, NewIngestedData(d))
+	}
+
+	return result
+}
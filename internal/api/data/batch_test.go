@@ -0,0 +1,93 @@
+package data
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mdma-backend/mdma-backend/internal/api/auth"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+// fakeDataStore records the data it creates.
+type fakeDataStore struct {
+	DataStore
+	created []Data
+}
+
+func (s *fakeDataStore) CreateData(data []Data) error {
+	s.created = append(s.created, data...)
+	return nil
+}
+
+type fakeDataHub struct {
+	DataHub
+}
+
+func (fakeDataHub) Publish(...Data) {}
+
+func postBatchRequest(body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(body))
+	r.Header.Set(IdempotencyKeyHeader, "key")
+	return r.WithContext(context.WithValue(r.Context(), auth.AccountInfoCtxKey, types.AccountInfo{}))
+}
+
+func TestPostBatchIdempotent(t *testing.T) {
+	const node1, node2 = "0cc56633-05ae-4cc3-8f71-801f429caeca", "6f1c8f0e-1b5a-4b8e-9d3a-2c7e5f4a1b90"
+	a := `{"type": "temperature", "measuredAt": "2023-11-14T22:13:20Z", "value": "21.5"}`
+	b := `{"type": "temperature", "measuredAt": "2023-11-14T22:14:20Z", "value": "22"}`
+
+	uuids := func(body string) []string {
+		store := &fakeDataStore{}
+		s := service{dataStore: store, dataHub: fakeDataHub{}}
+
+		w := httptest.NewRecorder()
+		s.postBatch()(w, postBatchRequest(body))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("postBatch() status = %d: %s", w.Code, w.Body)
+		}
+
+		var uuids []string
+		for _, d := range store.created {
+			uuids = append(uuids, d.MeshNodeUUID+" "+d.UUID)
+		}
+		sort.Strings(uuids)
+		return uuids
+	}
+
+	first := uuids(`[
+		{"meshNodeUUID": "` + node1 + `", "data": [` + a + `, ` + b + `, ` + a + `]},
+		{"meshNodeUUID": "` + node2 + `", "data": [` + a + `]}
+	]`)
+	// A retry with the data in another order and split differently
+	retry := uuids(`[
+		{"meshNodeUUID": "` + node2 + `", "data": [` + a + `]},
+		{"meshNodeUUID": "` + node1 + `", "data": [` + a + `]},
+		{"meshNodeUUID": "` + node1 + `", "data": [` + b + `, ` + a + `]}
+	]`)
+
+	if strings.Join(first, "\n") != strings.Join(retry, "\n") {
+		t.Errorf("retry got UUIDs\n%s\nwant\n%s", strings.Join(retry, "\n"), strings.Join(first, "\n"))
+	}
+
+	distinct := map[string]bool{}
+	for _, uuid := range first {
+		distinct[uuid] = true
+	}
+	if len(distinct) != 4 {
+		t.Errorf("postBatch() gave %d distinct UUIDs to 4 data: %v", len(distinct), first)
+	}
+}
+
+func TestPostBatchTooLarge(t *testing.T) {
+	s := service{dataStore: &fakeDataStore{}, dataHub: fakeDataHub{}}
+
+	w := httptest.NewRecorder()
+	s.postBatch()(w, postBatchRequest(`[{"meshNodeUUID": "`+strings.Repeat(" ", maxBatchSize)+`"}]`))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("postBatch() status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
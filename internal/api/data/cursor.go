@@ -0,0 +1,66 @@
+package data
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+const (
+	defaultPageLimit = 1000
+	maxPageLimit     = 10000
+)
+
+// Cursor points to the last measurement of a page. The next page starts
+// right after it in the sort order of measured_at and id.
+type Cursor struct {
+	MeasuredAt time.Time
+	UUID       string
+}
+
+// Page selects a page of measurements. A Limit of 0 selects all measurements
+// after the cursor.
+type Page struct {
+	After      *Cursor
+	Limit      int
+	Descending bool
+}
+
+// String returns the opaque representation of the cursor that clients pass
+// back to get the next page.
+func (c Cursor) String() string {
+	s := c.MeasuredAt.Format(time.RFC3339Nano) + "|" + c.UUID
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+func parseCursor(s string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, errInvalidCursor
+	}
+
+	measuredAtStr, uuidStr, ok := strings.Cut(string(b), "|")
+	if !ok {
+		return Cursor{}, errInvalidCursor
+	}
+
+	uuid, err := types.UUIDFromString(uuidStr)
+	if err != nil {
+		return Cursor{}, errInvalidCursor
+	}
+
+	measuredAt, err := time.Parse(time.RFC3339Nano, measuredAtStr)
+	if err != nil {
+		return Cursor{}, errInvalidCursor
+	}
+
+	return Cursor{
+		MeasuredAt: measuredAt,
+		UUID:       uuid.String(),
+	}, nil
+}
@@ -0,0 +1,57 @@
+package data
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseCursor(t *testing.T) {
+	measuredAt := time.Date(2023, 5, 1, 12, 30, 0, 123456789, time.UTC)
+	uuid := "0cc56633-05ae-4cc3-8f71-801f429caeca"
+
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+		want   Cursor
+		err    error
+	}{
+		{
+			name:   "round trip",
+			cursor: Cursor{MeasuredAt: measuredAt, UUID: uuid}.String(),
+			want:   Cursor{MeasuredAt: measuredAt, UUID: uuid},
+		},
+		{
+			name:   "upper case uuid",
+			cursor: encode(measuredAt.Format(time.RFC3339Nano) + "|0CC56633-05AE-4CC3-8F71-801F429CAECA"),
+			want:   Cursor{MeasuredAt: measuredAt, UUID: uuid},
+		},
+		{name: "not base64", cursor: "not base64!", err: errInvalidCursor},
+		{name: "missing separator", cursor: encode(measuredAt.Format(time.RFC3339Nano)), err: errInvalidCursor},
+		{name: "missing uuid", cursor: encode(measuredAt.Format(time.RFC3339Nano) + "|"), err: errInvalidCursor},
+		{name: "invalid uuid", cursor: encode(measuredAt.Format(time.RFC3339Nano) + "|' OR 1=1 --"), err: errInvalidCursor},
+		{name: "invalid time", cursor: encode("yesterday|" + uuid), err: errInvalidCursor},
+		{name: "empty", cursor: "", err: errInvalidCursor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCursor(tt.cursor)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseCursor(%q) error = %v, want %v", tt.cursor, err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+
+			if !got.MeasuredAt.Equal(tt.want.MeasuredAt) || got.UUID != tt.want.UUID {
+				t.Errorf("parseCursor(%q) = %+v, want %+v", tt.cursor, got, tt.want)
+			}
+		})
+	}
+}
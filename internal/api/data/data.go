@@ -2,11 +2,15 @@ package data
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
 	"github.com/mdma-backend/mdma-backend/internal/api/auth"
 	"github.com/mdma-backend/mdma-backend/internal/types"
 	"github.com/mdma-backend/mdma-backend/internal/types/permission"
@@ -14,15 +18,31 @@ import (
 
 type DataStore interface {
 	GetAggregatedData(dataType string, meshNodeUUIDs []string, startTime time.Time, endTime time.Time, sampleTime time.Duration, sampleCount int, aggregateFunction string) (AggregatedData, error)
-	GetManyData(dataType string, meshNodeUUIDs []string, startTime time.Time, endTime time.Time) (ManyData, error)
+	GetManyData(dataType string, meshNodeUUIDs []string, startTime time.Time, endTime time.Time, page Page) (ManyData, *Cursor, error)
+	StreamManyData(dataType string, meshNodeUUIDs []string, startTime time.Time, endTime time.Time, page Page, fn func(meshNodeUUID string, measuredAt time.Time, measurement Measurement) error) error
 	GetData(uuid string) (Data, error)
+	CreateData([]Data) error
 	DeleteData(uuid string) error
-	GetTypes() ([]string, error)
+	DataTypeByID(types.DataTypeID) (types.DataType, error)
+	DataTypes() ([]types.DataType, error)
+	MeshNodesWithin(types.SpatialFilter) ([]types.MeshNode, error)
+	AreaByID(types.AreaID) (types.Area, error)
+	CreateDataType(*types.DataType) error
+	UpdateDataType(types.DataTypeID, *types.DataType) error
+	DeleteDataType(id types.DataTypeID, force bool) error
+}
+
+// DataHub notifies about newly ingested data.
+type DataHub interface {
+	Publish(...Data)
+	Subscribe(filter func(Data) bool) (<-chan Data, func())
 }
 
 type service struct {
 	handler   http.Handler
 	dataStore DataStore
+	dataHub   DataHub
+	upgrader  websocket.Upgrader
 }
 
 type AggregatedData struct {
@@ -33,9 +53,24 @@ type AggregatedData struct {
 }
 
 type Sample struct {
-	IntervalStartAt string `json:"intervalStartAt"`
-	IntervalEndAt   string `json:"intervalEndAt"`
-	Value           string `json:"value"`
+	IntervalStartAt string  `json:"intervalStartAt"`
+	IntervalEndAt   string  `json:"intervalEndAt"`
+	Value           *string `json:"value"`
+	Filled          bool    `json:"filled"`
+}
+
+// sampleTimeLayout is the layout of the interval times of samples, which are
+// formatted by time.Time.String.
+const sampleTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// ParseSampleTime parses an interval time of a sample.
+func ParseSampleTime(s string) (time.Time, error) {
+	// Times with a monotonic clock reading have it appended
+	if i := strings.Index(s, " m="); i >= 0 {
+		s = s[:i]
+	}
+
+	return time.Parse(sampleTimeLayout, s)
 }
 
 type ManyData struct {
@@ -54,6 +89,8 @@ type Measurement struct {
 	Value      string `json:"value"`
 }
 
+// Data is a single measurement. Clients may supply its UUID to make
+// ingestion idempotent.
 type Data struct {
 	UUID         string `json:"uuid"`
 	MeshNodeUUID string `json:"meshNodeUUID"`
@@ -61,22 +98,66 @@ type Data struct {
 	CreatedAt    string `json:"createdAt"`
 	MeasuredAt   string `json:"measuredAt"`
 	Value        string `json:"value"`
+	// Duplicate is set by the store if data with the UUID was already stored.
+	Duplicate bool `json:"-"`
 }
 
-func NewService(dataStore DataStore, tokenService types.TokenService, roleService auth.RoleStore) http.Handler {
+// NewService returns the data API. WebSocket streams are only accepted from
+// the origin of the backend itself and from the streamOrigins.
+func NewService(dataStore DataStore, dataHub DataHub, tokenService types.TokenService, roleService auth.RoleStore, streamOrigins []string) http.Handler {
 	r := chi.NewRouter()
 	s := service{
 		handler:   r,
 		dataStore: dataStore,
+		dataHub:   dataHub,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: checkStreamOrigin(streamOrigins),
+		},
 	}
 
-	r.Get("/", s.getManyData())
+	r.Get("/", restrictLocationFilters(s.getManyData(), tokenService, roleService))
 	r.Get("/{uuid}", s.getData())
 	r.Get("/types", s.getDataTypes())
-	r.Get("/aggregated", s.getAggregatedData())
+	r.Get("/types/{id}", s.getDataType())
+	r.Get("/aggregated", restrictLocationFilters(s.getAggregatedData(), tokenService, roleService))
+	r.Get("/stream", auth.JWTHandlerFunc(
+		auth.RestrictHandlerFunc(s.getDataStream(), permission.DataRead),
+		tokenService,
+		roleService,
+	))
+	r.Get("/stream/ws", auth.JWTHandlerFunc(
+		auth.RestrictHandlerFunc(s.getDataWebSocket(), permission.DataRead),
+		tokenService,
+		roleService,
+	))
 
+	r.Post("/batch", auth.JWTHandlerFunc(
+		auth.RestrictHandlerFunc(s.postBatch(), permission.DataCreate),
+		tokenService,
+		roleService,
+	))
+	r.Post("/senml", auth.JWTHandlerFunc(
+		auth.RestrictHandlerFunc(s.postSenML(), permission.DataCreate),
+		tokenService,
+		roleService,
+	))
 	r.Delete("/{uuid}", auth.JWTHandlerFunc(
-		auth.RestrictHandlerFunc(s.deleteData(), permission.DataDelete),
+		auth.RestrictMeshNodeHandlerFunc(s.deleteData(), s.dataMeshNode, permission.DataDelete),
+		tokenService,
+		roleService,
+	))
+	r.Post("/types", auth.JWTHandlerFunc(
+		auth.RestrictHandlerFunc(s.postDataType(), permission.DataTypeCreate),
+		tokenService,
+		roleService,
+	))
+	r.Put("/types/{id}", auth.JWTHandlerFunc(
+		auth.RestrictHandlerFunc(s.putDataType(), permission.DataTypeUpdate),
+		tokenService,
+		roleService,
+	))
+	r.Delete("/types/{id}", auth.JWTHandlerFunc(
+		auth.RestrictHandlerFunc(s.deleteDataType(), permission.DataTypeDelete),
 		tokenService,
 		roleService,
 	))
@@ -84,6 +165,30 @@ func NewService(dataStore DataStore, tokenService types.TokenService, roleServic
 	return s
 }
 
+// restrictLocationFilters requires a token with the permission area_read for
+// the filter area and mesh_node_read for the filters bbox and near, since
+// they reveal where mesh nodes are. Requests without them stay public.
+func restrictLocationFilters(next http.HandlerFunc, tokenService types.TokenService, roleService auth.RoleStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		var permissions []permission.Permission
+		if query.Has("area") {
+			permissions = append(permissions, permission.AreaRead)
+		}
+		if query.Has("bbox") || query.Has("near") || query.Has("radius") {
+			permissions = append(permissions, permission.MeshNodeRead)
+		}
+
+		if len(permissions) == 0 {
+			next(w, r)
+			return
+		}
+
+		auth.JWTHandlerFunc(auth.RestrictHandlerFunc(next, permissions...), tokenService, roleService)(w, r)
+	}
+}
+
 func (s service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.handler.ServeHTTP(w, r)
 }
@@ -154,13 +259,71 @@ func (s service) getAggregatedData() http.HandlerFunc {
 			return
 		}
 
+		if sampleTime < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 sampleDuration must be positive"))
+			return
+		}
+
+		// Intervals are at least a nanosecond long
+		if sampleCount != 0 && endTime.Sub(startTime) < time.Duration(sampleCount) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 the time window is too short for sampleCount"))
+			return
+		}
+
 		aggregateFunction := r.URL.Query().Get("aggregateFunction")
-		if !isValidAggregateFunction(aggregateFunction) {
+		if !IsValidAggregateFunction(aggregateFunction) {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte("400 aggregateFunction is required"))
 			return
 		}
 
+		format, err := negotiateFormat(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 " + err.Error()))
+			return
+		}
+		if format == FormatSenML {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 senml is only supported for measurements"))
+			return
+		}
+
+		fill := FillZero
+		if fillValue := r.URL.Query().Get("fill"); fillValue != "" {
+			fill = Fill(fillValue)
+			if !IsValidFill(fill) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("400 fill must be one of null, zero, previous or linear"))
+				return
+			}
+		}
+
+		if areas := r.URL.Query()["area"]; len(areas) > 0 {
+			meshNodeUUIDs, err = s.meshNodesInAreas(areas, meshNodeUUIDs)
+			if errors.Is(err, types.ErrInvalidValue) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("400 " + err.Error()))
+				return
+			} else if errors.Is(err, types.ErrNotFound) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("404 area not found"))
+				return
+			} else if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("500 internal server error"))
+				return
+			}
+
+			if len(meshNodeUUIDs) == 0 {
+				w.WriteHeader(http.StatusNoContent)
+				w.Write([]byte("404 no mesh nodes found"))
+				return
+			}
+		}
+
 		data, err := s.dataStore.GetAggregatedData(dataType, meshNodeUUIDs, startTime, endTime, sampleTime, sampleCount, aggregateFunction)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -173,6 +336,12 @@ func (s service) getAggregatedData() http.HandlerFunc {
 			w.Write([]byte("404 no samles found"))
 			return
 		}
+		FillSamples(data.Samples, fill)
+
+		if format != FormatJSON {
+			s.exportAggregatedData(w, format, data)
+			return
+		}
 
 		response, err := json.Marshal(data)
 		if err != nil {
@@ -187,7 +356,7 @@ func (s service) getAggregatedData() http.HandlerFunc {
 	}
 }
 
-func isValidAggregateFunction(aggregateFunction string) bool {
+func IsValidAggregateFunction(aggregateFunction string) bool {
 	validFunctions := map[string]bool{
 		"count":   true,
 		"sum":     true,
@@ -235,44 +404,109 @@ func (s service) getManyData() http.HandlerFunc {
 			}
 		}
 
-		data, err := s.dataStore.GetManyData(dataType, meshNodeUUIDs, startTime, endTime)
+		spatialFilter, err := types.SpatialFilterFromQuery(r.URL.Query())
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("500 internal server error"))
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 " + err.Error()))
 			return
 		}
 
-		if data.MeasuredDatas == nil {
-			w.WriteHeader(http.StatusNoContent)
-			w.Write([]byte("404 no data found"))
-			return
+		if !spatialFilter.IsEmpty() {
+			meshNodeUUIDs, err = s.meshNodesWithin(spatialFilter, meshNodeUUIDs)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("500 internal server error"))
+				return
+			}
+
+			if len(meshNodeUUIDs) == 0 {
+				w.WriteHeader(http.StatusNoContent)
+				w.Write([]byte("404 no mesh nodes found"))
+				return
+			}
 		}
 
-		response, err := json.Marshal(data)
+		if areas := r.URL.Query()["area"]; len(areas) > 0 {
+			meshNodeUUIDs, err = s.meshNodesInAreas(areas, meshNodeUUIDs)
+			if errors.Is(err, types.ErrInvalidValue) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("400 " + err.Error()))
+				return
+			} else if errors.Is(err, types.ErrNotFound) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("404 area not found"))
+				return
+			} else if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("500 internal server error"))
+				return
+			}
+
+			if len(meshNodeUUIDs) == 0 {
+				w.WriteHeader(http.StatusNoContent)
+				w.Write([]byte("404 no mesh nodes found"))
+				return
+			}
+		}
+
+		format, err := negotiateFormat(r)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("500 internal server error"))
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 " + err.Error()))
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write(response)
-	}
-}
+		// Exports are not paginated unless a limit is given
+		page := Page{
+			Limit: defaultPageLimit,
+		}
+		if format != FormatJSON {
+			page.Limit = 0
+		}
 
-func (s service) getData() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		uuid := chi.URLParam(r, "uuid")
+		if limit := r.URL.Query().Get("limit"); limit != "" {
+			var err error
+			page.Limit, err = strconv.Atoi(limit)
+			if err != nil || page.Limit <= 0 || page.Limit > maxPageLimit {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(fmt.Sprintf("400 limit must be an integer between 1 and %d", maxPageLimit)))
+				return
+			}
+		}
 
-		data, err := s.dataStore.GetData(uuid)
+		switch r.URL.Query().Get("order") {
+		case "", "asc":
+		case "desc":
+			page.Descending = true
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 order must be asc or desc"))
+			return
+		}
+
+		if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+			after, err := parseCursor(cursor)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("400 " + err.Error()))
+				return
+			}
+			page.After = &after
+		}
+
+		if format != FormatJSON {
+			s.exportManyData(w, format, dataType, meshNodeUUIDs, startTime, endTime, page)
+			return
+		}
+
+		data, next, err := s.dataStore.GetManyData(dataType, meshNodeUUIDs, startTime, endTime, page)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte("500 internal server error"))
 			return
 		}
 
-		if data.Value == "" {
+		if data.MeasuredDatas == nil {
 			w.WriteHeader(http.StatusNoContent)
 			w.Write([]byte("404 no data found"))
 			return
@@ -285,28 +519,96 @@ func (s service) getData() http.HandlerFunc {
 			return
 		}
 
+		if next != nil {
+			nextURL := *r.URL
+			query := nextURL.Query()
+			query.Set("cursor", next.String())
+			nextURL.RawQuery = query.Encode()
+			w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextURL.RequestURI()))
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		w.Write(response)
 	}
 }
 
-func (s service) getDataTypes() http.HandlerFunc {
+// meshNodesWithin returns the UUIDs of the mesh nodes matching a spatial
+// filter. If meshNodeUUIDs is not empty, only these mesh nodes are matched.
+func (s service) meshNodesWithin(filter types.SpatialFilter, meshNodeUUIDs []string) ([]string, error) {
+	meshNodes, err := s.dataStore.MeshNodesWithin(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := map[string]bool{}
+	for _, uuid := range meshNodeUUIDs {
+		selected[uuid] = true
+	}
+
+	var uuids []string
+	for _, n := range meshNodes {
+		if len(meshNodeUUIDs) == 0 || selected[n.UUID.String()] {
+			uuids = append(uuids, n.UUID.String())
+		}
+	}
+
+	return uuids, nil
+}
+
+// meshNodesInAreas returns the UUIDs of the mesh nodes of the areas, given
+// by their ids, and of all areas below them. If meshNodeUUIDs is not empty,
+// only these mesh nodes are matched.
+func (s service) meshNodesInAreas(areaIDs []string, meshNodeUUIDs []string) ([]string, error) {
+	selected := map[string]bool{}
+	for _, uuid := range meshNodeUUIDs {
+		selected[uuid] = true
+	}
+
+	found := map[string]bool{}
+	var uuids []string
+	for _, id := range areaIDs {
+		areaID, err := types.IDFromString[types.AreaID](id)
+		if err != nil {
+			return nil, fmt.Errorf("%w: area: %s", types.ErrInvalidValue, err)
+		}
+
+		area, err := s.dataStore.AreaByID(areaID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, n := range area.MeshNodeUUIDs {
+			uuid := n.String()
+			if found[uuid] || len(meshNodeUUIDs) != 0 && !selected[uuid] {
+				continue
+			}
+			found[uuid] = true
+			uuids = append(uuids, uuid)
+		}
+	}
+
+	return uuids, nil
+}
+
+func (s service) getData() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		dataTypes, err := s.dataStore.GetTypes()
+		uuid := chi.URLParam(r, "uuid")
+
+		data, err := s.dataStore.GetData(uuid)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte("500 internal server error"))
 			return
 		}
 
-		if dataTypes == nil {
+		if data.Value == "" {
 			w.WriteHeader(http.StatusNoContent)
-			w.Write([]byte("404 no dataType found"))
+			w.Write([]byte("404 no data found"))
 			return
 		}
 
-		response, err := json.Marshal(dataTypes)
+		response, err := json.Marshal(data)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte("500 internal server error"))
@@ -319,6 +621,16 @@ func (s service) getDataTypes() http.HandlerFunc {
 	}
 }
 
+// dataMeshNode returns the mesh node of the data of the uuid URL parameter.
+func (s service) dataMeshNode(r *http.Request) (string, error) {
+	data, err := s.dataStore.GetData(chi.URLParam(r, "uuid"))
+	if err != nil {
+		return "", err
+	}
+
+	return data.MeshNodeUUID, nil
+}
+
 func (s service) deleteData() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		uuid := chi.URLParam(r, "uuid")
@@ -0,0 +1,168 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+// getDataTypes returns the names of all data types, as it did before types
+// had metadata. With details=true it returns the data types themselves.
+func (s service) getDataTypes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dataTypes, err := s.dataStore.DataTypes()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Query().Get("details") == "true" {
+			render.JSON(w, r, dataTypes)
+			return
+		}
+
+		if dataTypes == nil {
+			w.WriteHeader(http.StatusNoContent)
+			w.Write([]byte("404 no dataType found"))
+			return
+		}
+
+		names := make([]string, 0, len(dataTypes))
+		for _, t := range dataTypes {
+			names = append(names, t.Name)
+		}
+
+		render.JSON(w, r, names)
+	}
+}
+
+func (s service) getDataType() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		dataTypeID, err := types.IDFromString[types.DataTypeID](id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		dataType, err := s.dataStore.DataTypeByID(dataTypeID)
+		if errors.Is(err, types.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		render.JSON(w, r, dataType)
+	}
+}
+
+func (s service) postDataType() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dataType := types.DataType{AutoCreateAllowed: true}
+		if err := json.NewDecoder(r.Body).Decode(&dataType); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !dataType.Kind.IsValid() {
+			http.Error(w, fmt.Sprintf("invalid kind %q", dataType.Kind), http.StatusBadRequest)
+			return
+		}
+
+		if err := validateDataType(dataType); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := s.dataStore.CreateDataType(&dataType); errors.Is(err, types.ErrConflict) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		render.Status(r, http.StatusCreated)
+		render.JSON(w, r, dataType)
+	}
+}
+
+func (s service) putDataType() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		dataTypeID, err := types.IDFromString[types.DataTypeID](id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		dataType := types.DataType{AutoCreateAllowed: true}
+		if err := json.NewDecoder(r.Body).Decode(&dataType); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := validateDataType(dataType); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := s.dataStore.UpdateDataType(dataTypeID, &dataType); errors.Is(err, types.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if errors.Is(err, types.ErrConflict) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		dataType.ID = dataTypeID
+
+		render.JSON(w, r, dataType)
+	}
+}
+
+func (s service) deleteDataType() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		dataTypeID, err := types.IDFromString[types.DataTypeID](id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		force := r.URL.Query().Get("force") == "true"
+		if err := s.dataStore.DeleteDataType(dataTypeID, force); errors.Is(err, types.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if errors.Is(err, types.ErrConflict) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func validateDataType(dataType types.DataType) error {
+	if dataType.Name == "" {
+		return errors.New("name is required")
+	}
+
+	if dataType.Min != nil && dataType.Max != nil && *dataType.Min > *dataType.Max {
+		return errors.New("min must not be greater than max")
+	}
+
+	return nil
+}
@@ -0,0 +1,361 @@
+package data
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Format is the representation of data in a response.
+type Format string
+
+const (
+	FormatJSON    Format = "json"
+	FormatCSV     Format = "csv"
+	FormatNDJSON  Format = "ndjson"
+	FormatParquet Format = "parquet"
+	FormatSenML   Format = "senml"
+)
+
+// parquetRowGroupSize is the number of rows after which a parquet row group
+// is written to the response, so exports are not buffered in memory.
+const parquetRowGroupSize = 10000
+
+var formatContentTypes = map[Format]string{
+	FormatJSON:    "application/json",
+	FormatCSV:     "text/csv",
+	FormatNDJSON:  "application/x-ndjson",
+	FormatParquet: "application/vnd.apache.parquet",
+	FormatSenML:   senMLJSONContentType,
+}
+
+var contentTypeFormats = map[string]Format{
+	"application/json":               FormatJSON,
+	"text/csv":                       FormatCSV,
+	"application/x-ndjson":           FormatNDJSON,
+	"application/ndjson":             FormatNDJSON,
+	"application/vnd.apache.parquet": FormatParquet,
+	"application/x-parquet":          FormatParquet,
+	senMLJSONContentType:             FormatSenML,
+}
+
+// negotiateFormat returns the format requested by the format parameter or,
+// if not given, the first supported media type of the Accept header. It
+// defaults to JSON.
+func negotiateFormat(r *http.Request) (Format, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		if _, ok := formatContentTypes[Format(format)]; !ok {
+			return "", fmt.Errorf("format must be one of json, csv, ndjson, parquet or senml")
+		}
+		return Format(format), nil
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+
+		if format, ok := contentTypeFormats[mediaType]; ok {
+			return format, nil
+		}
+	}
+
+	return FormatJSON, nil
+}
+
+// exportManyData streams the measurements of a data type as records in
+// format. Once the first record is written, errors can only be logged and
+// end the response early.
+func (s service) exportManyData(w http.ResponseWriter, format Format, dataType string, meshNodeUUIDs []string, startTime time.Time, endTime time.Time, page Page) {
+	if format == FormatSenML {
+		s.exportSenML(w, dataType, meshNodeUUIDs, startTime, endTime, page)
+		return
+	}
+
+	var records recordWriter[measurementRecord]
+
+	err := s.dataStore.StreamManyData(dataType, meshNodeUUIDs, startTime, endTime, page, func(meshNodeUUID string, measuredAt time.Time, measurement Measurement) error {
+		if records == nil {
+			records = newRecordWriter[measurementRecord](w, format, dataType)
+		}
+
+		return records.Write(measurementRecord{
+			MeshNodeUUID: meshNodeUUID,
+			Type:         dataType,
+			UUID:         measurement.UUID,
+			MeasuredAt:   measuredAt,
+			Value:        measurement.Value,
+		})
+	})
+	if err != nil && records == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 internal server error"))
+		return
+	}
+	if err != nil {
+		log.Printf("exporting %s data: %s", dataType, err)
+		return
+	}
+
+	if records == nil {
+		records = newRecordWriter[measurementRecord](w, format, dataType)
+	}
+
+	if err := records.Close(); err != nil {
+		log.Printf("exporting %s data: %s", dataType, err)
+	}
+}
+
+// exportAggregatedData writes the samples of aggregated data as records in
+// format.
+func (s service) exportAggregatedData(w http.ResponseWriter, format Format, data AggregatedData) {
+	// The times are parsed before writing, so a failure can still be reported
+	sampleRecords := make([]sampleRecord, 0, len(data.Samples))
+	for _, sample := range data.Samples {
+		intervalStartAt, err := ParseSampleTime(sample.IntervalStartAt)
+		if err != nil {
+			log.Printf("exporting aggregated %s data: %s", data.DataType, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 internal server error"))
+			return
+		}
+
+		intervalEndAt, err := ParseSampleTime(sample.IntervalEndAt)
+		if err != nil {
+			log.Printf("exporting aggregated %s data: %s", data.DataType, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 internal server error"))
+			return
+		}
+
+		sampleRecords = append(sampleRecords, sampleRecord{
+			Type:              data.DataType,
+			AggregateFunction: data.AggregateFunction,
+			IntervalStartAt:   intervalStartAt,
+			IntervalEndAt:     intervalEndAt,
+			Value:             sample.Value,
+			Filled:            sample.Filled,
+		})
+	}
+
+	records := newRecordWriter[sampleRecord](w, format, data.DataType+"-"+data.AggregateFunction)
+	for _, record := range sampleRecords {
+		if err := records.Write(record); err != nil {
+			log.Printf("exporting aggregated %s data: %s", data.DataType, err)
+			return
+		}
+	}
+
+	if err := records.Close(); err != nil {
+		log.Printf("exporting aggregated %s data: %s", data.DataType, err)
+	}
+}
+
+// record is a flat row of an export. Its parquet row is a pointer to a struct
+// with parquet tags; the one of the zero record defines the schema.
+type record interface {
+	csvHeader() []string
+	csvRow() []string
+	parquetRow() interface{}
+}
+
+type measurementRecord struct {
+	MeshNodeUUID string    `json:"meshNodeUUID"`
+	Type         string    `json:"type"`
+	UUID         string    `json:"uuid"`
+	MeasuredAt   time.Time `json:"measuredAt"`
+	Value        string    `json:"value"`
+}
+
+type measurementParquetRow struct {
+	MeshNodeUUID string `parquet:"name=mesh_node_uuid, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Type         string `parquet:"name=type, type=BYTE_ARRAY, convertedtype=UTF8"`
+	UUID         string `parquet:"name=uuid, type=BYTE_ARRAY, convertedtype=UTF8"`
+	MeasuredAt   int64  `parquet:"name=measured_at, type=INT64, convertedtype=TIMESTAMP_MICROS"`
+	Value        string `parquet:"name=value, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+func (measurementRecord) csvHeader() []string {
+	return []string{"mesh_node_uuid", "type", "uuid", "measured_at", "value"}
+}
+
+func (r measurementRecord) csvRow() []string {
+	return []string{r.MeshNodeUUID, r.Type, r.UUID, r.MeasuredAt.Format(time.RFC3339Nano), r.Value}
+}
+
+func (r measurementRecord) parquetRow() interface{} {
+	return &measurementParquetRow{
+		MeshNodeUUID: r.MeshNodeUUID,
+		Type:         r.Type,
+		UUID:         r.UUID,
+		MeasuredAt:   r.MeasuredAt.UnixMicro(),
+		Value:        r.Value,
+	}
+}
+
+type sampleRecord struct {
+	Type              string    `json:"type"`
+	AggregateFunction string    `json:"aggregationFunction"`
+	IntervalStartAt   time.Time `json:"intervalStartAt"`
+	IntervalEndAt     time.Time `json:"intervalEndAt"`
+	Value             *string   `json:"value"`
+	Filled            bool      `json:"filled"`
+}
+
+type sampleParquetRow struct {
+	Type              string  `parquet:"name=type, type=BYTE_ARRAY, convertedtype=UTF8"`
+	AggregateFunction string  `parquet:"name=aggregation_function, type=BYTE_ARRAY, convertedtype=UTF8"`
+	IntervalStartAt   int64   `parquet:"name=interval_start_at, type=INT64, convertedtype=TIMESTAMP_MICROS"`
+	IntervalEndAt     int64   `parquet:"name=interval_end_at, type=INT64, convertedtype=TIMESTAMP_MICROS"`
+	Value             *string `parquet:"name=value, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	Filled            bool    `parquet:"name=filled, type=BOOLEAN"`
+}
+
+func (sampleRecord) csvHeader() []string {
+	return []string{"type", "aggregation_function", "interval_start_at", "interval_end_at", "value", "filled"}
+}
+
+func (r sampleRecord) csvRow() []string {
+	var value string
+	if r.Value != nil {
+		value = *r.Value
+	}
+
+	return []string{
+		r.Type,
+		r.AggregateFunction,
+		r.IntervalStartAt.Format(time.RFC3339Nano),
+		r.IntervalEndAt.Format(time.RFC3339Nano),
+		value,
+		strconv.FormatBool(r.Filled),
+	}
+}
+
+func (r sampleRecord) parquetRow() interface{} {
+	return &sampleParquetRow{
+		Type:              r.Type,
+		AggregateFunction: r.AggregateFunction,
+		IntervalStartAt:   r.IntervalStartAt.UnixMicro(),
+		IntervalEndAt:     r.IntervalEndAt.UnixMicro(),
+		Value:             r.Value,
+		Filled:            r.Filled,
+	}
+}
+
+// recordWriter writes the records of an export one by one to a response.
+type recordWriter[T record] interface {
+	Write(T) error
+	Close() error
+}
+
+// newRecordWriter sets the headers of the response for an export named name
+// and returns a writer for the records in format.
+func newRecordWriter[T record](w http.ResponseWriter, format Format, name string) recordWriter[T] {
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+
+	switch format {
+	case FormatCSV:
+		return &csvRecordWriter[T]{w: csv.NewWriter(w)}
+	case FormatParquet:
+		return &parquetRecordWriter[T]{w: w}
+	default:
+		return &ndjsonRecordWriter[T]{enc: json.NewEncoder(w)}
+	}
+}
+
+type csvRecordWriter[T record] struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (w *csvRecordWriter[T]) Write(r T) error {
+	if !w.headerWritten {
+		if err := w.w.Write(r.csvHeader()); err != nil {
+			return err
+		}
+		w.headerWritten = true
+	}
+
+	return w.w.Write(r.csvRow())
+}
+
+func (w *csvRecordWriter[T]) Close() error {
+	if !w.headerWritten {
+		var r T
+		if err := w.w.Write(r.csvHeader()); err != nil {
+			return err
+		}
+	}
+
+	w.w.Flush()
+	return w.w.Error()
+}
+
+type ndjsonRecordWriter[T record] struct {
+	enc *json.Encoder
+}
+
+func (w *ndjsonRecordWriter[T]) Write(r T) error {
+	return w.enc.Encode(r)
+}
+
+func (w *ndjsonRecordWriter[T]) Close() error {
+	return nil
+}
+
+type parquetRecordWriter[T record] struct {
+	w    io.Writer
+	pw   *writer.ParquetWriter
+	rows int
+}
+
+func (w *parquetRecordWriter[T]) init() error {
+	if w.pw != nil {
+		return nil
+	}
+
+	var r T
+	pw, err := writer.NewParquetWriterFromWriter(w.w, r.parquetRow(), 1)
+	if err != nil {
+		return err
+	}
+	w.pw = pw
+
+	return nil
+}
+
+func (w *parquetRecordWriter[T]) Write(r T) error {
+	if err := w.init(); err != nil {
+		return err
+	}
+
+	if err := w.pw.Write(r.parquetRow()); err != nil {
+		return err
+	}
+
+	w.rows++
+	if w.rows%parquetRowGroupSize == 0 {
+		return w.pw.Flush(true)
+	}
+
+	return nil
+}
+
+func (w *parquetRecordWriter[T]) Close() error {
+	if err := w.init(); err != nil {
+		return err
+	}
+
+	return w.pw.WriteStop()
+}
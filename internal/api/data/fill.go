@@ -0,0 +1,90 @@
+package data
+
+import (
+	"strconv"
+)
+
+// Fill describes how samples of intervals without any measurement are
+// filled.
+type Fill string
+
+const (
+	// FillNull leaves the value of empty intervals as null.
+	FillNull Fill = "null"
+	// FillZero sets the value of empty intervals to zero.
+	FillZero Fill = "zero"
+	// FillPrevious carries the value of the last non-empty interval forward.
+	FillPrevious Fill = "previous"
+	// FillLinear interpolates linearly between the surrounding non-empty
+	// intervals.
+	FillLinear Fill = "linear"
+)
+
+func IsValidFill(fill Fill) bool {
+	switch fill {
+	case FillNull, FillZero, FillPrevious, FillLinear:
+		return true
+	default:
+		return false
+	}
+}
+
+// FillSamples fills every sample without a value according to fill and
+// marks it as filled. Samples that can not be filled, like leading samples
+// when carrying values forward, keep a null value.
+func FillSamples(samples []Sample, fill Fill) {
+	for i := range samples {
+		if samples[i].Value != nil {
+			continue
+		}
+		samples[i].Filled = true
+
+		switch fill {
+		case FillZero:
+			samples[i].Value = stringPtr("0")
+		case FillPrevious:
+			if i > 0 {
+				samples[i].Value = samples[i-1].Value
+			}
+		case FillLinear:
+			samples[i].Value = interpolate(samples, i)
+		}
+	}
+}
+
+// interpolate returns the linear interpolation of the sample at index i
+// between the closest samples before and after it that hold a measured
+// numeric value.
+func interpolate(samples []Sample, i int) *string {
+	prev := i - 1
+	for prev >= 0 && (samples[prev].Filled || samples[prev].Value == nil) {
+		prev--
+	}
+
+	next := i + 1
+	for next < len(samples) && samples[next].Value == nil {
+		next++
+	}
+
+	if prev < 0 || next >= len(samples) {
+		return nil
+	}
+
+	prevValue, err := strconv.ParseFloat(*samples[prev].Value, 64)
+	if err != nil {
+		return nil
+	}
+
+	nextValue, err := strconv.ParseFloat(*samples[next].Value, 64)
+	if err != nil {
+		return nil
+	}
+
+	ratio := float64(i-prev) / float64(next-prev)
+	value := strconv.FormatFloat(prevValue+(nextValue-prevValue)*ratio, 'f', -1, 64)
+	return &value
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,50 @@
+package data
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+// IdempotencyKeyHeader lets clients retry a request without storing its data
+// twice, even if the data has no client supplied UUID.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyNamespace is the namespace of the UUIDs derived from
+// idempotency keys.
+var idempotencyNamespace = uuid.Must(uuid.FromString("5b7f3a9e-2f4c-4d8e-9a61-3c0e8d2b7f14"))
+
+// IdempotentUUID derives the UUID of the i-th data of a request from its
+// idempotency key. Retries with the same key and data get the same UUIDs, so
+// the store recognizes them as duplicates.
+func IdempotentUUID(meshNodeUUID types.UUID, key string, i int) string {
+	return uuid.NewV5(idempotencyNamespace, meshNodeUUID.String()+"/"+key+"/"+strconv.Itoa(i)).String()
+}
+
+// IdempotentDataUUID derives the UUID of data from the idempotency key of a
+// request and the content of the data, so it does not depend on the position
+// of the data in the request. n tells apart data with the same content.
+func IdempotentDataUUID(key string, d Data, n int) string {
+	name := strings.Join([]string{d.MeshNodeUUID, key, d.Type, d.MeasuredAt, d.Value, strconv.Itoa(n)}, "\x00")
+	return uuid.NewV5(idempotencyNamespace, name).String()
+}
+
+// IngestedData reports whether data was stored by the request or already by
+// an earlier one. Duplicate is null if the data was only queued to be stored,
+// so it is not known yet.
+type IngestedData struct {
+	Data
+	Duplicate *bool `json:"duplicate"`
+}
+
+func NewIngestedData(d Data) IngestedData {
+	duplicate := d.Duplicate
+	return IngestedData{Data: d, Duplicate: &duplicate}
+}
+
+// NewQueuedData returns queued data whose duplicate status is unknown.
+func NewQueuedData(d Data) IngestedData {
+	return IngestedData{Data: d}
+}
@@ -0,0 +1,82 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestIdempotentDataUUID(t *testing.T) {
+	d := Data{MeshNodeUUID: "0cc56633-05ae-4cc3-8f71-801f429caeca", Type: "temperature", MeasuredAt: "2023-11-14T22:13:20Z", Value: "21.5"}
+	uuid := IdempotentDataUUID("key", d, 0)
+
+	if again := IdempotentDataUUID("key", d, 0); again != uuid {
+		t.Errorf("IdempotentDataUUID() = %s, then %s", uuid, again)
+	}
+
+	// Data that is stored does not change the UUID
+	stored := d
+	stored.Duplicate = true
+	if got := IdempotentDataUUID("key", stored, 0); got != uuid {
+		t.Errorf("IdempotentDataUUID() of stored data = %s, want %s", got, uuid)
+	}
+
+	other := map[string]Data{}
+	for name, change := range map[string]func(*Data){
+		"mesh node":   func(d *Data) { d.MeshNodeUUID = "6f1c8f0e-1b5a-4b8e-9d3a-2c7e5f4a1b90" },
+		"type":        func(d *Data) { d.Type = "humidity" },
+		"measured at": func(d *Data) { d.MeasuredAt = "2023-11-14T22:13:21Z" },
+		"value":       func(d *Data) { d.Value = "21.6" },
+		// Fields are separated, so they can not be shifted into each other
+		"shifted fields": func(d *Data) { d.Type, d.MeasuredAt = "temperature\x002023-11-14T22:13:20Z", "" },
+	} {
+		changed := d
+		change(&changed)
+		other[name] = changed
+	}
+
+	for name, changed := range other {
+		if got := IdempotentDataUUID("key", changed, 0); got == uuid {
+			t.Errorf("IdempotentDataUUID() of data with another %s = %s, the UUID of the original", name, got)
+		}
+	}
+	if got := IdempotentDataUUID("other key", d, 0); got == uuid {
+		t.Errorf("IdempotentDataUUID() with another key = %s, the UUID of the original", got)
+	}
+	if got := IdempotentDataUUID("key", d, 1); got == uuid {
+		t.Errorf("IdempotentDataUUID() of the second occurrence = %s, the UUID of the first", got)
+	}
+}
+
+func TestIngestedDataJSON(t *testing.T) {
+	d := Data{UUID: "6f1c8f0e-1b5a-4b8e-9d3a-2c7e5f4a1b90", Type: "temperature", Value: "21.5"}
+	duplicate := d
+	duplicate.Duplicate = true
+
+	tests := []struct {
+		name string
+		data IngestedData
+		want string
+	}{
+		{name: "stored", data: NewIngestedData(d), want: "false"},
+		{name: "duplicate", data: NewIngestedData(duplicate), want: "true"},
+		{name: "queued", data: NewQueuedData(duplicate), want: "null"},
+	}
+
+	for _, tt := range tests {
+		b, err := json.Marshal(tt.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(b, &fields); err != nil {
+			t.Fatal(err)
+		}
+		if got := string(fields["duplicate"]); got != tt.want {
+			t.Errorf("duplicate of %s data = %s, want %s", tt.name, got, tt.want)
+		}
+		if got := string(fields["uuid"]); got != `"`+d.UUID+`"` {
+			t.Errorf("uuid of %s data = %s, want %q", tt.name, got, d.UUID)
+		}
+	}
+}
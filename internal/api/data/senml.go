@@ -0,0 +1,308 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-chi/render"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+const (
+	senMLJSONContentType = "application/senml+json"
+	senMLCBORContentType = "application/senml+cbor"
+)
+
+// maxSenMLSize limits the size of a SenML pack.
+const maxSenMLSize = 32 << 20
+
+// senMLRelativeTime is the time below which SenML times are relative to now
+// (RFC 8428, section 4.5.3).
+const senMLRelativeTime = 1 << 28
+
+// senMLMaxTime bounds SenML times, so relative times fit into a
+// time.Duration.
+const senMLMaxTime = 1 << 33
+
+// senMLNamePrefix is the prefix of SenML names of mesh nodes. Names are the
+// UUID of a mesh node followed by a separator and the name of a data type,
+// for example urn:uuid:0cc56633-05ae-4cc3-8f71-801f429caeca:temperature.
+const senMLNamePrefix = "urn:uuid:"
+
+// senMLRecord is a record of a SenML pack. The CBOR keys are the labels of
+// RFC 8428, section 6.
+type senMLRecord struct {
+	BaseName    string   `json:"bn,omitempty" cbor:"-2,keyasint,omitempty"`
+	BaseTime    float64  `json:"bt,omitempty" cbor:"-3,keyasint,omitempty"`
+	BaseUnit    string   `json:"bu,omitempty" cbor:"-4,keyasint,omitempty"`
+	BaseValue   *float64 `json:"bv,omitempty" cbor:"-5,keyasint,omitempty"`
+	Name        string   `json:"n,omitempty" cbor:"0,keyasint,omitempty"`
+	Unit        string   `json:"u,omitempty" cbor:"1,keyasint,omitempty"`
+	Value       *float64 `json:"v,omitempty" cbor:"2,keyasint,omitempty"`
+	StringValue *string  `json:"vs,omitempty" cbor:"3,keyasint,omitempty"`
+	BoolValue   *bool    `json:"vb,omitempty" cbor:"4,keyasint,omitempty"`
+	Time        float64  `json:"t,omitempty" cbor:"6,keyasint,omitempty"`
+}
+
+// postSenML stores a SenML pack. The base name and name of a record are the
+// mesh node and data type, a record without data type in its name uses its
+// unit as data type. The data of each mesh node is stored on its own like
+// in a batch.
+func (s service) postSenML() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSenMLSize))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte("413 " + err.Error()))
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 " + err.Error()))
+			return
+		}
+
+		var pack []senMLRecord
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == senMLCBORContentType {
+			err = cbor.Unmarshal(body, &pack)
+		} else {
+			err = json.Unmarshal(body, &pack)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 " + err.Error()))
+			return
+		}
+
+		batch, err := resolveSenML(pack, time.Now())
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 " + err.Error()))
+			return
+		}
+
+		key := r.Header.Get(IdempotencyKeyHeader)
+		occurrences := map[string]int{}
+		status := http.StatusCreated
+		results := make([]BatchResult, 0, len(batch))
+		for _, b := range batch {
+			result := s.storeBatchData(r, b, key, occurrences)
+			if result.Status != BatchStored {
+				status = http.StatusMultiStatus
+			}
+			results = append(results, result)
+		}
+
+		render.Status(r, status)
+		render.JSON(w, r, results)
+	}
+}
+
+// resolveSenML applies the base fields to the records of a pack and groups
+// them by mesh node in the order of their first record.
+func resolveSenML(pack []senMLRecord, now time.Time) ([]BatchData, error) {
+	var baseName, baseUnit string
+	var baseTime float64
+	var baseValue *float64
+
+	var batch []BatchData
+	batchIndex := map[string]int{}
+	for i, record := range pack {
+		if record.BaseName != "" {
+			baseName = record.BaseName
+		}
+		if record.BaseTime != 0 {
+			baseTime = record.BaseTime
+		}
+		if record.BaseUnit != "" {
+			baseUnit = record.BaseUnit
+		}
+		if record.BaseValue != nil {
+			baseValue = record.BaseValue
+		}
+
+		meshNodeUUID, dataType, err := splitSenMLName(baseName + record.Name)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+
+		if dataType == "" {
+			dataType = record.Unit
+		}
+		if dataType == "" {
+			dataType = baseUnit
+		}
+		if dataType == "" {
+			return nil, fmt.Errorf("record %d: name has no data type and there is no unit", i)
+		}
+
+		var value string
+		switch {
+		case record.Value != nil:
+			v := *record.Value
+			if baseValue != nil {
+				v += *baseValue
+			}
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		case record.StringValue != nil:
+			value = *record.StringValue
+		case record.BoolValue != nil:
+			value = strconv.FormatBool(*record.BoolValue)
+		default:
+			return nil, fmt.Errorf("record %d has no value", i)
+		}
+
+		j, ok := batchIndex[meshNodeUUID]
+		if !ok {
+			j = len(batch)
+			batchIndex[meshNodeUUID] = j
+			batch = append(batch, BatchData{MeshNodeUUID: meshNodeUUID})
+		}
+
+		// NaN fails the comparisons as well
+		t := baseTime + record.Time
+		if !(t > -senMLMaxTime && t < senMLMaxTime) {
+			return nil, fmt.Errorf("record %d: time %v is out of range", i, t)
+		}
+
+		batch[j].Data = append(batch[j].Data, Data{
+			MeshNodeUUID: meshNodeUUID,
+			Type:         dataType,
+			MeasuredAt:   senMLTime(t, now).Format(time.RFC3339Nano),
+			Value:        value,
+		})
+	}
+
+	return batch, nil
+}
+
+// splitSenMLName splits a name into the UUID of a mesh node and the name of
+// a data type, which may be empty.
+func splitSenMLName(name string) (string, string, error) {
+	name = strings.TrimPrefix(name, senMLNamePrefix)
+
+	const uuidLen = 36
+	if len(name) < uuidLen {
+		return "", "", fmt.Errorf("name %q does not start with a mesh node uuid", name)
+	}
+
+	meshNodeUUID, err := types.UUIDFromString(name[:uuidLen])
+	if err != nil {
+		return "", "", fmt.Errorf("name %q does not start with a mesh node uuid", name)
+	}
+
+	dataType := name[uuidLen:]
+	if dataType != "" {
+		if !strings.ContainsAny(dataType[:1], ":/.") {
+			return "", "", fmt.Errorf("name %q has no separator after the mesh node uuid", name)
+		}
+		dataType = dataType[1:]
+	}
+
+	return meshNodeUUID.String(), dataType, nil
+}
+
+// senMLTime converts a SenML time in seconds to a time. Small times are
+// relative to now.
+func senMLTime(t float64, now time.Time) time.Time {
+	if t < senMLRelativeTime {
+		return now.Add(time.Duration(t * float64(time.Second))).UTC()
+	}
+
+	sec, frac := math.Modf(t)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC()
+}
+
+// exportSenML streams the measurements of a data type as a SenML pack. The
+// base name is repeated whenever the mesh node changes and the unit of the
+// data type is the base unit.
+func (s service) exportSenML(w http.ResponseWriter, dataType string, meshNodeUUIDs []string, startTime time.Time, endTime time.Time, page Page) {
+	dataTypes, err := s.dataStore.DataTypes()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 internal server error"))
+		return
+	}
+
+	var t types.DataType
+	for _, dt := range dataTypes {
+		if dt.Name == dataType {
+			t = dt
+			break
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	var lastMeshNodeUUID string
+	first := true
+
+	err = s.dataStore.StreamManyData(dataType, meshNodeUUIDs, startTime, endTime, page, func(meshNodeUUID string, measuredAt time.Time, measurement Measurement) error {
+		record := senMLRecord{
+			Name: dataType,
+			Time: float64(measuredAt.UnixNano()) / 1e9,
+		}
+
+		if meshNodeUUID != lastMeshNodeUUID {
+			record.BaseName = senMLNamePrefix + meshNodeUUID + ":"
+			lastMeshNodeUUID = meshNodeUUID
+		}
+
+		separator := ","
+		if first {
+			w.Header().Set("Content-Type", senMLJSONContentType)
+			record.BaseUnit = t.Unit
+			separator = "["
+			first = false
+		}
+
+		setSenMLValue(&record, t.Kind, measurement.Value)
+
+		if _, err := io.WriteString(w, separator); err != nil {
+			return err
+		}
+		return enc.Encode(record)
+	})
+	if err != nil && first {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 internal server error"))
+		return
+	}
+	if err != nil {
+		log.Printf("exporting %s data as senml: %s", dataType, err)
+		return
+	}
+
+	if first {
+		w.Header().Set("Content-Type", senMLJSONContentType)
+		io.WriteString(w, "[")
+	}
+	io.WriteString(w, "]\n")
+}
+
+// setSenMLValue sets the value field that fits the kind of the data type.
+func setSenMLValue(record *senMLRecord, kind types.ValueKind, value string) {
+	switch {
+	case kind.IsNumeric():
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			record.Value = &v
+			return
+		}
+	case kind == types.BooleanValueKind:
+		if v, err := strconv.ParseBool(value); err == nil {
+			record.BoolValue = &v
+			return
+		}
+	}
+
+	record.StringValue = &value
+}
@@ -0,0 +1,114 @@
+package data
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+func TestResolveSenML(t *testing.T) {
+	now := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+	node1 := "0cc56633-05ae-4cc3-8f71-801f429caeca"
+	node2 := "6f1c8f0e-1b5a-4b8e-9d3a-2c7e5f4a1b90"
+
+	tests := []struct {
+		name string
+		pack string
+		want []BatchData
+		err  bool
+	}{
+		{
+			name: "base fields",
+			pack: `[
+				{"bn": "urn:uuid:` + node1 + `:", "bt": 1700000000, "bu": "Cel", "bv": 20, "n": "temperature", "v": 1.5},
+				{"n": "temperature", "t": 60, "v": 2},
+				{"n": "humidity", "u": "%RH", "v": 40}
+			]`,
+			want: []BatchData{{MeshNodeUUID: node1, Data: []Data{
+				{MeshNodeUUID: node1, Type: "temperature", MeasuredAt: "2023-11-14T22:13:20Z", Value: "21.5"},
+				{MeshNodeUUID: node1, Type: "temperature", MeasuredAt: "2023-11-14T22:14:20Z", Value: "22"},
+				{MeshNodeUUID: node1, Type: "humidity", MeasuredAt: "2023-11-14T22:13:20Z", Value: "60"},
+			}}},
+		},
+		{
+			name: "grouped by mesh node",
+			pack: `[
+				{"n": "` + node1 + `/temperature", "t": 1700000000, "v": 21},
+				{"n": "` + node2 + `.door", "t": 1700000000, "vb": true},
+				{"n": "` + node1 + `:status", "t": 1700000000, "vs": "ok"}
+			]`,
+			want: []BatchData{
+				{MeshNodeUUID: node1, Data: []Data{
+					{MeshNodeUUID: node1, Type: "temperature", MeasuredAt: "2023-11-14T22:13:20Z", Value: "21"},
+					{MeshNodeUUID: node1, Type: "status", MeasuredAt: "2023-11-14T22:13:20Z", Value: "ok"},
+				}},
+				{MeshNodeUUID: node2, Data: []Data{
+					{MeshNodeUUID: node2, Type: "door", MeasuredAt: "2023-11-14T22:13:20Z", Value: "true"},
+				}},
+			},
+		},
+		{
+			name: "unit as data type and relative time",
+			pack: `[{"n": "` + node1 + `", "u": "Cel", "t": -30.5, "v": 21}]`,
+			want: []BatchData{{MeshNodeUUID: node1, Data: []Data{
+				{MeshNodeUUID: node1, Type: "Cel", MeasuredAt: "2023-11-14T22:12:49.5Z", Value: "21"},
+			}}},
+		},
+		{
+			name: "fractional absolute time",
+			pack: `[{"n": "` + node1 + `:t", "t": 1700000000.25, "v": 1}]`,
+			want: []BatchData{{MeshNodeUUID: node1, Data: []Data{
+				{MeshNodeUUID: node1, Type: "t", MeasuredAt: "2023-11-14T22:13:20.25Z", Value: "1"},
+			}}},
+		},
+		{name: "no mesh node", pack: `[{"n": "temperature", "v": 1}]`, err: true},
+		{name: "invalid mesh node", pack: `[{"n": "0cc56633-05ae-4cc3-8f71-801f429caecx:t", "v": 1}]`, err: true},
+		{name: "no separator", pack: `[{"n": "` + node1 + `temperature", "v": 1}]`, err: true},
+		{name: "no data type", pack: `[{"n": "` + node1 + `", "v": 1}]`, err: true},
+		{name: "no value", pack: `[{"n": "` + node1 + `:t"}]`, err: true},
+		{name: "time out of range", pack: `[{"n": "` + node1 + `:t", "t": -1e300, "v": 1}]`, err: true},
+		{name: "absolute time out of range", pack: `[{"n": "` + node1 + `:t", "t": 1e300, "v": 1}]`, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pack []senMLRecord
+			if err := json.Unmarshal([]byte(tt.pack), &pack); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := resolveSenML(pack, now)
+			if (err != nil) != tt.err {
+				t.Fatalf("resolveSenML() error = %v, want error %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolveSenML() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSenMLCBORLabels(t *testing.T) {
+	v := 21.5
+	record := senMLRecord{BaseName: "urn:uuid:0cc56633-05ae-4cc3-8f71-801f429caeca:", Name: "temperature", Value: &v, Time: 1700000000}
+
+	b, err := cbor.Marshal([]senMLRecord{record})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded []map[int]interface{}
+	if err := cbor.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	// RFC 8428, section 6
+	for _, label := range []int{-2, 0, 2, 6} {
+		if _, ok := decoded[0][label]; !ok {
+			t.Errorf("record has no label %d: %v", label, decoded[0])
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mdma-backend/mdma-backend/internal/api/auth"
+)
+
+const (
+	streamKeepAliveInterval = 15 * time.Second
+	streamWriteTimeout      = 10 * time.Second
+)
+
+// checkStreamOrigin allows WebSocket handshakes without an Origin header,
+// which are not sent by browsers, from the host of the backend and from the
+// allowed origins. CORS does not apply to WebSockets, so without this check
+// any site could open a stream with the cookie of a logged in user.
+func checkStreamOrigin(allowedOrigins []string) func(*http.Request) bool {
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		allowed[strings.ToLower(strings.TrimSuffix(strings.TrimSpace(origin), "/"))] = true
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+
+		if allowed[strings.ToLower(origin)] {
+			return true
+		}
+
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+
+		return strings.EqualFold(u.Host, r.Host)
+	}
+}
+
+// streamFilter returns a filter for the data of the type and mesh nodes
+// given in the query of the request. Empty parameters match everything.
+// Only data of mesh nodes the role of the account applies to is matched.
+func streamFilter(r *http.Request) func(Data) bool {
+	dataType := r.URL.Query().Get("type")
+	meshNodeUUIDs := map[string]bool{}
+	for _, uuid := range r.URL.Query()["meshNodes"] {
+		meshNodeUUIDs[uuid] = true
+	}
+	scope, scoped := auth.MeshNodesInScope(r)
+
+	return func(d Data) bool {
+		if dataType != "" && d.Type != dataType {
+			return false
+		}
+
+		if len(meshNodeUUIDs) > 0 && !meshNodeUUIDs[d.MeshNodeUUID] {
+			return false
+		}
+
+		if scoped && !scope[d.MeshNodeUUID] {
+			return false
+		}
+
+		return true
+	}
+}
+
+func (s service) getDataStream() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		dataChan, unsubscribe := s.dataHub.Subscribe(streamFilter(r))
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(streamKeepAliveInterval)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			case d, ok := <-dataChan:
+				if !ok {
+					return
+				}
+
+				event, err := json.Marshal(d)
+				if err != nil {
+					log.Printf("marshaling streamed data: %s", err)
+					continue
+				}
+
+				if _, err := fmt.Fprintf(w, "id: %s\nevent: data\ndata: %s\n\n", d.UUID, event); err != nil {
+					return
+				}
+			}
+
+			flusher.Flush()
+		}
+	}
+}
+
+func (s service) getDataWebSocket() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter := streamFilter(r)
+
+		conn, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader already replied with an error
+			return
+		}
+		defer conn.Close()
+
+		dataChan, unsubscribe := s.dataHub.Subscribe(filter)
+		defer unsubscribe()
+
+		// Clients do not send anything but control messages, which have to
+		// be read for the connection to notice when it is closed
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		keepAlive := time.NewTicker(streamKeepAliveInterval)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-closed:
+				return
+			case <-keepAlive.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+					return
+				}
+			case d, ok := <-dataChan:
+				if !ok {
+					return
+				}
+
+				conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+				if err := conn.WriteJSON(d); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package data_import
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/mdma-backend/mdma-backend/internal/api/auth"
+	"github.com/mdma-backend/mdma-backend/internal/pkg/importer"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+	"github.com/mdma-backend/mdma-backend/internal/types/permission"
+)
+
+// maxFileSize limits the size of an imported CSV file, which is kept in the
+// database until the import is deleted with DELETE /data-imports/{id}.
+const maxFileSize = 512 << 20
+
+type DataImportStore interface {
+	DataImportByID(types.DataImportID) (types.DataImport, error)
+	DataImports() ([]types.DataImport, error)
+	DataImportErrors(types.DataImportID) ([]types.DataImportError, error)
+	CreateDataImport(*types.DataImport) error
+	DeleteDataImport(types.DataImportID) error
+}
+
+// DataImporter runs imports in the background.
+type DataImporter interface {
+	Start(types.DataImportID) error
+}
+
+type service struct {
+	handler         http.Handler
+	dataImportStore DataImportStore
+	dataImporter    DataImporter
+}
+
+func NewService(dataImportStore DataImportStore, dataImporter DataImporter) http.Handler {
+	r := chi.NewRouter()
+	s := service{
+		handler:         r,
+		dataImportStore: dataImportStore,
+		dataImporter:    dataImporter,
+	}
+
+	r.Get("/", auth.RestrictHandlerFunc(s.getDataImports(), permission.DataRead))
+	r.Get("/{id}", auth.RestrictHandlerFunc(s.getDataImport(), permission.DataRead))
+	r.Get("/{id}/errors", auth.RestrictHandlerFunc(s.getDataImportErrors(), permission.DataRead))
+	r.Post("/", auth.RestrictHandlerFunc(s.postDataImport(), permission.DataCreate))
+	r.Post("/{id}/resume", auth.RestrictHandlerFunc(s.resumeDataImport(), permission.DataCreate))
+	r.Delete("/{id}", auth.RestrictHandlerFunc(s.deleteDataImport(), permission.DataDelete))
+
+	return s
+}
+
+func (s service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+func (s service) getDataImports() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dataImports, err := s.dataImportStore.DataImports()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		render.JSON(w, r, dataImports)
+	}
+}
+
+func (s service) getDataImport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dataImportID, err := types.IDFromString[types.DataImportID](chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		dataImport, err := s.dataImportStore.DataImportByID(dataImportID)
+		if errors.Is(err, types.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		render.JSON(w, r, dataImport)
+	}
+}
+
+func (s service) getDataImportErrors() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dataImportID, err := types.IDFromString[types.DataImportID](chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := s.dataImportStore.DataImportByID(dataImportID); errors.Is(err, types.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		importErrors, err := s.dataImportStore.DataImportErrors(dataImportID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if importErrors == nil {
+			importErrors = []types.DataImportError{}
+		}
+
+		render.JSON(w, r, importErrors)
+	}
+}
+
+// postDataImport creates an import of the CSV file in the body and starts
+// it. The columns are mapped by query parameters, defaulting to the columns
+// of the CSV export.
+func (s service) postDataImport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		dataImport := types.DataImport{
+			Name:    query.Get("name"),
+			Mapping: types.DefaultDataImportMapping(),
+		}
+		if dataImport.Name == "" {
+			dataImport.Name = "import"
+		}
+
+		// Imports of roles scoped to areas reject rows of other mesh nodes
+		if info, ok := r.Context().Value(auth.AccountInfoCtxKey).(types.AccountInfo); ok && info.Role.IsScoped() {
+			dataImport.AreaIDs = info.Role.AreaIDs
+		}
+
+		if header := query.Get("header"); header != "" {
+			var err error
+			dataImport.Mapping.Header, err = strconv.ParseBool(header)
+			if err != nil {
+				http.Error(w, "header must be true or false", http.StatusBadRequest)
+				return
+			}
+		}
+
+		for param, column := range map[string]*string{
+			"meshNodeUUID": &dataImport.Mapping.MeshNodeUUID,
+			"type":         &dataImport.Mapping.Type,
+			"measuredAt":   &dataImport.Mapping.MeasuredAt,
+			"value":        &dataImport.Mapping.Value,
+			"timeFormat":   &dataImport.Mapping.TimeFormat,
+		} {
+			if value := query.Get(param); value != "" {
+				*column = value
+			}
+		}
+
+		if err := dataImport.Mapping.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		dataImport.Data, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxFileSize))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := s.dataImportStore.CreateDataImport(&dataImport); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := s.dataImporter.Start(dataImport.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		render.Status(r, http.StatusAccepted)
+		render.JSON(w, r, dataImport)
+	}
+}
+
+// resumeDataImport continues a failed or interrupted import after the last
+// processed row.
+func (s service) resumeDataImport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dataImportID, err := types.IDFromString[types.DataImportID](chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		dataImport, err := s.dataImportStore.DataImportByID(dataImportID)
+		if errors.Is(err, types.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if dataImport.Status == types.DataImportCompleted {
+			http.Error(w, importer.ErrCompleted.Error(), http.StatusConflict)
+			return
+		}
+
+		if err := s.dataImporter.Start(dataImportID); errors.Is(err, importer.ErrRunning) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		render.Status(r, http.StatusAccepted)
+		render.JSON(w, r, dataImport)
+	}
+}
+
+// deleteDataImport deletes an import that is not running together with its
+// file. The data it imported is kept.
+func (s service) deleteDataImport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dataImportID, err := types.IDFromString[types.DataImportID](chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := s.dataImportStore.DeleteDataImport(dataImportID); errors.Is(err, types.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if errors.Is(err, types.ErrConflict) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
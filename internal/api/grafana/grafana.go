@@ -0,0 +1,619 @@
+package grafana
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/mdma-backend/mdma-backend/internal/api/auth"
+	"github.com/mdma-backend/mdma-backend/internal/api/data"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+	"github.com/mdma-backend/mdma-backend/internal/types/permission"
+)
+
+const (
+	defaultAggregateFunction = "average"
+	defaultMaxDataPoints     = 1000
+
+	// meshNodeTag is the key of ad hoc filters and of the payload option
+	// that select mesh nodes.
+	meshNodeTag = "meshNode"
+)
+
+type DataStore interface {
+	GetAggregatedDataByMeshNode(dataType string, meshNodeUUIDs []string, startTime time.Time, endTime time.Time, sampleTime time.Duration, sampleCount int, aggregateFunction string) (map[string]data.AggregatedData, error)
+	DataTypes() ([]types.DataType, error)
+}
+
+type MeshNodeStore interface {
+	MeshNodes() ([]types.MeshNode, error)
+	MeshNodeUpdates() ([]types.MeshNodeUpdate, error)
+}
+
+type service struct {
+	handler       http.Handler
+	dataStore     DataStore
+	meshNodeStore MeshNodeStore
+}
+
+// NewService returns a service that implements the contract of the Grafana
+// JSON datasource. Metrics are data types, queries return the aggregated
+// time series of every mesh node and annotations are events of mesh nodes.
+func NewService(dataStore DataStore, meshNodeStore MeshNodeStore) http.Handler {
+	r := chi.NewRouter()
+	s := service{
+		handler:       r,
+		dataStore:     dataStore,
+		meshNodeStore: meshNodeStore,
+	}
+
+	r.Get("/", auth.RestrictHandlerFunc(s.getHealth(), permission.DataRead))
+	r.Post("/search", auth.RestrictHandlerFunc(s.postSearch(), permission.DataRead))
+	r.Post("/metrics", auth.RestrictHandlerFunc(s.postMetrics(), permission.DataRead))
+	r.Post("/metric-payload-options", auth.RestrictHandlerFunc(s.postMetricPayloadOptions(), permission.DataRead, permission.MeshNodeRead))
+	r.Post("/query", auth.RestrictHandlerFunc(s.postQuery(), permission.DataRead, permission.MeshNodeRead))
+	r.Post("/annotations", auth.RestrictHandlerFunc(s.postAnnotations(), permission.MeshNodeRead))
+	r.Post("/tag-keys", auth.RestrictHandlerFunc(s.postTagKeys(), permission.DataRead))
+	r.Post("/tag-values", auth.RestrictHandlerFunc(s.postTagValues(), permission.MeshNodeRead))
+
+	return s
+}
+
+func (s service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// getHealth is used by Grafana to test the datasource.
+func (s service) getHealth() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// postSearch returns the names of all data types.
+func (s service) postSearch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dataTypes, err := s.dataStore.DataTypes()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		names := make([]string, 0, len(dataTypes))
+		for _, t := range dataTypes {
+			names = append(names, t.Name)
+		}
+
+		render.JSON(w, r, names)
+	}
+}
+
+type metric struct {
+	Label    string          `json:"label"`
+	Value    string          `json:"value"`
+	Payloads []metricPayload `json:"payloads"`
+}
+
+type metricPayload struct {
+	Name         string   `json:"name"`
+	Label        string   `json:"label"`
+	Type         string   `json:"type"`
+	Placeholder  string   `json:"placeholder,omitempty"`
+	ReloadMetric bool     `json:"reloadMetric,omitempty"`
+	Width        int      `json:"width,omitempty"`
+	Options      []option `json:"options,omitempty"`
+}
+
+type option struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// postMetrics returns all data types with the options of their queries.
+func (s service) postMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dataTypes, err := s.dataStore.DataTypes()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		aggregateFunctions := []option{}
+		for _, f := range []string{"average", "minimum", "maximum", "sum", "count", "range", "median"} {
+			aggregateFunctions = append(aggregateFunctions, option{Label: f, Value: f})
+		}
+
+		fills := []option{}
+		for _, f := range []data.Fill{data.FillNull, data.FillZero, data.FillPrevious, data.FillLinear} {
+			fills = append(fills, option{Label: string(f), Value: string(f)})
+		}
+
+		metrics := make([]metric, 0, len(dataTypes))
+		for _, t := range dataTypes {
+			label := t.Name
+			if t.Unit != "" {
+				label += " (" + t.Unit + ")"
+			}
+
+			metrics = append(metrics, metric{
+				Label: label,
+				Value: t.Name,
+				Payloads: []metricPayload{
+					{
+						Name:    "aggregateFunction",
+						Label:   "Aggregate function",
+						Type:    "select",
+						Options: aggregateFunctions,
+					},
+					{
+						Name:    "fill",
+						Label:   "Fill",
+						Type:    "select",
+						Options: fills,
+					},
+					{
+						Name:        meshNodeTag,
+						Label:       "Mesh nodes",
+						Type:        "multi-select",
+						Placeholder: "all mesh nodes",
+					},
+				},
+			})
+		}
+
+		render.JSON(w, r, metrics)
+	}
+}
+
+// postMetricPayloadOptions returns the mesh nodes that can be selected in
+// a query.
+func (s service) postMetricPayloadOptions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		meshNodeUUIDs, err := s.meshNodeUUIDs(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		options := make([]option, 0, len(meshNodeUUIDs))
+		for _, uuid := range meshNodeUUIDs {
+			options = append(options, option{Label: uuid, Value: uuid})
+		}
+
+		render.JSON(w, r, options)
+	}
+}
+
+type timeRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type queryRequest struct {
+	Range         timeRange     `json:"range"`
+	IntervalMs    int64         `json:"intervalMs"`
+	MaxDataPoints int           `json:"maxDataPoints"`
+	Targets       []target      `json:"targets"`
+	AdhocFilters  []adhocFilter `json:"adhocFilters"`
+}
+
+type target struct {
+	Target  string       `json:"target"`
+	RefID   string       `json:"refId"`
+	Type    string       `json:"type"`
+	Hide    bool         `json:"hide"`
+	Payload queryPayload `json:"payload"`
+}
+
+// queryPayload holds the options of a query. Mesh nodes are accepted as a
+// single UUID or a list of UUIDs.
+type queryPayload struct {
+	AggregateFunction string      `json:"aggregateFunction"`
+	Fill              data.Fill   `json:"fill"`
+	MeshNodes         stringSlice `json:"meshNode"`
+}
+
+type stringSlice []string
+
+func (s *stringSlice) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err == nil {
+		if str != "" {
+			*s = []string{str}
+		}
+		return nil
+	}
+
+	return json.Unmarshal(b, (*[]string)(s))
+}
+
+type adhocFilter struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+type timeSeries struct {
+	Target     string           `json:"target"`
+	RefID      string           `json:"refId,omitempty"`
+	Datapoints [][2]interface{} `json:"datapoints"`
+}
+
+type table struct {
+	Type    string          `json:"type"`
+	RefID   string          `json:"refId,omitempty"`
+	Columns []tableColumn   `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+type tableColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// postQuery returns a time series per mesh node for every target. The
+// interval of the samples is the interval of the query, but there are no
+// more samples than the maximum number of data points. Mesh nodes without
+// any sample are left out.
+func (s service) postQuery() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var query queryRequest
+		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !query.Range.From.Before(query.Range.To) {
+			http.Error(w, "range must end after it starts", http.StatusBadRequest)
+			return
+		}
+
+		maxDataPoints := query.MaxDataPoints
+		if maxDataPoints <= 0 {
+			maxDataPoints = defaultMaxDataPoints
+		}
+		sampleTime := time.Duration(query.IntervalMs) * time.Millisecond
+		if minSampleTime := query.Range.To.Sub(query.Range.From) / time.Duration(maxDataPoints); sampleTime < minSampleTime {
+			sampleTime = minSampleTime
+		}
+		if sampleTime < time.Millisecond {
+			sampleTime = time.Millisecond
+		}
+
+		var filteredMeshNodes []string
+		for _, f := range query.AdhocFilters {
+			if f.Key == meshNodeTag && f.Operator == "=" {
+				filteredMeshNodes = append(filteredMeshNodes, f.Value)
+			}
+		}
+
+		allMeshNodes, err := s.meshNodeUUIDs(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := []interface{}{}
+		for _, t := range query.Targets {
+			if t.Hide || t.Target == "" {
+				continue
+			}
+
+			aggregateFunction := t.Payload.AggregateFunction
+			if aggregateFunction == "" {
+				aggregateFunction = defaultAggregateFunction
+			}
+			if !data.IsValidAggregateFunction(aggregateFunction) {
+				http.Error(w, fmt.Sprintf("unknown aggregate function %s", aggregateFunction), http.StatusBadRequest)
+				return
+			}
+
+			fill := t.Payload.Fill
+			if fill == "" {
+				fill = data.FillNull
+			}
+			if !data.IsValidFill(fill) {
+				http.Error(w, "fill must be one of null, zero, previous or linear", http.StatusBadRequest)
+				return
+			}
+
+			meshNodeUUIDs := inScope(r, t.Payload.MeshNodes)
+			if len(t.Payload.MeshNodes) == 0 {
+				meshNodeUUIDs = allMeshNodes
+			}
+			if len(filteredMeshNodes) > 0 {
+				meshNodeUUIDs = intersect(meshNodeUUIDs, filteredMeshNodes)
+			}
+
+			series, err := s.querySeries(t, meshNodeUUIDs, query.Range, sampleTime, aggregateFunction, fill)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if t.Type == "table" {
+				response = append(response, seriesTable(t.RefID, series))
+				continue
+			}
+			for _, ts := range series {
+				response = append(response, ts)
+			}
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// querySeries aggregates the data of a target for each mesh node.
+func (s service) querySeries(t target, meshNodeUUIDs []string, tr timeRange, sampleTime time.Duration, aggregateFunction string, fill data.Fill) ([]timeSeries, error) {
+	if len(meshNodeUUIDs) == 0 {
+		return nil, nil
+	}
+
+	aggregatedDataByMeshNode, err := s.dataStore.GetAggregatedDataByMeshNode(t.Target, meshNodeUUIDs, tr.From, tr.To, sampleTime, 0, aggregateFunction)
+	if err != nil {
+		return nil, err
+	}
+
+	var series []timeSeries
+	for _, meshNodeUUID := range meshNodeUUIDs {
+		// The store returns the canonical lower case form of the UUIDs
+		aggregatedData, ok := aggregatedDataByMeshNode[strings.ToLower(meshNodeUUID)]
+		if !ok || !hasValue(aggregatedData.Samples) {
+			continue
+		}
+		data.FillSamples(aggregatedData.Samples, fill)
+
+		ts := timeSeries{
+			Target:     t.Target + " " + meshNodeUUID,
+			RefID:      t.RefID,
+			Datapoints: make([][2]interface{}, 0, len(aggregatedData.Samples)),
+		}
+		for _, sample := range aggregatedData.Samples {
+			startAt, err := data.ParseSampleTime(sample.IntervalStartAt)
+			if err != nil {
+				return nil, err
+			}
+			ts.Datapoints = append(ts.Datapoints, [2]interface{}{sampleValue(sample), startAt.UnixMilli()})
+		}
+		series = append(series, ts)
+	}
+
+	return series, nil
+}
+
+// meshNodeUUIDs returns the UUIDs of all mesh nodes in the scope of the
+// role of the request.
+func (s service) meshNodeUUIDs(r *http.Request) ([]string, error) {
+	meshNodes, err := s.meshNodeStore.MeshNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	uuids := make([]string, 0, len(meshNodes))
+	for _, n := range meshNodes {
+		uuids = append(uuids, n.UUID.String())
+	}
+
+	return inScope(r, uuids), nil
+}
+
+// inScope leaves out the mesh nodes outside of the scope of the role of the
+// request.
+func inScope(r *http.Request, meshNodeUUIDs []string) []string {
+	scope, scoped := auth.MeshNodesInScope(r)
+	if !scoped {
+		return meshNodeUUIDs
+	}
+
+	var result []string
+	for _, uuid := range meshNodeUUIDs {
+		if scope[strings.ToLower(uuid)] {
+			result = append(result, uuid)
+		}
+	}
+
+	return result
+}
+
+// seriesTable converts time series to a table with a row per data point.
+func seriesTable(refID string, series []timeSeries) table {
+	t := table{
+		Type:  "table",
+		RefID: refID,
+		Columns: []tableColumn{
+			{Text: "Time", Type: "time"},
+			{Text: "Mesh Node", Type: "string"},
+			{Text: "Value", Type: "number"},
+		},
+		Rows: [][]interface{}{},
+	}
+
+	for _, ts := range series {
+		for _, p := range ts.Datapoints {
+			t.Rows = append(t.Rows, []interface{}{p[1], ts.Target, p[0]})
+		}
+	}
+
+	return t
+}
+
+func hasValue(samples []data.Sample) bool {
+	for _, sample := range samples {
+		if sample.Value != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sampleValue returns the value of a sample as number or nil if it has no
+// numeric value.
+func sampleValue(sample data.Sample) interface{} {
+	if sample.Value == nil {
+		return nil
+	}
+
+	v, err := strconv.ParseFloat(*sample.Value, 64)
+	if err != nil {
+		return nil
+	}
+
+	return v
+}
+
+func intersect(a, b []string) []string {
+	inB := map[string]bool{}
+	for _, s := range b {
+		inB[s] = true
+	}
+
+	var result []string
+	for _, s := range a {
+		if inB[s] {
+			result = append(result, s)
+		}
+	}
+
+	return result
+}
+
+type annotationRequest struct {
+	Range      timeRange              `json:"range"`
+	Annotation map[string]interface{} `json:"annotation"`
+}
+
+type annotation struct {
+	Annotation map[string]interface{} `json:"annotation,omitempty"`
+	Time       int64                  `json:"time"`
+	Title      string                 `json:"title"`
+	Text       string                 `json:"text"`
+	Tags       []string               `json:"tags"`
+}
+
+// postAnnotations returns the events of mesh nodes in the range: mesh
+// nodes that were added or last changed and updates that were released.
+func (s service) postAnnotations() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req annotationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		meshNodes, err := s.meshNodeStore.MeshNodes()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		updates, err := s.meshNodeStore.MeshNodeUpdates()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		inRange := func(t time.Time) bool {
+			return !t.Before(req.Range.From) && !t.After(req.Range.To)
+		}
+
+		annotations := []annotation{}
+		for _, n := range meshNodes {
+			if !auth.MeshNodeInScope(r, n.UUID.String()) {
+				continue
+			}
+
+			if inRange(n.CreatedAt) {
+				annotations = append(annotations, annotation{
+					Annotation: req.Annotation,
+					Time:       n.CreatedAt.UnixMilli(),
+					Title:      "Mesh node added",
+					Text:       n.UUID.String(),
+					Tags:       []string{"mesh-node", "created", n.UUID.String()},
+				})
+			}
+
+			if n.UpdatedAt != nil && inRange(*n.UpdatedAt) {
+				annotations = append(annotations, annotation{
+					Annotation: req.Annotation,
+					Time:       n.UpdatedAt.UnixMilli(),
+					Title:      "Mesh node changed",
+					Text:       n.UUID.String(),
+					Tags:       []string{"mesh-node", "updated", n.UUID.String()},
+				})
+			}
+		}
+
+		for _, u := range updates {
+			if inRange(u.CreatedAt) {
+				annotations = append(annotations, annotation{
+					Annotation: req.Annotation,
+					Time:       u.CreatedAt.UnixMilli(),
+					Title:      "Mesh node update released",
+					Text:       "Version " + u.Version,
+					Tags:       []string{"mesh-node-update", u.Version},
+				})
+			}
+		}
+
+		sort.Slice(annotations, func(i, j int) bool {
+			return annotations[i].Time < annotations[j].Time
+		})
+
+		render.JSON(w, r, annotations)
+	}
+}
+
+type tagKey struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type tagValue struct {
+	Text string `json:"text"`
+}
+
+// postTagKeys returns the keys of ad hoc filters.
+func (s service) postTagKeys() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		render.JSON(w, r, []tagKey{{Type: "string", Text: meshNodeTag}})
+	}
+}
+
+// postTagValues returns the values of an ad hoc filter.
+func (s service) postTagValues() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Key string `json:"key"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		values := []tagValue{}
+		if req.Key != meshNodeTag {
+			render.JSON(w, r, values)
+			return
+		}
+
+		meshNodeUUIDs, err := s.meshNodeUUIDs(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		for _, uuid := range meshNodeUUIDs {
+			values = append(values, tagValue{Text: uuid})
+		}
+
+		render.JSON(w, r, values)
+	}
+}
@@ -0,0 +1,218 @@
+package influx
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mdma-backend/mdma-backend/internal/api/auth"
+	"github.com/mdma-backend/mdma-backend/internal/api/data"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+	"github.com/mdma-backend/mdma-backend/internal/types/permission"
+)
+
+// maxBodySize limits the size of a single write request, before and after
+// decompressing it.
+const maxBodySize = 32 << 20
+
+// valueField is the field whose data type is the measurement alone.
+const valueField = "value"
+
+type DataStore interface {
+	CreateData([]data.Data) error
+}
+
+// DataPublisher is notified about all data written through the service.
+type DataPublisher interface {
+	Publish(...data.Data)
+}
+
+type RoleStore interface {
+	RoleByServiceAccountID(types.ServiceAccountID) (types.Role, error)
+}
+
+type service struct {
+	handler       http.Handler
+	dataStore     DataStore
+	dataPublisher DataPublisher
+	tokenService  types.TokenService
+	roleStore     RoleStore
+	meshNodeTag   string
+}
+
+// NewService returns a service compatible with the write endpoints of the
+// InfluxDB v1 (/write) and v2 (/api/v2/write) APIs. The value of the tag
+// meshNodeTag of a point is the UUID of its mesh node and its measurement
+// and field make up the data type, like weather_temperature. The field
+// value only uses the measurement as data type.
+// Clients authenticate with the token of a service account, which is
+// accepted as Token or Bearer authorization, as basic auth password or as
+// p parameter.
+func NewService(store DataStore, publisher DataPublisher, tokenService types.TokenService, roleStore RoleStore, meshNodeTag string) http.Handler {
+	r := chi.NewRouter()
+	s := service{
+		handler:       r,
+		dataStore:     store,
+		dataPublisher: publisher,
+		tokenService:  tokenService,
+		roleStore:     roleStore,
+		meshNodeTag:   meshNodeTag,
+	}
+
+	r.Post("/write", s.authenticate(auth.RestrictHandlerFunc(s.postWrite(), permission.DataCreate)))
+	r.Post("/api/v2/write", s.authenticate(auth.RestrictHandlerFunc(s.postWrite(), permission.DataCreate)))
+
+	return s
+}
+
+func (s service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// authenticate adds the account of the service account token of a request
+// to its context.
+func (s service) authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := s.tokenService.Validate(tokenFromRequest(r))
+		if err != nil {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		if claims.AccountType != types.ServiceAccountType {
+			http.Error(w, "not a service account", http.StatusUnauthorized)
+			return
+		}
+
+		role, err := s.roleStore.RoleByServiceAccountID(types.ServiceAccountID(claims.AccountID))
+		if err != nil {
+			http.Error(w, "account has no role", http.StatusBadRequest)
+			return
+		}
+
+		info := types.AccountInfo{
+			AccountType: claims.AccountType,
+			AccountID:   claims.AccountID,
+			Role:        role,
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), auth.AccountInfoCtxKey, info)))
+	}
+}
+
+func tokenFromRequest(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+	for _, scheme := range []string{"Token ", "Bearer "} {
+		if strings.HasPrefix(authorization, scheme) {
+			return strings.TrimPrefix(authorization, scheme)
+		}
+	}
+
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+
+	return r.URL.Query().Get("p")
+}
+
+func (s service) postWrite() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		precision, ok := precisions[r.URL.Query().Get("precision")]
+		if !ok {
+			http.Error(w, "invalid precision", http.StatusBadRequest)
+			return
+		}
+
+		var body io.Reader = http.MaxBytesReader(w, r.Body, maxBodySize)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer gz.Close()
+			// The decompressed body is limited as well, since a small
+			// compressed body can expand to any size
+			body = http.MaxBytesReader(w, gz, maxBodySize)
+		}
+
+		b, err := io.ReadAll(body)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		points, err := parseLines(string(b), precision)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		meshNodeData, err := s.pointData(points, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for _, d := range meshNodeData {
+			if !auth.MeshNodeInScope(r, d.MeshNodeUUID) {
+				http.Error(w, fmt.Sprintf("mesh node %s is not in the areas of the role", d.MeshNodeUUID), http.StatusUnauthorized)
+				return
+			}
+		}
+
+		if err := s.dataStore.CreateData(meshNodeData); errors.Is(err, types.ErrInvalidValue) || errors.Is(err, types.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.dataPublisher.Publish(meshNodeData...)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// pointData converts every field of the points to data. Points without a
+// timestamp are measured at receivedAt.
+func (s service) pointData(points []point, receivedAt time.Time) ([]data.Data, error) {
+	var meshNodeData []data.Data
+	for _, p := range points {
+		meshNodeUUID, err := types.UUIDFromString(p.tags[s.meshNodeTag])
+		if err != nil {
+			return nil, fmt.Errorf("measurement %s: tag %s is not a mesh node uuid", p.measurement, s.meshNodeTag)
+		}
+
+		measuredAt := receivedAt
+		if p.time != nil {
+			measuredAt = *p.time
+		}
+
+		for _, f := range p.fields {
+			dataType := p.measurement
+			if f.key != valueField {
+				dataType += "_" + f.key
+			}
+
+			meshNodeData = append(meshNodeData, data.Data{
+				MeshNodeUUID: meshNodeUUID.String(),
+				Type:         dataType,
+				MeasuredAt:   measuredAt.Format(time.RFC3339Nano),
+				Value:        f.value,
+			})
+		}
+	}
+
+	return meshNodeData, nil
+}
@@ -0,0 +1,183 @@
+package influx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// point is a parsed line of the line protocol. The values of the fields are
+// formatted like the values of data.
+type point struct {
+	measurement string
+	tags        map[string]string
+	fields      []field
+	time        *time.Time
+}
+
+type field struct {
+	key   string
+	value string
+}
+
+// precisions maps the precision parameter of the v1 and v2 write APIs to
+// the unit of timestamps.
+var precisions = map[string]time.Duration{
+	"":   time.Nanosecond,
+	"n":  time.Nanosecond,
+	"ns": time.Nanosecond,
+	"u":  time.Microsecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// parseLines parses the lines of a body in line protocol. Empty lines and
+// comments are skipped.
+func parseLines(body string, precision time.Duration) ([]point, error) {
+	var points []point
+	for i, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		p, err := parseLine(line, precision)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		points = append(points, p)
+	}
+
+	return points, nil
+}
+
+// parseLine parses a line of the form
+// measurement[,tag=value...] field=value[,field=value...] [timestamp].
+func parseLine(line string, precision time.Duration) (point, error) {
+	sections := splitUnescaped(line, ' ', true)
+	if len(sections) < 2 || len(sections) > 3 {
+		return point{}, errors.New("expected measurement, fields and optional timestamp")
+	}
+
+	series := splitUnescaped(sections[0], ',', false)
+	p := point{
+		measurement: unescape(series[0]),
+		tags:        map[string]string{},
+	}
+	if p.measurement == "" {
+		return point{}, errors.New("missing measurement")
+	}
+
+	for _, tag := range series[1:] {
+		kv := splitUnescaped(tag, '=', false)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return point{}, fmt.Errorf("invalid tag %q", tag)
+		}
+		p.tags[unescape(kv[0])] = unescape(kv[1])
+	}
+
+	for _, f := range splitUnescaped(sections[1], ',', true) {
+		kv := splitUnescaped(f, '=', true)
+		if len(kv) < 2 || kv[0] == "" {
+			return point{}, fmt.Errorf("invalid field %q", f)
+		}
+
+		// The first unescaped equals sign separates the key from the value
+		key := unescape(kv[0])
+		value, err := parseFieldValue(f[len(kv[0])+1:])
+		if err != nil {
+			return point{}, fmt.Errorf("field %s: %w", key, err)
+		}
+		p.fields = append(p.fields, field{key: key, value: value})
+	}
+
+	if len(sections) == 3 {
+		ts, err := strconv.ParseInt(sections[2], 10, 64)
+		// Timestamps in nanoseconds have to fit into an int64
+		if err != nil || ts > math.MaxInt64/int64(precision) || ts < math.MinInt64/int64(precision) {
+			return point{}, fmt.Errorf("invalid timestamp %q", sections[2])
+		}
+		t := time.Unix(0, ts*int64(precision)).UTC()
+		p.time = &t
+	}
+
+	return p, nil
+}
+
+// parseFieldValue parses a float, an integer (suffix i), an unsigned integer
+// (suffix u), a quoted string or a boolean.
+func parseFieldValue(s string) (string, error) {
+	switch {
+	case s == "":
+		return "", errors.New("missing value")
+	case s[0] == '"':
+		if len(s) < 2 || s[len(s)-1] != '"' {
+			return "", errors.New("unterminated string")
+		}
+		r := strings.NewReplacer(`\"`, `"`, `\\`, `\`)
+		return r.Replace(s[1 : len(s)-1]), nil
+	case strings.HasSuffix(s, "i"):
+		i, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid integer %q", s)
+		}
+		return strconv.FormatInt(i, 10), nil
+	case strings.HasSuffix(s, "u"):
+		u, err := strconv.ParseUint(s[:len(s)-1], 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid unsigned integer %q", s)
+		}
+		return strconv.FormatUint(u, 10), nil
+	}
+
+	switch s {
+	case "t", "T", "true", "True", "TRUE":
+		return "true", nil
+	case "f", "F", "false", "False", "FALSE":
+		return "false", nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid value %q", s)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64), nil
+}
+
+// splitUnescaped splits s at every sep that is not escaped with a backslash
+// and, if quoted is set, not inside a double quoted string.
+func splitUnescaped(s string, sep byte, quoted bool) []string {
+	var parts []string
+	var inQuotes bool
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quoted && s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// unescape removes the backslashes of escaped commas, equals signs and
+// spaces in measurements, tags and field keys.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	r := strings.NewReplacer(`\,`, ",", `\=`, "=", `\ `, " ", `\\`, `\`)
+	return r.Replace(s)
+}
@@ -0,0 +1,131 @@
+package influx
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	at := func(ns int64) *time.Time {
+		t := time.Unix(0, ns).UTC()
+		return &t
+	}
+
+	tests := []struct {
+		name      string
+		line      string
+		precision time.Duration
+		want      point
+		err       bool
+	}{
+		{
+			name:      "fields of every kind",
+			line:      `weather,node=0cc56633-05ae-4cc3-8f71-801f429caeca temperature=21.5,count=3i,total=4u,open=t,status="ok" 1700000000000000000`,
+			precision: time.Nanosecond,
+			want: point{
+				measurement: "weather",
+				tags:        map[string]string{"node": "0cc56633-05ae-4cc3-8f71-801f429caeca"},
+				fields: []field{
+					{key: "temperature", value: "21.5"},
+					{key: "count", value: "3"},
+					{key: "total", value: "4"},
+					{key: "open", value: "true"},
+					{key: "status", value: "ok"},
+				},
+				time: at(1700000000000000000),
+			},
+		},
+		{
+			name:      "without timestamp",
+			line:      `weather temperature=1e1`,
+			precision: time.Nanosecond,
+			want: point{
+				measurement: "weather",
+				tags:        map[string]string{},
+				fields:      []field{{key: "temperature", value: "10"}},
+			},
+		},
+		{
+			name:      "escaped characters",
+			line:      `my\ weather,my\,tag=a\=b my\ field="say \"hi\", then go",other=F`,
+			precision: time.Nanosecond,
+			want: point{
+				measurement: "my weather",
+				tags:        map[string]string{"my,tag": "a=b"},
+				fields: []field{
+					{key: "my field", value: `say "hi", then go`},
+					{key: "other", value: "false"},
+				},
+			},
+		},
+		{
+			name:      "precision in seconds",
+			line:      `weather temperature=1 1700000000`,
+			precision: time.Second,
+			want: point{
+				measurement: "weather",
+				tags:        map[string]string{},
+				fields:      []field{{key: "temperature", value: "1"}},
+				time:        at(1700000000000000000),
+			},
+		},
+		{
+			name:      "largest timestamp in hours",
+			line:      `weather temperature=1 2562047`,
+			precision: time.Hour,
+			want: point{
+				measurement: "weather",
+				tags:        map[string]string{},
+				fields:      []field{{key: "temperature", value: "1"}},
+				time:        at(2562047 * int64(time.Hour)),
+			},
+		},
+		{name: "timestamp overflowing in hours", line: `weather temperature=1 2562048`, precision: time.Hour, err: true},
+		{name: "negative timestamp overflowing in seconds", line: `weather temperature=1 -9223372037`, precision: time.Second, err: true},
+		{name: "timestamp overflowing int64", line: `weather temperature=1 9223372036854775808`, precision: time.Nanosecond, err: true},
+		{name: "invalid timestamp", line: `weather temperature=1 now`, precision: time.Nanosecond, err: true},
+		{name: "missing fields", line: `weather`, precision: time.Nanosecond, err: true},
+		{name: "too many sections", line: `weather temperature=1 1 2`, precision: time.Nanosecond, err: true},
+		{name: "missing measurement", line: `,node=a temperature=1`, precision: time.Nanosecond, err: true},
+		{name: "tag without value", line: `weather,node= temperature=1`, precision: time.Nanosecond, err: true},
+		{name: "field without value", line: `weather temperature=`, precision: time.Nanosecond, err: true},
+		{name: "field without key", line: `weather =1`, precision: time.Nanosecond, err: true},
+		{name: "unterminated string", line: `weather status="ok`, precision: time.Nanosecond, err: true},
+		{name: "invalid integer", line: `weather count=1.5i`, precision: time.Nanosecond, err: true},
+		{name: "negative unsigned integer", line: `weather count=-1u`, precision: time.Nanosecond, err: true},
+		{name: "invalid float", line: `weather temperature=warm`, precision: time.Nanosecond, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLine(tt.line, tt.precision)
+			if (err != nil) != tt.err {
+				t.Fatalf("parseLine(%q) error = %v, want error %v", tt.line, err, tt.err)
+			}
+			if tt.err {
+				return
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLine(%q) = %+v, want %+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLines(t *testing.T) {
+	body := "# comment\n\nweather temperature=1\n  weather temperature=2  \n"
+
+	points, err := parseLines(body, time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("parseLines() returned %d points, want 2", len(points))
+	}
+
+	if _, err := parseLines("weather temperature=1\nweather", time.Nanosecond); err == nil || err.Error() != `line 2: expected measurement, fields and optional timestamp` {
+		t.Errorf("parseLines() error = %v, want the error of line 2", err)
+	}
+}
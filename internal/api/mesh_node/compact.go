@@ -0,0 +1,370 @@
+package mesh_node
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gofrs/uuid"
+	"github.com/mdma-backend/mdma-backend/internal/api/data"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The compact encodings of data are described in api/mesh_node_data.proto.
+const (
+	cborContentType     = "application/cbor"
+	protobufContentType = "application/x-protobuf"
+)
+
+// maxDelta is the largest delta in seconds that fits into a time.Duration.
+const maxDelta = int64(math.MaxInt64 / time.Second)
+
+// series are measurements of one data type in the compact encodings.
+type series struct {
+	typeID types.DataTypeID
+	name   string
+	start  int64
+	deltas []int64
+	values []string
+	uuids  [][]byte
+}
+
+// cborSeries uses the field numbers of the Protobuf schema as keys.
+type cborSeries struct {
+	TypeID       uint64        `cbor:"1,keyasint,omitempty"`
+	Type         string        `cbor:"2,keyasint,omitempty"`
+	Start        int64         `cbor:"3,keyasint,omitempty"`
+	Deltas       []int64       `cbor:"4,keyasint,omitempty"`
+	Values       []interface{} `cbor:"5,keyasint,omitempty"`
+	StringValues []string      `cbor:"6,keyasint,omitempty"`
+	UUIDs        [][]byte      `cbor:"7,keyasint,omitempty"`
+}
+
+type cborUpload struct {
+	Series []cborSeries `cbor:"1,keyasint,omitempty"`
+}
+
+// isCompact reports whether the body of the request is in a compact
+// encoding.
+func isCompact(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case cborContentType, protobufContentType, "application/protobuf", "application/vnd.google.protobuf":
+		return true
+	default:
+		return false
+	}
+}
+
+// decodeCompact decodes a body in a compact encoding into data of the mesh
+// node. Measurements of a series without start time are measured relative
+// to receivedAt. The size of the body has to be limited by the caller.
+func (s service) decodeCompact(r *http.Request, meshNodeUUID types.UUID, receivedAt time.Time) ([]data.Data, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var ss []series
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == cborContentType {
+		ss, err = decodeCBOR(body)
+	} else {
+		ss, err = decodeProtobuf(body)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", types.ErrInvalidValue, err)
+	}
+
+	// Type names are looked up once per request
+	typeNames := map[types.DataTypeID]string{}
+	var meshNodeData []data.Data
+	for _, series := range ss {
+		if series.name == "" {
+			name, ok := typeNames[series.typeID]
+			if !ok {
+				dataType, err := s.dataTypeByID(series.typeID)
+				if err != nil {
+					return nil, err
+				}
+				name = dataType.Name
+				typeNames[series.typeID] = name
+			}
+			series.name = name
+		}
+
+		dd, err := series.data(meshNodeUUID, receivedAt)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", types.ErrInvalidValue, err)
+		}
+		meshNodeData = append(meshNodeData, dd...)
+	}
+
+	return meshNodeData, nil
+}
+
+// data expands the series into data of the mesh node.
+func (s series) data(meshNodeUUID types.UUID, receivedAt time.Time) ([]data.Data, error) {
+	if len(s.deltas) != 0 && len(s.deltas) != len(s.values) {
+		return nil, fmt.Errorf("series of %s has %d values but %d deltas", s.name, len(s.values), len(s.deltas))
+	}
+
+	if len(s.uuids) != 0 && len(s.uuids) != len(s.values) {
+		return nil, fmt.Errorf("series of %s has %d values but %d uuids", s.name, len(s.values), len(s.uuids))
+	}
+
+	measuredAt := time.Unix(s.start, 0)
+	if s.start == 0 {
+		measuredAt = receivedAt.Truncate(time.Second)
+	}
+
+	dd := make([]data.Data, 0, len(s.values))
+	for i, value := range s.values {
+		if len(s.deltas) != 0 {
+			delta := s.deltas[i]
+			if delta > maxDelta || delta < -maxDelta {
+				return nil, fmt.Errorf("delta %d of measurement %d of %s is out of range", delta, i, s.name)
+			}
+			measuredAt = measuredAt.Add(time.Duration(delta) * time.Second)
+		}
+
+		d := data.Data{
+			MeshNodeUUID: meshNodeUUID.String(),
+			Type:         s.name,
+			MeasuredAt:   measuredAt.UTC().Format(time.RFC3339),
+			Value:        value,
+		}
+
+		if len(s.uuids) != 0 {
+			id, err := uuid.FromBytes(s.uuids[i])
+			if err != nil {
+				return nil, fmt.Errorf("uuid of measurement %d of %s: %w", i, s.name, err)
+			}
+			d.UUID = id.String()
+		}
+
+		dd = append(dd, d)
+	}
+
+	return dd, nil
+}
+
+func (s service) dataTypeByID(id types.DataTypeID) (types.DataType, error) {
+	dataType, err := s.meshNodeStore.DataTypeByID(id)
+	if errors.Is(err, types.ErrNotFound) {
+		return dataType, fmt.Errorf("%w: unknown data type id %d", types.ErrInvalidValue, id)
+	}
+
+	return dataType, err
+}
+
+// errSeriesValues rejects series with numeric and string values, since their
+// order could not be told.
+var errSeriesValues = errors.New("series has both values and string values")
+
+func decodeCBOR(body []byte) ([]series, error) {
+	var upload cborUpload
+	if err := cbor.Unmarshal(body, &upload); err != nil {
+		return nil, err
+	}
+
+	ss := make([]series, 0, len(upload.Series))
+	for _, cs := range upload.Series {
+		if len(cs.Values) != 0 && len(cs.StringValues) != 0 {
+			return nil, errSeriesValues
+		}
+
+		s := series{
+			typeID: types.DataTypeID(cs.TypeID),
+			name:   cs.Type,
+			start:  cs.Start,
+			deltas: cs.Deltas,
+			values: cs.StringValues,
+			uuids:  cs.UUIDs,
+		}
+
+		for _, v := range cs.Values {
+			value, err := cborValue(v)
+			if err != nil {
+				return nil, err
+			}
+			s.values = append(s.values, value)
+		}
+
+		ss = append(ss, s)
+	}
+
+	return ss, nil
+}
+
+func cborValue(v interface{}) (string, error) {
+	switch value := v.(type) {
+	case uint64:
+		return strconv.FormatUint(value, 10), nil
+	case int64:
+		return strconv.FormatInt(value, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(value), 'f', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case string:
+		return value, nil
+	default:
+		return "", fmt.Errorf("unsupported value %v of type %T", v, v)
+	}
+}
+
+// decodeProtobuf decodes an Upload message. Repeated scalar fields may be
+// packed or not.
+func decodeProtobuf(b []byte) ([]series, error) {
+	var ss []series
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if num == 1 && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+
+			s, err := decodeProtobufSeries(v)
+			if err != nil {
+				return nil, err
+			}
+			ss = append(ss, s)
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+
+	return ss, nil
+}
+
+func decodeProtobufSeries(b []byte) (series, error) {
+	var s series
+	var numbers []string
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return s, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return s, protowire.ParseError(n)
+			}
+			s.typeID = types.DataTypeID(v)
+			b = b[n:]
+		case num == 2 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return s, protowire.ParseError(n)
+			}
+			s.name = v
+			b = b[n:]
+		case num == 3 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return s, protowire.ParseError(n)
+			}
+			s.start = int64(v)
+			b = b[n:]
+		case num == 4 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return s, protowire.ParseError(n)
+			}
+			s.deltas = append(s.deltas, protowire.DecodeZigZag(v))
+			b = b[n:]
+		case num == 4 && typ == protowire.BytesType:
+			packed, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return s, protowire.ParseError(n)
+			}
+			for len(packed) > 0 {
+				v, m := protowire.ConsumeVarint(packed)
+				if m < 0 {
+					return s, protowire.ParseError(m)
+				}
+				s.deltas = append(s.deltas, protowire.DecodeZigZag(v))
+				packed = packed[m:]
+			}
+			b = b[n:]
+		case num == 5 && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				return s, protowire.ParseError(n)
+			}
+			numbers = append(numbers, formatDouble(v))
+			b = b[n:]
+		case num == 5 && typ == protowire.BytesType:
+			packed, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return s, protowire.ParseError(n)
+			}
+			for len(packed) > 0 {
+				v, m := protowire.ConsumeFixed64(packed)
+				if m < 0 {
+					return s, protowire.ParseError(m)
+				}
+				numbers = append(numbers, formatDouble(v))
+				packed = packed[m:]
+			}
+			b = b[n:]
+		case num == 6 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return s, protowire.ParseError(n)
+			}
+			s.values = append(s.values, v)
+			b = b[n:]
+		case num == 7 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return s, protowire.ParseError(n)
+			}
+			s.uuids = append(s.uuids, v)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return s, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+
+	if len(numbers) != 0 && len(s.values) != 0 {
+		return s, errSeriesValues
+	}
+	if len(numbers) != 0 {
+		s.values = numbers
+	}
+
+	return s, nil
+}
+
+func formatDouble(bits uint64) string {
+	return strconv.FormatFloat(math.Float64frombits(bits), 'f', -1, 64)
+}
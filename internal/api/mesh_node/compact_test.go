@@ -0,0 +1,224 @@
+package mesh_node
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protobufSeries encodes an Upload with a single series. Deltas and values
+// are packed.
+func protobufSeries(typeID uint64, name string, start int64, deltas []int64, values []float64, stringValues []string) []byte {
+	var s []byte
+	if typeID != 0 {
+		s = protowire.AppendTag(s, 1, protowire.VarintType)
+		s = protowire.AppendVarint(s, typeID)
+	}
+	if name != "" {
+		s = protowire.AppendTag(s, 2, protowire.BytesType)
+		s = protowire.AppendString(s, name)
+	}
+	if start != 0 {
+		s = protowire.AppendTag(s, 3, protowire.VarintType)
+		s = protowire.AppendVarint(s, uint64(start))
+	}
+	if len(deltas) != 0 {
+		var packed []byte
+		for _, d := range deltas {
+			packed = protowire.AppendVarint(packed, protowire.EncodeZigZag(d))
+		}
+		s = protowire.AppendTag(s, 4, protowire.BytesType)
+		s = protowire.AppendBytes(s, packed)
+	}
+	if len(values) != 0 {
+		var packed []byte
+		for _, v := range values {
+			packed = protowire.AppendFixed64(packed, math.Float64bits(v))
+		}
+		s = protowire.AppendTag(s, 5, protowire.BytesType)
+		s = protowire.AppendBytes(s, packed)
+	}
+	for _, v := range stringValues {
+		s = protowire.AppendTag(s, 6, protowire.BytesType)
+		s = protowire.AppendString(s, v)
+	}
+
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, s)
+}
+
+func TestDecodeCompact(t *testing.T) {
+	tests := []struct {
+		name     string
+		protobuf []byte
+		cbor     cborUpload
+		want     []series
+		err      bool
+	}{
+		{
+			name:     "numeric values",
+			protobuf: protobufSeries(0, "temperature", 1700000000, []int64{0, 60}, []float64{21.5, 22}, nil),
+			cbor: cborUpload{Series: []cborSeries{{
+				Type:   "temperature",
+				Start:  1700000000,
+				Deltas: []int64{0, 60},
+				Values: []interface{}{21.5, 22.0},
+			}}},
+			want: []series{{name: "temperature", start: 1700000000, deltas: []int64{0, 60}, values: []string{"21.5", "22"}}},
+		},
+		{
+			name:     "string values",
+			protobuf: protobufSeries(3, "", 0, nil, nil, []string{"on", "off"}),
+			cbor:     cborUpload{Series: []cborSeries{{TypeID: 3, StringValues: []string{"on", "off"}}}},
+			want:     []series{{typeID: 3, values: []string{"on", "off"}}},
+		},
+		{
+			name:     "numeric and string values",
+			protobuf: protobufSeries(0, "temperature", 0, nil, []float64{21.5}, []string{"warm"}),
+			cbor:     cborUpload{Series: []cborSeries{{Type: "temperature", Values: []interface{}{21.5}, StringValues: []string{"warm"}}}},
+			err:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fromProtobuf, err := decodeProtobuf(tt.protobuf)
+			if (err != nil) != tt.err {
+				t.Fatalf("decodeProtobuf() error = %v, want error %v", err, tt.err)
+			}
+
+			b, err := cbor.Marshal(tt.cbor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fromCBOR, err := decodeCBOR(b)
+			if (err != nil) != tt.err {
+				t.Fatalf("decodeCBOR() error = %v, want error %v", err, tt.err)
+			}
+			if tt.err {
+				return
+			}
+
+			if !reflect.DeepEqual(fromProtobuf, tt.want) {
+				t.Errorf("decodeProtobuf() = %+v, want %+v", fromProtobuf, tt.want)
+			}
+			if !reflect.DeepEqual(fromCBOR, tt.want) {
+				t.Errorf("decodeCBOR() = %+v, want %+v", fromCBOR, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeProtobufInvalid(t *testing.T) {
+	valid := protobufSeries(0, "temperature", 0, nil, []float64{21.5}, nil)
+	if _, err := decodeProtobuf(valid[:len(valid)-1]); err == nil {
+		t.Error("decodeProtobuf() of a truncated upload succeeded")
+	}
+}
+
+func TestCBORValue(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+		err   bool
+	}{
+		{value: uint64(7), want: "7"},
+		{value: int64(-7), want: "-7"},
+		{value: float32(0.5), want: "0.5"},
+		{value: 21.5, want: "21.5"},
+		{value: true, want: "true"},
+		{value: "on", want: "on"},
+		{value: []byte{1}, err: true},
+		{value: nil, err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := cborValue(tt.value)
+		if (err != nil) != tt.err {
+			t.Errorf("cborValue(%v) error = %v, want error %v", tt.value, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("cborValue(%v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSeriesData(t *testing.T) {
+	meshNodeUUID, err := types.UUIDFromString("0cc56633-05ae-4cc3-8f71-801f429caeca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receivedAt := time.Date(2023, 11, 14, 22, 13, 20, 500000000, time.UTC)
+
+	tests := []struct {
+		name   string
+		series series
+		want   []string
+		err    bool
+	}{
+		{
+			name:   "deltas relative to start",
+			series: series{name: "t", start: 1700000000, deltas: []int64{0, 60, -30}, values: []string{"1", "2", "3"}},
+			want:   []string{"2023-11-14T22:13:20Z", "2023-11-14T22:14:20Z", "2023-11-14T22:13:50Z"},
+		},
+		{
+			name:   "relative to receipt",
+			series: series{name: "t", deltas: []int64{-60, 60}, values: []string{"1", "2"}},
+			want:   []string{"2023-11-14T22:12:20Z", "2023-11-14T22:13:20Z"},
+		},
+		{
+			name:   "without deltas",
+			series: series{name: "t", start: 1700000000, values: []string{"1", "2"}},
+			want:   []string{"2023-11-14T22:13:20Z", "2023-11-14T22:13:20Z"},
+		},
+		{
+			name:   "fewer deltas than values",
+			series: series{name: "t", deltas: []int64{0}, values: []string{"1", "2"}},
+			err:    true,
+		},
+		{
+			name:   "fewer uuids than values",
+			series: series{name: "t", values: []string{"1", "2"}, uuids: [][]byte{make([]byte, 16)}},
+			err:    true,
+		},
+		{
+			name:   "invalid uuid",
+			series: series{name: "t", values: []string{"1"}, uuids: [][]byte{{1, 2, 3}}},
+			err:    true,
+		},
+		{
+			name:   "delta out of range",
+			series: series{name: "t", deltas: []int64{math.MaxInt64 / 1000}, values: []string{"1"}},
+			err:    true,
+		},
+		{
+			name:   "negative delta out of range",
+			series: series{name: "t", deltas: []int64{math.MinInt64}, values: []string{"1"}},
+			err:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dd, err := tt.series.data(meshNodeUUID, receivedAt)
+			if (err != nil) != tt.err {
+				t.Fatalf("data() error = %v, want error %v", err, tt.err)
+			}
+
+			var got []string
+			for _, d := range dd {
+				got = append(got, d.MeasuredAt)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("data() measured at %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
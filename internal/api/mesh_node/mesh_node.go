@@ -3,7 +3,9 @@ package mesh_node
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
@@ -13,35 +15,58 @@ import (
 	"github.com/mdma-backend/mdma-backend/internal/types/permission"
 )
 
+// maxDataSize limits the size of the body of a request with data.
+const maxDataSize = 32 << 20
+
 type MeshNodeStore interface {
 	MeshNodes() ([]types.MeshNode, error)
+	MeshNodesWithin(types.SpatialFilter) ([]types.MeshNode, error)
 	MeshNodeById(types.UUID) (types.MeshNode, error)
 	CreateMeshNode(*types.MeshNode) error
 	CreateMeshNodeData(types.UUID, *data.Data) error
 	CreateManyMeshNodeData(types.UUID, []data.Data) error
 	UpdateMeshNode(types.UUID, *types.MeshNode) error
 	DeleteMeshNode(types.UUID) error
+	DataTypeByID(types.DataTypeID) (types.DataType, error)
+	AreasContain(areaIDs []types.AreaID, latitude float32, longitude float32) (bool, error)
+}
+
+// DataPublisher is notified about all data ingested through the service.
+type DataPublisher interface {
+	Publish(...data.Data)
+}
+
+// DataWriter stores lists of data asynchronously. It fails if it can not
+// take more data at the moment.
+type DataWriter interface {
+	Write([]data.Data) error
 }
 
 type service struct {
 	handler       http.Handler
 	meshNodeStore MeshNodeStore
+	dataPublisher DataPublisher
+	dataWriter    DataWriter
 }
 
-func NewService(store MeshNodeStore) http.Handler {
+// NewService returns the mesh node service. If writer is not nil, lists of
+// data are accepted by the writer instead of being stored before responding.
+func NewService(store MeshNodeStore, publisher DataPublisher, writer DataWriter) http.Handler {
 	r := chi.NewRouter()
 	s := service{
 		handler:       r,
 		meshNodeStore: store,
+		dataPublisher: publisher,
+		dataWriter:    writer,
 	}
 
 	r.Get("/", auth.RestrictHandlerFunc(s.getMeshNodes(), permission.MeshNodeRead))
-	r.Get("/{uuid}", auth.RestrictHandlerFunc(s.getMeshNode(), permission.MeshNodeRead))
+	r.Get("/{uuid}", auth.RestrictMeshNodeHandlerFunc(s.getMeshNode(), meshNodeParam, permission.MeshNodeRead))
 	r.Post("/", auth.RestrictHandlerFunc(s.postMeshNode(), permission.MeshNodeCreate))
-	r.Post("/{uuid}/data", auth.RestrictHandlerFunc(s.postMeshNodeData(), permission.DataCreate))
-	r.Post("/{uuid}/data-list", auth.RestrictHandlerFunc(s.postManyMeshNodeData(), permission.DataCreate))
-	r.Put("/{uuid}", auth.RestrictHandlerFunc(s.putMeshNode(), permission.MeshNodeUpdate))
-	r.Delete("/{uuid}", auth.RestrictHandlerFunc(s.deleteMeshNode(), permission.MeshNodeDelete))
+	r.Post("/{uuid}/data", auth.RestrictMeshNodeHandlerFunc(s.postMeshNodeData(), meshNodeParam, permission.DataCreate))
+	r.Post("/{uuid}/data-list", auth.RestrictMeshNodeHandlerFunc(s.postManyMeshNodeData(), meshNodeParam, permission.DataCreate))
+	r.Put("/{uuid}", auth.RestrictMeshNodeHandlerFunc(s.putMeshNode(), meshNodeParam, permission.MeshNodeUpdate))
+	r.Delete("/{uuid}", auth.RestrictMeshNodeHandlerFunc(s.deleteMeshNode(), meshNodeParam, permission.MeshNodeDelete))
 
 	return s
 }
@@ -50,14 +75,61 @@ func (s service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.handler.ServeHTTP(w, r)
 }
 
+// meshNodeParam returns the mesh node of the uuid URL parameter. Invalid
+// UUIDs are rejected by the handlers.
+func meshNodeParam(r *http.Request) (string, error) {
+	meshNodeUUID, err := types.UUIDFromString(chi.URLParam(r, "uuid"))
+	if err != nil {
+		return "", nil
+	}
+
+	return meshNodeUUID.String(), nil
+}
+
+// locationInScope reports whether the location of the mesh node lies within
+// the areas of the role of the request. Roles that are not scoped to areas
+// may place mesh nodes anywhere.
+func (s service) locationInScope(r *http.Request, meshNode types.MeshNode) (bool, error) {
+	info, ok := r.Context().Value(auth.AccountInfoCtxKey).(types.AccountInfo)
+	if !ok {
+		return false, nil
+	}
+	if !info.Role.IsScoped() {
+		return true, nil
+	}
+
+	return s.meshNodeStore.AreasContain(info.Role.AreaIDs, meshNode.Latitude, meshNode.Longitude)
+}
+
 func (s service) getMeshNodes() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		meshNodes, err := s.meshNodeStore.MeshNodes()
+		filter, err := types.SpatialFilterFromQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var meshNodes []types.MeshNode
+		if filter.IsEmpty() {
+			meshNodes, err = s.meshNodeStore.MeshNodes()
+		} else {
+			meshNodes, err = s.meshNodeStore.MeshNodesWithin(filter)
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		if scope, ok := auth.MeshNodesInScope(r); ok {
+			var inScope []types.MeshNode
+			for _, n := range meshNodes {
+				if scope[n.UUID.String()] {
+					inScope = append(inScope, n)
+				}
+			}
+			meshNodes = inScope
+		}
+
 		render.JSON(w, r, meshNodes)
 	}
 }
@@ -92,6 +164,14 @@ func (s service) postMeshNode() http.HandlerFunc {
 			return
 		}
 
+		if ok, err := s.locationInScope(r, meshNode); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if !ok {
+			http.Error(w, "location is not in the areas of the role", http.StatusUnauthorized)
+			return
+		}
+
 		if err := s.meshNodeStore.CreateMeshNode(&meshNode); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -111,20 +191,54 @@ func (s service) postMeshNodeData() http.HandlerFunc {
 			return
 		}
 
-		var data data.Data
-		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, maxDataSize)
+		var maxBytesErr *http.MaxBytesError
+
+		var meshNodeData data.Data
+		if isCompact(r) {
+			dd, err := s.decodeCompact(r, meshNodeUUID, time.Now())
+			if err == nil && len(dd) != 1 {
+				err = fmt.Errorf("%w: expected one measurement, got %d", types.ErrInvalidValue, len(dd))
+			}
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			} else if errors.Is(err, types.ErrInvalidValue) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			meshNodeData = dd[0]
+		} else if err := json.NewDecoder(r.Body).Decode(&meshNodeData); errors.As(err, &maxBytesErr) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		data.MeshNodeUUID = meshNodeUUID.String()
+		meshNodeData.MeshNodeUUID = meshNodeUUID.String()
+		if key := r.Header.Get(data.IdempotencyKeyHeader); key != "" && meshNodeData.UUID == "" {
+			meshNodeData.UUID = data.IdempotentUUID(meshNodeUUID, key, 0)
+		}
 
-		if err = s.meshNodeStore.CreateMeshNodeData(meshNodeUUID, &data); err != nil {
+		if err = s.meshNodeStore.CreateMeshNodeData(meshNodeUUID, &meshNodeData); errors.Is(err, types.ErrInvalidValue) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if errors.Is(err, types.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		s.dataPublisher.Publish(meshNodeData)
 
-		render.Status(r, http.StatusCreated)
-		render.JSON(w, r, data)
+		if !meshNodeData.Duplicate {
+			render.Status(r, http.StatusCreated)
+		}
+		render.JSON(w, r, data.NewIngestedData(meshNodeData))
 	}
 }
 
@@ -137,19 +251,79 @@ func (s service) postManyMeshNodeData() http.HandlerFunc {
 			return
 		}
 
+		r.Body = http.MaxBytesReader(w, r.Body, maxDataSize)
+		var maxBytesErr *http.MaxBytesError
+
 		var meshNodeData []data.Data
-		if err := json.NewDecoder(r.Body).Decode(&meshNodeData); err != nil {
+		if isCompact(r) {
+			meshNodeData, err = s.decodeCompact(r, meshNodeUUID, time.Now())
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			} else if errors.Is(err, types.ErrInvalidValue) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&meshNodeData); errors.As(err, &maxBytesErr) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		if err = s.meshNodeStore.CreateManyMeshNodeData(meshNodeUUID, meshNodeData); err != nil {
+		key := r.Header.Get(data.IdempotencyKeyHeader)
+		for i := range meshNodeData {
+			meshNodeData[i].MeshNodeUUID = meshNodeUUID.String()
+			if key != "" && meshNodeData[i].UUID == "" {
+				meshNodeData[i].UUID = data.IdempotentUUID(meshNodeUUID, key, i)
+			}
+		}
+
+		if s.dataWriter != nil {
+			if err := s.dataWriter.Write(meshNodeData); err != nil {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+
+			response := make([]data.IngestedData, 0, len(meshNodeData))
+			for _, d := range meshNodeData {
+				response = append(response, data.NewQueuedData(d))
+			}
+
+			render.Status(r, http.StatusAccepted)
+			render.JSON(w, r, response)
+			return
+		}
+
+		if err = s.meshNodeStore.CreateManyMeshNodeData(meshNodeUUID, meshNodeData); errors.Is(err, types.ErrInvalidValue) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if errors.Is(err, types.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		s.dataPublisher.Publish(meshNodeData...)
 
-		render.Status(r, http.StatusCreated)
-		render.JSON(w, r, meshNodeData)
+		// Created if at least one data was not stored before
+		status := http.StatusOK
+		response := make([]data.IngestedData, 0, len(meshNodeData))
+		for _, d := range meshNodeData {
+			if !d.Duplicate {
+				status = http.StatusCreated
+			}
+			response = append(response, data.NewIngestedData(d))
+		}
+
+		render.Status(r, status)
+		render.JSON(w, r, response)
 	}
 }
 
@@ -168,6 +342,14 @@ func (s service) putMeshNode() http.HandlerFunc {
 			return
 		}
 
+		if ok, err := s.locationInScope(r, meshNode); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if !ok {
+			http.Error(w, "location is not in the areas of the role", http.StatusUnauthorized)
+			return
+		}
+
 		if err := s.meshNodeStore.UpdateMeshNode(meshNodeUUID, &meshNode); errors.Is(err, types.ErrNotFound) {
 			w.WriteHeader(http.StatusNotFound)
 			return
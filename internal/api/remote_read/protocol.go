@@ -0,0 +1,176 @@
+package remote_read
+
+import (
+	"errors"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The messages of the remote read protocol are decoded and encoded by hand
+// like the compact encodings of mesh node data. Only the fields needed to
+// answer queries with samples are supported, see
+// https://github.com/prometheus/prometheus/blob/main/prompb/remote.proto.
+
+// query is a query of a read request.
+type query struct {
+	startMs  int64
+	endMs    int64
+	matchers []Matcher
+}
+
+// decodeReadRequest decodes the queries of a ReadRequest.
+func decodeReadRequest(b []byte) ([]query, error) {
+	var queries []query
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != 1 || typ != protowire.BytesType {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n, nil
+		}
+
+		q, err := decodeQuery(v)
+		if err != nil {
+			return 0, err
+		}
+		queries = append(queries, q)
+		return n, nil
+	})
+
+	return queries, err
+}
+
+func decodeQuery(b []byte) (query, error) {
+	var q query
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			q.startMs = int64(v)
+			return n, nil
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			q.endMs = int64(v)
+			return n, nil
+		case num == 3 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+
+			m, err := decodeMatcher(v)
+			if err != nil {
+				return 0, err
+			}
+			q.matchers = append(q.matchers, m)
+			return n, nil
+		default:
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+	})
+
+	return q, err
+}
+
+func decodeMatcher(b []byte) (Matcher, error) {
+	var m Matcher
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			m.Type = MatchType(v)
+			return n, nil
+		case num == 2 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			m.Name = v
+			return n, nil
+		case num == 3 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			m.Value = v
+			return n, nil
+		default:
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+	})
+	if err != nil {
+		return m, err
+	}
+
+	if m.Type > MatchNotRegexp {
+		return m, errors.New("unknown matcher type")
+	}
+
+	return m, nil
+}
+
+// consumeFields calls fn for every field of a message. fn returns the
+// length of the value of the field or a negative protowire error code.
+func consumeFields(b []byte, fn func(protowire.Number, protowire.Type, []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		n, err := fn(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+
+	return nil
+}
+
+// encodeReadResponse encodes a ReadResponse with a QueryResult per query.
+func encodeReadResponse(results [][]Series) []byte {
+	var b []byte
+	for _, result := range results {
+		var r []byte
+		for _, s := range result {
+			r = protowire.AppendTag(r, 1, protowire.BytesType)
+			r = protowire.AppendBytes(r, encodeTimeSeries(s))
+		}
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, r)
+	}
+
+	return b
+}
+
+// encodeTimeSeries encodes the labels, sorted by name, and the samples of a
+// series.
+func encodeTimeSeries(s Series) []byte {
+	var b []byte
+	for _, label := range [][2]string{{nameLabel, s.DataType}, {meshNodeLabel, s.MeshNodeUUID}} {
+		var l []byte
+		l = protowire.AppendTag(l, 1, protowire.BytesType)
+		l = protowire.AppendString(l, label[0])
+		l = protowire.AppendTag(l, 2, protowire.BytesType)
+		l = protowire.AppendString(l, label[1])
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, l)
+	}
+
+	for _, sample := range s.Samples {
+		var sb []byte
+		sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(sample.Value))
+		sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(sample.Timestamp))
+
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, sb)
+	}
+
+	return b
+}
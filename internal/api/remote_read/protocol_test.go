@@ -0,0 +1,217 @@
+package remote_read
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func encodeMatcher(m Matcher) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(m.Type))
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, m.Name)
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	return protowire.AppendString(b, m.Value)
+}
+
+func encodeReadRequest(queries []query) []byte {
+	var b []byte
+	for _, q := range queries {
+		var qb []byte
+		qb = protowire.AppendTag(qb, 1, protowire.VarintType)
+		qb = protowire.AppendVarint(qb, uint64(q.startMs))
+		qb = protowire.AppendTag(qb, 2, protowire.VarintType)
+		qb = protowire.AppendVarint(qb, uint64(q.endMs))
+		for _, m := range q.matchers {
+			qb = protowire.AppendTag(qb, 3, protowire.BytesType)
+			qb = protowire.AppendBytes(qb, encodeMatcher(m))
+		}
+		// Hints are not supported and skipped
+		qb = protowire.AppendTag(qb, 4, protowire.BytesType)
+		qb = protowire.AppendBytes(qb, []byte{0x08, 0x01})
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, qb)
+	}
+
+	// Accepted response types are not supported and skipped
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	return protowire.AppendVarint(b, 0)
+}
+
+func TestDecodeReadRequest(t *testing.T) {
+	queries := []query{
+		{
+			startMs: 1700000000000,
+			endMs:   1700003600000,
+			matchers: []Matcher{
+				{Type: MatchEqual, Name: nameLabel, Value: "temperature"},
+				{Type: MatchRegexp, Name: meshNodeLabel, Value: "0cc5.*"},
+			},
+		},
+		{
+			startMs:  0,
+			endMs:    1,
+			matchers: []Matcher{{Type: MatchNotRegexp, Name: "job", Value: ".+"}},
+		},
+	}
+
+	got, err := decodeReadRequest(encodeReadRequest(queries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, queries) {
+		t.Errorf("decodeReadRequest() = %+v, want %+v", got, queries)
+	}
+}
+
+func TestDecodeReadRequestInvalid(t *testing.T) {
+	valid := encodeReadRequest([]query{{startMs: 1, endMs: 2, matchers: []Matcher{{Name: nameLabel, Value: "temperature"}}}})
+
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{name: "truncated", b: valid[:len(valid)-5]},
+		{name: "unknown matcher type", b: encodeReadRequest([]query{{matchers: []Matcher{{Type: 4, Name: nameLabel}}}})},
+		{name: "invalid tag", b: []byte{0xff}},
+	}
+
+	for _, tt := range tests {
+		if _, err := decodeReadRequest(tt.b); err == nil {
+			t.Errorf("decodeReadRequest() of %s request succeeded", tt.name)
+		}
+	}
+}
+
+// decodedSeries is a series of a ReadResponse with its labels in order.
+type decodedSeries struct {
+	labels  [][2]string
+	samples []Sample
+}
+
+// messages calls fn with the number and value of every length delimited
+// field of a message.
+func messages(t *testing.T, b []byte, fn func(protowire.Number, []byte)) {
+	t.Helper()
+
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if typ != protowire.BytesType {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+
+		v, n := protowire.ConsumeBytes(b)
+		if n >= 0 {
+			fn(num, v)
+		}
+		return n, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func decodeReadResponse(t *testing.T, b []byte) [][]decodedSeries {
+	t.Helper()
+
+	var results [][]decodedSeries
+	messages(t, b, func(_ protowire.Number, result []byte) {
+		var series []decodedSeries
+		messages(t, result, func(_ protowire.Number, ts []byte) {
+			var s decodedSeries
+			messages(t, ts, func(num protowire.Number, v []byte) {
+				if num == 1 {
+					var label [2]string
+					messages(t, v, func(num protowire.Number, v []byte) {
+						label[num-1] = string(v)
+					})
+					s.labels = append(s.labels, label)
+					return
+				}
+
+				var sample Sample
+				consumeFields(v, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+					if num == 1 {
+						bits, n := protowire.ConsumeFixed64(b)
+						sample.Value = math.Float64frombits(bits)
+						return n, nil
+					}
+					ts, n := protowire.ConsumeVarint(b)
+					sample.Timestamp = int64(ts)
+					return n, nil
+				})
+				s.samples = append(s.samples, sample)
+			})
+			series = append(series, s)
+		})
+		results = append(results, series)
+	})
+
+	return results
+}
+
+func TestEncodeReadResponse(t *testing.T) {
+	results := [][]Series{
+		{
+			{
+				DataType:     "temperature",
+				MeshNodeUUID: "0cc56633-05ae-4cc3-8f71-801f429caeca",
+				Samples:      []Sample{{Timestamp: 1700000000000, Value: 21.5}, {Timestamp: 1700000060000, Value: -1}},
+			},
+		},
+		nil,
+	}
+
+	want := [][]decodedSeries{
+		{
+			{
+				labels:  [][2]string{{nameLabel, "temperature"}, {meshNodeLabel, "0cc56633-05ae-4cc3-8f71-801f429caeca"}},
+				samples: []Sample{{Timestamp: 1700000000000, Value: 21.5}, {Timestamp: 1700000060000, Value: -1}},
+			},
+		},
+		nil,
+	}
+
+	got := decodeReadResponse(t, encodeReadResponse(results))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("encodeReadResponse() decodes to %+v, want %+v", got, want)
+	}
+}
+
+func TestMatcherCompile(t *testing.T) {
+	tests := []struct {
+		matcher Matcher
+		matches []string
+		misses  []string
+		err     bool
+	}{
+		{matcher: Matcher{Type: MatchEqual, Value: "temperature"}, matches: []string{"temperature"}, misses: []string{"temperature2", ""}},
+		{matcher: Matcher{Type: MatchNotEqual, Value: "temperature"}, matches: []string{"humidity", ""}, misses: []string{"temperature"}},
+		{matcher: Matcher{Type: MatchRegexp, Value: "temp.*|hum"}, matches: []string{"temperature", "hum"}, misses: []string{"humidity", "air temperature"}},
+		{matcher: Matcher{Type: MatchNotRegexp, Value: "temp.*"}, matches: []string{"humidity", "air temperature"}, misses: []string{"temperature"}},
+		{matcher: Matcher{Type: MatchRegexp, Value: "("}, err: true},
+	}
+
+	for _, tt := range tests {
+		matches, err := tt.matcher.compile()
+		if (err != nil) != tt.err {
+			t.Errorf("compile() of %+v error = %v, want error %v", tt.matcher, err, tt.err)
+			continue
+		}
+
+		for _, value := range tt.matches {
+			if !matches(value) {
+				t.Errorf("%+v does not match %q", tt.matcher, value)
+			}
+		}
+		for _, value := range tt.misses {
+			if matches(value) {
+				t.Errorf("%+v matches %q", tt.matcher, value)
+			}
+		}
+	}
+}
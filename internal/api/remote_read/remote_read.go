@@ -0,0 +1,239 @@
+package remote_read
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang/snappy"
+	"github.com/mdma-backend/mdma-backend/internal/api/auth"
+	"github.com/mdma-backend/mdma-backend/internal/types/permission"
+)
+
+const (
+	// nameLabel is the label of the data type of a series.
+	nameLabel = "__name__"
+	// meshNodeLabel is the label of the mesh node of a series.
+	meshNodeLabel = "mesh_node"
+
+	maxRequestSize = 1 << 20
+	// maxSamples limits the samples of a request, which are all held in
+	// memory before they are sent.
+	maxSamples = 5000000
+)
+
+// ErrTooManySamples is returned by the store if a query selects more
+// samples than allowed.
+var ErrTooManySamples = errors.New("too many samples")
+
+// MatchType is the type of a label matcher. The values are the ones of the
+// remote read protocol.
+type MatchType int
+
+const (
+	MatchEqual MatchType = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+)
+
+// Matcher selects series by the value of a label. Regular expressions are
+// fully anchored.
+type Matcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+}
+
+// Series are the samples of a data type measured by a mesh node.
+type Series struct {
+	DataType     string
+	MeshNodeUUID string
+	Samples      []Sample
+}
+
+// Sample is a numeric measurement with its time in milliseconds since the
+// epoch.
+type Sample struct {
+	Timestamp int64
+	Value     float64
+}
+
+type SeriesStore interface {
+	// LabelValues returns all values of the label __name__ or mesh_node.
+	LabelValues(name string) ([]string, error)
+	// ReadSeries returns the series of numeric data of the data types
+	// measured by the mesh nodes between start and end. Nil selects all
+	// data types or mesh nodes. It returns ErrTooManySamples if the series
+	// have more than limit samples.
+	ReadSeries(dataTypes []string, meshNodeUUIDs []string, start time.Time, end time.Time, limit int) ([]Series, error)
+}
+
+type service struct {
+	handler     http.Handler
+	seriesStore SeriesStore
+}
+
+// NewService returns a service implementing the Prometheus remote read
+// protocol. The data type of a series is its metric name and the mesh node
+// its label mesh_node. Boolean data is read as 0 and 1, data that is not
+// numeric is left out.
+func NewService(seriesStore SeriesStore) http.Handler {
+	r := chi.NewRouter()
+	s := service{
+		handler:     r,
+		seriesStore: seriesStore,
+	}
+
+	r.Post("/", auth.RestrictHandlerFunc(s.postRead(), permission.DataRead))
+
+	return s
+}
+
+func (s service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+func (s service) postRead() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		b, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		queries, err := decodeReadRequest(b)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		limit := maxSamples
+		results := make([][]Series, 0, len(queries))
+		for _, q := range queries {
+			series, err := s.readSeries(r, q, limit)
+			if errors.Is(err, errInvalidMatcher) || errors.Is(err, ErrTooManySamples) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			for _, ss := range series {
+				limit -= len(ss.Samples)
+			}
+			results = append(results, series)
+		}
+
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Header().Set("Content-Encoding", "snappy")
+		w.Write(snappy.Encode(nil, encodeReadResponse(results)))
+	}
+}
+
+var errInvalidMatcher = errors.New("invalid matcher")
+
+// readSeries reads the series of a query. Series only have the labels
+// __name__ and mesh_node, so matchers on other labels are matched against
+// the empty value of a missing label. Matchers on the labels of series are
+// applied to the values of the labels, so regular expressions always have
+// the syntax of Prometheus. Mesh nodes outside of the scope of the role of
+// the request are never matched.
+func (s service) readSeries(r *http.Request, q query, limit int) ([]Series, error) {
+	matchers := map[string][]func(string) bool{}
+	for _, m := range q.matchers {
+		matches, err := m.compile()
+		if err != nil {
+			return nil, err
+		}
+
+		if m.Name == nameLabel || m.Name == meshNodeLabel {
+			matchers[m.Name] = append(matchers[m.Name], matches)
+			continue
+		}
+
+		if !matches("") {
+			return nil, nil
+		}
+	}
+
+	if scope, scoped := auth.MeshNodesInScope(r); scoped {
+		matchers[meshNodeLabel] = append(matchers[meshNodeLabel], func(value string) bool {
+			return scope[value]
+		})
+	}
+
+	dataTypes, err := s.labelValues(nameLabel, matchers[nameLabel])
+	if err != nil || (dataTypes != nil && len(dataTypes) == 0) {
+		return nil, err
+	}
+
+	meshNodeUUIDs, err := s.labelValues(meshNodeLabel, matchers[meshNodeLabel])
+	if err != nil || (meshNodeUUIDs != nil && len(meshNodeUUIDs) == 0) {
+		return nil, err
+	}
+
+	return s.seriesStore.ReadSeries(dataTypes, meshNodeUUIDs, time.UnixMilli(q.startMs), time.UnixMilli(q.endMs), limit)
+}
+
+// labelValues returns the values of the label that match all matchers, or
+// nil if there are no matchers.
+func (s service) labelValues(name string, matchers []func(string) bool) ([]string, error) {
+	if len(matchers) == 0 {
+		return nil, nil
+	}
+
+	values, err := s.seriesStore.LabelValues(name)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := []string{}
+	for _, value := range values {
+		matchesAll := true
+		for _, matches := range matchers {
+			if !matches(value) {
+				matchesAll = false
+				break
+			}
+		}
+
+		if matchesAll {
+			selected = append(selected, value)
+		}
+	}
+
+	return selected, nil
+}
+
+// compile returns a function that reports whether a value matches.
+func (m Matcher) compile() (func(string) bool, error) {
+	switch m.Type {
+	case MatchEqual:
+		return func(value string) bool { return value == m.Value }, nil
+	case MatchNotEqual:
+		return func(value string) bool { return value != m.Value }, nil
+	}
+
+	re, err := regexp.Compile("^(?:" + m.Value + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidMatcher, err)
+	}
+
+	if m.Type == MatchRegexp {
+		return re.MatchString, nil
+	}
+
+	return func(value string) bool { return !re.MatchString(value) }, nil
+}
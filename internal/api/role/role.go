@@ -44,9 +44,10 @@ func NewService(roleStore RoleStore) http.Handler {
 
 	r.Get("/", auth.RestrictHandlerFunc(s.getRoles(), permission.RoleRead))
 	r.Get("/{id}", auth.RestrictHandlerFunc(s.getRole(), permission.RoleRead))
-	r.Post("/", auth.RestrictHandlerFunc(s.postRole(), permission.RoleCreate))
-	r.Put("/{id}", auth.RestrictHandlerFunc(s.putRole(), permission.RoleUpdate))
-	r.Delete("/{id}", auth.RestrictHandlerFunc(s.deleteRole(), permission.RoleDelete))
+	// Roles scoped to areas could remove the areas of their own role
+	r.Post("/", auth.RestrictUnscopedHandlerFunc(s.postRole(), permission.RoleCreate))
+	r.Put("/{id}", auth.RestrictUnscopedHandlerFunc(s.putRole(), permission.RoleUpdate))
+	r.Delete("/{id}", auth.RestrictUnscopedHandlerFunc(s.deleteRole(), permission.RoleDelete))
 
 	r.Get("/permissions", auth.RestrictHandlerFunc(getPermissions(), permission.RoleCreate))
 
@@ -99,7 +100,10 @@ func (s service) postRole() http.HandlerFunc {
 			return
 		}
 
-		if err := s.roleStore.CreateRole(&role); err != nil {
+		if err := s.roleStore.CreateRole(&role); errors.Is(err, types.ErrInvalidValue) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -127,6 +131,9 @@ func (s service) putRole() http.HandlerFunc {
 		if err := s.roleStore.UpdateRole(roleID, &role); errors.Is(err, types.ErrNotFound) {
 			w.WriteHeader(http.StatusNotFound)
 			return
+		} else if errors.Is(err, types.ErrInvalidValue) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		} else if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
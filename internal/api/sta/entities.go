@@ -0,0 +1,400 @@
+package sta
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+// Entity sets of the sensing part of the SensorThings API. Mesh nodes are
+// Things with a Location and a Sensor, data types are ObservedProperties,
+// the data of a data type measured by a mesh node is a Datastream and each
+// measurement is an Observation.
+const (
+	thingsSet             = "Things"
+	locationsSet          = "Locations"
+	sensorsSet            = "Sensors"
+	observedPropertiesSet = "ObservedProperties"
+	datastreamsSet        = "Datastreams"
+	observationsSet       = "Observations"
+)
+
+var entitySets = []string{
+	thingsSet,
+	locationsSet,
+	sensorsSet,
+	observedPropertiesSet,
+	datastreamsSet,
+	observationsSet,
+}
+
+// navigation is a navigation property to entities of set.
+type navigation struct {
+	set  string
+	many bool
+}
+
+var navigations = map[string]map[string]navigation{
+	thingsSet: {
+		"Locations":   {set: locationsSet, many: true},
+		"Datastreams": {set: datastreamsSet, many: true},
+	},
+	locationsSet: {
+		"Things": {set: thingsSet, many: true},
+	},
+	sensorsSet: {
+		"Datastreams": {set: datastreamsSet, many: true},
+	},
+	observedPropertiesSet: {
+		"Datastreams": {set: datastreamsSet, many: true},
+	},
+	datastreamsSet: {
+		"Thing":            {set: thingsSet},
+		"Sensor":           {set: sensorsSet},
+		"ObservedProperty": {set: observedPropertiesSet},
+		"Observations":     {set: observationsSet, many: true},
+	},
+	observationsSet: {
+		"Datastream": {set: datastreamsSet},
+	},
+}
+
+// entity is an entity of a set with its JSON properties. Entities are
+// related if they belong to the same mesh node and data type.
+type entity struct {
+	set          string
+	id           string
+	meshNodeUUID string
+	dataTypeID   types.DataTypeID
+	props        map[string]interface{}
+	// related are the properties of related entities that can be used in
+	// filters, like Thing/name of a Datastream.
+	related map[string]map[string]interface{}
+}
+
+func hasMeshNode(set string) bool {
+	return set != observedPropertiesSet
+}
+
+func hasDataType(set string) bool {
+	return set == observedPropertiesSet || set == datastreamsSet || set == observationsSet
+}
+
+func (e entity) relatedTo(other entity) bool {
+	if hasMeshNode(e.set) && hasMeshNode(other.set) && e.meshNodeUUID != other.meshNodeUUID {
+		return false
+	}
+	if hasDataType(e.set) && hasDataType(other.set) && e.dataTypeID != other.dataTypeID {
+		return false
+	}
+	return true
+}
+
+// view returns the properties of the entity and its related entities.
+func (e entity) view() map[string]interface{} {
+	if len(e.related) == 0 {
+		return e.props
+	}
+
+	v := make(map[string]interface{}, len(e.props)+len(e.related))
+	for key, value := range e.props {
+		v[key] = value
+	}
+	for key, value := range e.related {
+		v[key] = value
+	}
+	return v
+}
+
+// datastreamID returns the id of the Datastream of a data type measured by
+// a mesh node.
+func datastreamID(meshNodeUUID string, dataTypeID types.DataTypeID) string {
+	return fmt.Sprintf("%s:%d", meshNodeUUID, dataTypeID)
+}
+
+// keyLiteral returns the id of an entity as it appears in URLs.
+func keyLiteral(set string, id string) string {
+	if set == observedPropertiesSet {
+		return id
+	}
+	return "'" + strings.ReplaceAll(id, "'", "''") + "'"
+}
+
+// model builds the entities of a request from the store. The mesh nodes,
+// data types and datastreams are only read once. If scope is not nil, only
+// the mesh nodes in it and their data are visible.
+type model struct {
+	store   Store
+	baseURL string
+	scope   map[string]bool
+
+	meshNodes   []types.MeshNode
+	dataTypes   map[types.DataTypeID]types.DataType
+	datastreams []Datastream
+}
+
+func (m *model) selfLink(set string, id string) string {
+	return fmt.Sprintf("%s/%s(%s)", m.baseURL, set, keyLiteral(set, id))
+}
+
+func (m *model) newEntity(set string, id string, props map[string]interface{}) entity {
+	props["@iot.id"] = id
+	if set == observedPropertiesSet {
+		// The ids of data types are numbers
+		props["@iot.id"], _ = strconv.Atoi(id)
+	}
+	props["@iot.selfLink"] = m.selfLink(set, id)
+	for name := range navigations[set] {
+		props[name+"@iot.navigationLink"] = m.selfLink(set, id) + "/" + name
+	}
+
+	return entity{set: set, id: id, props: props}
+}
+
+func (m *model) loadMeshNodes() error {
+	if m.meshNodes != nil {
+		return nil
+	}
+
+	meshNodes, err := m.store.MeshNodes()
+	if err != nil {
+		return err
+	}
+	m.meshNodes = []types.MeshNode{}
+	for _, n := range meshNodes {
+		if m.inScope(n.UUID) {
+			m.meshNodes = append(m.meshNodes, n)
+		}
+	}
+	return nil
+}
+
+func (m *model) loadDataTypes() error {
+	if m.dataTypes != nil {
+		return nil
+	}
+
+	dataTypes, err := m.store.DataTypes()
+	if err != nil {
+		return err
+	}
+
+	m.dataTypes = map[types.DataTypeID]types.DataType{}
+	for _, t := range dataTypes {
+		m.dataTypes[t.ID] = t
+	}
+	return nil
+}
+
+func (m *model) loadDatastreams() error {
+	if m.datastreams != nil {
+		return nil
+	}
+
+	datastreams, err := m.store.Datastreams()
+	if err != nil {
+		return err
+	}
+	m.datastreams = []Datastream{}
+	for _, ds := range datastreams {
+		if m.inScope(ds.MeshNodeUUID) {
+			m.datastreams = append(m.datastreams, ds)
+		}
+	}
+	return nil
+}
+
+func (m *model) inScope(meshNodeUUID types.UUID) bool {
+	return m.scope == nil || m.scope[meshNodeUUID.String()]
+}
+
+// entities returns all entities of a set except Observations.
+func (m *model) entities(set string) ([]entity, error) {
+	switch set {
+	case thingsSet, locationsSet, sensorsSet:
+		if err := m.loadMeshNodes(); err != nil {
+			return nil, err
+		}
+
+		entities := make([]entity, 0, len(m.meshNodes))
+		for _, n := range m.meshNodes {
+			entities = append(entities, m.meshNodeEntity(set, n))
+		}
+		return entities, nil
+	case observedPropertiesSet:
+		if err := m.loadDataTypes(); err != nil {
+			return nil, err
+		}
+
+		entities := make([]entity, 0, len(m.dataTypes))
+		for _, t := range m.dataTypes {
+			entities = append(entities, m.observedPropertyEntity(t))
+		}
+		sort.Slice(entities, func(i, j int) bool {
+			return entities[i].dataTypeID < entities[j].dataTypeID
+		})
+		return entities, nil
+	case datastreamsSet:
+		if err := m.loadMeshNodes(); err != nil {
+			return nil, err
+		}
+		if err := m.loadDataTypes(); err != nil {
+			return nil, err
+		}
+		if err := m.loadDatastreams(); err != nil {
+			return nil, err
+		}
+
+		meshNodes := map[string]types.MeshNode{}
+		for _, n := range m.meshNodes {
+			meshNodes[n.UUID.String()] = n
+		}
+
+		entities := make([]entity, 0, len(m.datastreams))
+		for _, ds := range m.datastreams {
+			e := m.datastreamEntity(ds)
+
+			meshNode := meshNodes[e.meshNodeUUID]
+			e.related = map[string]map[string]interface{}{
+				"Thing":            m.meshNodeEntity(thingsSet, meshNode).props,
+				"Sensor":           m.meshNodeEntity(sensorsSet, meshNode).props,
+				"ObservedProperty": m.observedPropertyEntity(m.dataTypes[ds.DataTypeID]).props,
+			}
+			entities = append(entities, e)
+		}
+		return entities, nil
+	default:
+		return nil, fmt.Errorf("no entities of %s", set)
+	}
+}
+
+func (m *model) meshNodeEntity(set string, n types.MeshNode) entity {
+	id := n.UUID.String()
+
+	var props map[string]interface{}
+	switch set {
+	case thingsSet:
+		properties := map[string]interface{}{
+			"createdAt": n.CreatedAt,
+		}
+		if n.UpdatedAt != nil {
+			properties["updatedAt"] = *n.UpdatedAt
+		}
+		if n.UpdateID != nil {
+			properties["updateId"] = *n.UpdateID
+		}
+
+		props = map[string]interface{}{
+			"name":        id,
+			"description": "Mesh node " + id,
+			"properties":  properties,
+		}
+	case locationsSet:
+		props = map[string]interface{}{
+			"name":         id,
+			"description":  "Location of mesh node " + id,
+			"encodingType": "application/geo+json",
+			"location": map[string]interface{}{
+				"type":        "Point",
+				"coordinates": []interface{}{float64(n.Longitude), float64(n.Latitude)},
+			},
+		}
+	default:
+		props = map[string]interface{}{
+			"name":         id,
+			"description":  "Sensors of mesh node " + id,
+			"encodingType": "text/plain",
+			"metadata":     id,
+		}
+	}
+
+	e := m.newEntity(set, id, props)
+	e.meshNodeUUID = id
+	return e
+}
+
+func (m *model) observedPropertyEntity(t types.DataType) entity {
+	e := m.newEntity(observedPropertiesSet, strconv.FormatUint(uint64(t.ID), 10), map[string]interface{}{
+		"name":        t.Name,
+		"definition":  t.Name,
+		"description": t.Description,
+	})
+	e.dataTypeID = t.ID
+	return e
+}
+
+func (m *model) datastreamEntity(ds Datastream) entity {
+	meshNodeUUID := ds.MeshNodeUUID.String()
+	t := m.dataTypes[ds.DataTypeID]
+
+	e := m.newEntity(datastreamsSet, datastreamID(meshNodeUUID, ds.DataTypeID), map[string]interface{}{
+		"name":            t.Name + " of mesh node " + meshNodeUUID,
+		"description":     t.Description,
+		"observationType": observationType(t.Kind),
+		"unitOfMeasurement": map[string]interface{}{
+			"name":       t.Unit,
+			"symbol":     t.Unit,
+			"definition": "",
+		},
+		"phenomenonTime": ds.FirstMeasuredAt.UTC().Format(time.RFC3339Nano) + "/" + ds.LastMeasuredAt.UTC().Format(time.RFC3339Nano),
+	})
+	e.meshNodeUUID = meshNodeUUID
+	e.dataTypeID = ds.DataTypeID
+	return e
+}
+
+func (m *model) observationEntity(o Observation) entity {
+	meshNodeUUID := o.MeshNodeUUID.String()
+
+	e := m.newEntity(observationsSet, o.UUID, map[string]interface{}{
+		"phenomenonTime": o.MeasuredAt,
+		"resultTime":     o.MeasuredAt,
+		"result":         observationResult(m.dataTypes[o.DataTypeID].Kind, o.Value),
+	})
+	e.props["Datastream@iot.navigationLink"] = m.selfLink(datastreamsSet, datastreamID(meshNodeUUID, o.DataTypeID))
+	e.meshNodeUUID = meshNodeUUID
+	e.dataTypeID = o.DataTypeID
+	return e
+}
+
+// observationType returns the O&M observation type of a value kind.
+func observationType(kind types.ValueKind) string {
+	const om = "http://www.opengis.net/def/observationType/OGC-OM/2.0/"
+	switch kind {
+	case types.FloatValueKind:
+		return om + "OM_Measurement"
+	case types.IntegerValueKind:
+		return om + "OM_CountObservation"
+	case types.BooleanValueKind:
+		return om + "OM_TruthObservation"
+	case types.StringValueKind:
+		return om + "OM_CategoryObservation"
+	default:
+		return om + "OM_Observation"
+	}
+}
+
+// observationResult returns the value of data as JSON value of its kind.
+func observationResult(kind types.ValueKind, value string) interface{} {
+	switch kind {
+	case types.FloatValueKind, types.IntegerValueKind:
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	case types.BooleanValueKind:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	case types.JSONValueKind:
+		if json.Valid([]byte(value)) {
+			return json.RawMessage(value)
+		}
+	}
+
+	return value
+}
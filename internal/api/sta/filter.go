@@ -0,0 +1,603 @@
+package sta
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expr is an expression of the $filter query option.
+type Expr interface {
+	expr()
+}
+
+// Literal is a number (float64), string, boolean, time or nil.
+type Literal struct {
+	Value interface{}
+}
+
+// Property is a path to a property, like result or Thing/name.
+type Property struct {
+	Path string
+}
+
+// BinaryExpr is a comparison (eq, ne, gt, ge, lt, le), a logical (and, or)
+// or an arithmetic (add, sub, mul, div, mod) expression.
+type BinaryExpr struct {
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+// NotExpr negates an expression.
+type NotExpr struct {
+	X Expr
+}
+
+// Call is a call of a function, like startswith(name,'temp').
+type Call struct {
+	Name string
+	Args []Expr
+}
+
+func (Literal) expr()    {}
+func (Property) expr()   {}
+func (BinaryExpr) expr() {}
+func (NotExpr) expr()    {}
+func (Call) expr()       {}
+
+// Functions maps the supported functions to their number of arguments.
+var Functions = map[string]int{
+	"substringof": 2,
+	"contains":    2,
+	"startswith":  2,
+	"endswith":    2,
+	"tolower":     1,
+	"toupper":     1,
+	"length":      1,
+	"year":        1,
+	"month":       1,
+	"day":         1,
+	"hour":        1,
+	"minute":      1,
+	"second":      1,
+	"now":         0,
+}
+
+var (
+	comparisonOps = map[string]bool{"eq": true, "ne": true, "gt": true, "ge": true, "lt": true, "le": true}
+	additiveOps   = map[string]bool{"add": true, "sub": true}
+	multiplyOps   = map[string]bool{"mul": true, "div": true, "mod": true}
+
+	timeLiteral = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T`)
+)
+
+var errInvalidFilter = errors.New("invalid $filter")
+
+// ParseFilter parses the value of a $filter query option.
+func ParseFilter(s string) (Expr, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %s", errInvalidFilter, p.tokens[p.pos].text)
+	}
+
+	return e, nil
+}
+
+type tokenKind int
+
+const (
+	identToken tokenKind = iota
+	literalToken
+	punctToken
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ':
+			i++
+		case c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, token{kind: punctToken, text: string(c)})
+			i++
+		case c == '\'':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(s); j++ {
+				if s[j] == '\'' {
+					// Quotes are escaped by doubling them
+					if j+1 < len(s) && s[j+1] == '\'' {
+						b.WriteByte('\'')
+						j++
+						continue
+					}
+					break
+				}
+				b.WriteByte(s[j])
+			}
+			if j == len(s) {
+				return nil, fmt.Errorf("%w: unterminated string", errInvalidFilter)
+			}
+			tokens = append(tokens, token{kind: literalToken, text: s[i : j+1], value: b.String()})
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" (),'", rune(s[j])) {
+				j++
+			}
+			text := s[i:j]
+			i = j
+
+			t, err := wordToken(text)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, t)
+		}
+	}
+
+	return tokens, nil
+}
+
+func wordToken(text string) (token, error) {
+	switch text {
+	case "true", "false":
+		return token{kind: literalToken, text: text, value: text == "true"}, nil
+	case "null":
+		return token{kind: literalToken, text: text}, nil
+	}
+
+	if timeLiteral.MatchString(text) {
+		t, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			return token{}, fmt.Errorf("%w: invalid time %s", errInvalidFilter, text)
+		}
+		return token{kind: literalToken, text: text, value: t}, nil
+	}
+
+	if c := text[0]; c == '-' || c == '.' || (c >= '0' && c <= '9') {
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return token{}, fmt.Errorf("%w: invalid number %s", errInvalidFilter, text)
+		}
+		return token{kind: literalToken, text: text, value: f}, nil
+	}
+
+	return token{kind: identToken, text: text}, nil
+}
+
+type filterParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *filterParser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// peekOp returns the operator at the current position if it is one of ops.
+func (p *filterParser) peekOp(ops map[string]bool) (string, bool) {
+	t, ok := p.peek()
+	if !ok || t.kind != identToken || !ops[t.text] {
+		return "", false
+	}
+	return t.text, true
+}
+
+func (p *filterParser) expect(text string) error {
+	t, ok := p.peek()
+	if !ok || t.kind != punctToken || t.text != text {
+		return fmt.Errorf("%w: expected %s", errInvalidFilter, text)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *filterParser) parseOr() (Expr, error) {
+	return p.parseBinary(map[string]bool{"or": true}, p.parseAnd)
+}
+
+func (p *filterParser) parseAnd() (Expr, error) {
+	return p.parseBinary(map[string]bool{"and": true}, p.parseNot)
+}
+
+func (p *filterParser) parseNot() (Expr, error) {
+	if _, ok := p.peekOp(map[string]bool{"not": true}); ok {
+		p.pos++
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return NotExpr{X: x}, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (Expr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	op, ok := p.peekOp(comparisonOps)
+	if !ok {
+		return left, nil
+	}
+	p.pos++
+
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	return BinaryExpr{Op: op, Left: left, Right: right}, nil
+}
+
+func (p *filterParser) parseAdditive() (Expr, error) {
+	return p.parseBinary(additiveOps, p.parseMultiplicative)
+}
+
+func (p *filterParser) parseMultiplicative() (Expr, error) {
+	return p.parseBinary(multiplyOps, p.parsePrimary)
+}
+
+// parseBinary parses left associative expressions of operators in ops.
+func (p *filterParser) parseBinary(ops map[string]bool, next func() (Expr, error)) (Expr, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, ok := p.peekOp(ops)
+		if !ok {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		left = BinaryExpr{Op: op, Left: left, Right: right}
+	}
+}
+
+func (p *filterParser) parsePrimary() (Expr, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("%w: unexpected end", errInvalidFilter)
+	}
+	p.pos++
+
+	switch {
+	case t.kind == literalToken:
+		return Literal{Value: t.value}, nil
+	case t.kind == punctToken && t.text == "(":
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	case t.kind == identToken:
+		if next, ok := p.peek(); !ok || next.kind != punctToken || next.text != "(" {
+			return Property{Path: t.text}, nil
+		}
+		return p.parseCall(t.text)
+	default:
+		return nil, fmt.Errorf("%w: unexpected %s", errInvalidFilter, t.text)
+	}
+}
+
+func (p *filterParser) parseCall(name string) (Expr, error) {
+	argc, ok := Functions[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown function %s", errInvalidFilter, name)
+	}
+
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	call := Call{Name: name}
+	if t, ok := p.peek(); ok && t.kind == punctToken && t.text == ")" {
+		p.pos++
+	} else {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, arg)
+
+			if t, ok := p.peek(); ok && t.kind == punctToken && t.text == "," {
+				p.pos++
+				continue
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	if len(call.Args) != argc {
+		return nil, fmt.Errorf("%w: %s takes %d arguments", errInvalidFilter, name, argc)
+	}
+
+	return call, nil
+}
+
+// evaluate evaluates an expression on an entity. Properties are looked up
+// in the entity and its nested objects, missing properties are nil.
+func evaluate(e Expr, entity map[string]interface{}) interface{} {
+	switch e := e.(type) {
+	case Literal:
+		return e.Value
+	case Property:
+		return lookup(entity, e.Path)
+	case NotExpr:
+		b, _ := evaluate(e.X, entity).(bool)
+		return !b
+	case Call:
+		args := make([]interface{}, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = evaluate(arg, entity)
+		}
+		return call(e.Name, args)
+	case BinaryExpr:
+		left := evaluate(e.Left, entity)
+		switch e.Op {
+		case "and":
+			if b, _ := left.(bool); !b {
+				return false
+			}
+			b, _ := evaluate(e.Right, entity).(bool)
+			return b
+		case "or":
+			if b, _ := left.(bool); b {
+				return true
+			}
+			b, _ := evaluate(e.Right, entity).(bool)
+			return b
+		}
+
+		right := evaluate(e.Right, entity)
+		if comparisonOps[e.Op] {
+			return compareOp(e.Op, left, right)
+		}
+		return arithmetic(e.Op, left, right)
+	}
+
+	return nil
+}
+
+func lookup(entity map[string]interface{}, path string) interface{} {
+	var v interface{} = entity
+	for _, key := range strings.Split(path, "/") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+
+	return v
+}
+
+func call(name string, args []interface{}) interface{} {
+	str := func(i int) string {
+		s, _ := args[i].(string)
+		return s
+	}
+	timeArg := func() (time.Time, bool) {
+		t, ok := asTime(args[0])
+		return t, ok
+	}
+
+	switch name {
+	case "substringof":
+		return strings.Contains(str(1), str(0))
+	case "contains":
+		return strings.Contains(str(0), str(1))
+	case "startswith":
+		return strings.HasPrefix(str(0), str(1))
+	case "endswith":
+		return strings.HasSuffix(str(0), str(1))
+	case "tolower":
+		return strings.ToLower(str(0))
+	case "toupper":
+		return strings.ToUpper(str(0))
+	case "length":
+		return float64(len([]rune(str(0))))
+	case "now":
+		return time.Now()
+	}
+
+	t, ok := timeArg()
+	if !ok {
+		return nil
+	}
+
+	switch name {
+	case "year":
+		return float64(t.Year())
+	case "month":
+		return float64(t.Month())
+	case "day":
+		return float64(t.Day())
+	case "hour":
+		return float64(t.Hour())
+	case "minute":
+		return float64(t.Minute())
+	default:
+		return float64(t.Second())
+	}
+}
+
+func asTime(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return t, err == nil
+	default:
+		return time.Time{}, false
+	}
+}
+
+func asNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+func arithmetic(op string, left, right interface{}) interface{} {
+	l, ok := asNumber(left)
+	if !ok {
+		return nil
+	}
+	r, ok := asNumber(right)
+	if !ok {
+		return nil
+	}
+
+	switch op {
+	case "add":
+		return l + r
+	case "sub":
+		return l - r
+	case "mul":
+		return l * r
+	case "div":
+		return l / r
+	default:
+		return math.Mod(l, r)
+	}
+}
+
+func compareOp(op string, left, right interface{}) bool {
+	if left == nil || right == nil {
+		switch op {
+		case "eq":
+			return left == nil && right == nil
+		case "ne":
+			return (left == nil) != (right == nil)
+		default:
+			return false
+		}
+	}
+
+	c, ok := compare(left, right)
+	if !ok {
+		return op == "ne"
+	}
+
+	switch op {
+	case "eq":
+		return c == 0
+	case "ne":
+		return c != 0
+	case "gt":
+		return c > 0
+	case "ge":
+		return c >= 0
+	case "lt":
+		return c < 0
+	default:
+		return c <= 0
+	}
+}
+
+// compare compares two values of the same kind. Strings are compared as
+// times if the other value is a time.
+func compare(left, right interface{}) (int, bool) {
+	if l, ok := asNumber(left); ok {
+		r, ok := asNumber(right)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case l < r:
+			return -1, true
+		case l > r:
+			return 1, true
+		default:
+			return 0, true
+		}
+	}
+
+	_, leftIsTime := left.(time.Time)
+	_, rightIsTime := right.(time.Time)
+	if leftIsTime || rightIsTime {
+		l, ok := asTime(left)
+		if !ok {
+			return 0, false
+		}
+		r, ok := asTime(right)
+		if !ok {
+			return 0, false
+		}
+		return l.Compare(r), true
+	}
+
+	switch l := left.(type) {
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(l, r), true
+	case bool:
+		r, ok := right.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case l == r:
+			return 0, true
+		case !l:
+			return -1, true
+		default:
+			return 1, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,163 @@
+package sta
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseFilter(t *testing.T) {
+	at := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+
+	tests := []struct {
+		filter string
+		want   Expr
+	}{
+		{
+			filter: "result gt 20",
+			want:   BinaryExpr{Op: "gt", Left: Property{Path: "result"}, Right: Literal{Value: 20.0}},
+		},
+		{
+			filter: "Thing/name eq 'O''Brien''s'",
+			want:   BinaryExpr{Op: "eq", Left: Property{Path: "Thing/name"}, Right: Literal{Value: "O'Brien's"}},
+		},
+		{
+			filter: "phenomenonTime ge 2023-11-14T22:13:20Z and result ne null",
+			want: BinaryExpr{
+				Op:    "and",
+				Left:  BinaryExpr{Op: "ge", Left: Property{Path: "phenomenonTime"}, Right: Literal{Value: at}},
+				Right: BinaryExpr{Op: "ne", Left: Property{Path: "result"}, Right: Literal{}},
+			},
+		},
+		{
+			// and binds tighter than or
+			filter: "a eq 1 or b eq 2 and c eq 3",
+			want: BinaryExpr{
+				Op:   "or",
+				Left: BinaryExpr{Op: "eq", Left: Property{Path: "a"}, Right: Literal{Value: 1.0}},
+				Right: BinaryExpr{
+					Op:    "and",
+					Left:  BinaryExpr{Op: "eq", Left: Property{Path: "b"}, Right: Literal{Value: 2.0}},
+					Right: BinaryExpr{Op: "eq", Left: Property{Path: "c"}, Right: Literal{Value: 3.0}},
+				},
+			},
+		},
+		{
+			// mul binds tighter than sub, which is left associative
+			filter: "result sub 1 sub 2 mul 3 lt -0.5",
+			want: BinaryExpr{
+				Op: "lt",
+				Left: BinaryExpr{
+					Op:    "sub",
+					Left:  BinaryExpr{Op: "sub", Left: Property{Path: "result"}, Right: Literal{Value: 1.0}},
+					Right: BinaryExpr{Op: "mul", Left: Literal{Value: 2.0}, Right: Literal{Value: 3.0}},
+				},
+				Right: Literal{Value: -0.5},
+			},
+		},
+		{
+			filter: "not (startswith(tolower(name),'temp') or true)",
+			want: NotExpr{X: BinaryExpr{
+				Op: "or",
+				Left: Call{Name: "startswith", Args: []Expr{
+					Call{Name: "tolower", Args: []Expr{Property{Path: "name"}}},
+					Literal{Value: "temp"},
+				}},
+				Right: Literal{Value: true},
+			}},
+		},
+		{
+			filter: "year(phenomenonTime) eq year(now())",
+			want: BinaryExpr{
+				Op:    "eq",
+				Left:  Call{Name: "year", Args: []Expr{Property{Path: "phenomenonTime"}}},
+				Right: Call{Name: "year", Args: []Expr{Call{Name: "now"}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseFilter(tt.filter)
+		if err != nil {
+			t.Errorf("ParseFilter(%q) error = %v", tt.filter, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseFilter(%q) = %+v, want %+v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestParseFilterInvalid(t *testing.T) {
+	for _, filter := range []string{
+		"",
+		"result gt",
+		"result gt 20 20",
+		"name eq 'unterminated",
+		"(result gt 20",
+		"result gt 20)",
+		"result gt 1.2.3",
+		"phenomenonTime gt 2023-13-01T00:00:00Z",
+		"unknown(name)",
+		"startswith(name)",
+		"length(name,'a')",
+		"startswith name",
+		"eq 1",
+	} {
+		if _, err := ParseFilter(filter); !errors.Is(err, errInvalidFilter) {
+			t.Errorf("ParseFilter(%q) error = %v, want %v", filter, err, errInvalidFilter)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	entity := map[string]interface{}{
+		"name":           "Temperature",
+		"result":         21.5,
+		"count":          3,
+		"open":           true,
+		"phenomenonTime": "2023-11-14T22:13:20Z",
+		"Thing":          map[string]interface{}{"name": "Kitchen"},
+	}
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{filter: "result gt 20", want: true},
+		{filter: "result le 21", want: false},
+		{filter: "count eq 3", want: true},
+		{filter: "result add 0.5 eq 22 and count mod 2 eq 1", want: true},
+		{filter: "result div 0 gt 1000", want: true},
+		{filter: "Thing/name eq 'Kitchen'", want: true},
+		{filter: "Thing/unknown eq null", want: true},
+		{filter: "Thing/name/unknown ne null", want: false},
+		{filter: "unknown gt 1", want: false},
+		{filter: "name eq 21.5", want: false},
+		{filter: "name ne 21.5", want: true},
+		{filter: "open and not false", want: true},
+		{filter: "name or open", want: true},
+		{filter: "open gt false", want: true},
+		{filter: "substringof('per',name) and contains(name,'per')", want: true},
+		{filter: "startswith(name,'Temp') and endswith(toupper(name),'URE')", want: true},
+		{filter: "length(name) eq 11", want: true},
+		{filter: "phenomenonTime lt 2023-11-14T22:13:21Z", want: true},
+		{filter: "2023-11-14T23:13:20+01:00 eq phenomenonTime", want: true},
+		{filter: "year(phenomenonTime) eq 2023 and month(phenomenonTime) eq 11 and day(phenomenonTime) eq 14", want: true},
+		{filter: "hour(phenomenonTime) eq 22 and minute(phenomenonTime) eq 13 and second(phenomenonTime) eq 20", want: true},
+		{filter: "year(name) eq null", want: true},
+		{filter: "phenomenonTime lt now()", want: true},
+	}
+
+	for _, tt := range tests {
+		e, err := ParseFilter(tt.filter)
+		if err != nil {
+			t.Errorf("ParseFilter(%q) error = %v", tt.filter, err)
+			continue
+		}
+		if got := evaluate(e, entity); got != tt.want {
+			t.Errorf("evaluate(%q) = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
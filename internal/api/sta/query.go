@@ -0,0 +1,292 @@
+package sta
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultTop = 100
+	maxTop     = 10000
+)
+
+// OrderBy is an item of the $orderby query option.
+type OrderBy struct {
+	Property   string
+	Descending bool
+}
+
+// queryOptions are the query options of a collection. top is -1 if not
+// given.
+type queryOptions struct {
+	filter  Expr
+	orderBy []OrderBy
+	top     int
+	skip    int
+	count   bool
+	selects []string
+	expand  []expandItem
+	// raw are the options as given in the request.
+	raw map[string]string
+}
+
+// expandItem is a navigation property of the $expand query option with the
+// query options of the expanded entities.
+type expandItem struct {
+	name    string
+	options queryOptions
+}
+
+var errInvalidQuery = errors.New("invalid query")
+
+// parseQueryOptions parses the query options of a raw query. Options are
+// only separated by ampersands, as the options of expanded navigation
+// properties are separated by semicolons.
+func parseQueryOptions(rawQuery string) (queryOptions, error) {
+	options := map[string]string{}
+	for _, option := range strings.Split(rawQuery, "&") {
+		if option == "" {
+			continue
+		}
+
+		key, value, _ := strings.Cut(option, "=")
+		key, err := url.QueryUnescape(key)
+		if err != nil {
+			return queryOptions{}, fmt.Errorf("%w: %s", errInvalidQuery, err)
+		}
+		value, err = url.QueryUnescape(value)
+		if err != nil {
+			return queryOptions{}, fmt.Errorf("%w: %s", errInvalidQuery, err)
+		}
+		options[key] = value
+	}
+
+	return parseOptions(options)
+}
+
+// parseNestedOptions parses query options separated by semicolons, like
+// the options of an expanded navigation property.
+func parseNestedOptions(s string) (queryOptions, error) {
+	options := map[string]string{}
+	for _, option := range splitTopLevel(s, ';') {
+		key, value, ok := strings.Cut(option, "=")
+		if !ok {
+			return queryOptions{}, fmt.Errorf("%w: invalid option %s", errInvalidQuery, option)
+		}
+		options[strings.TrimSpace(key)] = value
+	}
+
+	return parseOptions(options)
+}
+
+func parseOptions(options map[string]string) (queryOptions, error) {
+	q := queryOptions{top: -1, raw: options}
+	for key, value := range options {
+		var err error
+		switch key {
+		case "$filter":
+			q.filter, err = ParseFilter(value)
+		case "$orderby":
+			q.orderBy, err = parseOrderBy(value)
+		case "$top":
+			q.top, err = strconv.Atoi(value)
+			if err == nil && (q.top < 0 || q.top > maxTop) {
+				err = fmt.Errorf("%w: $top must be between 0 and %d", errInvalidQuery, maxTop)
+			}
+		case "$skip":
+			q.skip, err = strconv.Atoi(value)
+			if err == nil && q.skip < 0 {
+				err = fmt.Errorf("%w: $skip must not be negative", errInvalidQuery)
+			}
+		case "$count":
+			q.count, err = strconv.ParseBool(value)
+		case "$select":
+			for _, s := range strings.Split(value, ",") {
+				q.selects = append(q.selects, strings.TrimSpace(s))
+			}
+		case "$expand":
+			q.expand, err = parseExpand(value)
+		default:
+			if strings.HasPrefix(key, "$") {
+				err = fmt.Errorf("%w: unsupported option %s", errInvalidQuery, key)
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, errInvalidQuery) && !errors.Is(err, errInvalidFilter) {
+				err = fmt.Errorf("%w: %s: %s", errInvalidQuery, key, err)
+			}
+			return queryOptions{}, err
+		}
+	}
+
+	return q, nil
+}
+
+func parseOrderBy(s string) ([]OrderBy, error) {
+	var orderBy []OrderBy
+	for _, item := range strings.Split(s, ",") {
+		fields := strings.Fields(item)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("%w: invalid $orderby %s", errInvalidQuery, item)
+		}
+
+		o := OrderBy{Property: fields[0]}
+		if len(fields) == 2 {
+			switch fields[1] {
+			case "asc":
+			case "desc":
+				o.Descending = true
+			default:
+				return nil, fmt.Errorf("%w: invalid $orderby %s", errInvalidQuery, item)
+			}
+		}
+		orderBy = append(orderBy, o)
+	}
+
+	return orderBy, nil
+}
+
+// parseExpand parses items like Datastreams($top=1;$expand=Observations)
+// and the shorthand Datastreams/Observations.
+func parseExpand(s string) ([]expandItem, error) {
+	var items []expandItem
+	for _, item := range splitTopLevel(s, ',') {
+		item = strings.TrimSpace(item)
+
+		name, rest := item, ""
+		if i := strings.IndexAny(item, "(/"); i >= 0 {
+			name, rest = item[:i], item[i:]
+		}
+		if name == "" {
+			return nil, fmt.Errorf("%w: invalid $expand %s", errInvalidQuery, item)
+		}
+
+		e := expandItem{name: name, options: queryOptions{top: -1}}
+		if strings.HasPrefix(rest, "(") {
+			end := matchingParen(rest)
+			if end < 0 {
+				return nil, fmt.Errorf("%w: invalid $expand %s", errInvalidQuery, item)
+			}
+
+			var err error
+			e.options, err = parseNestedOptions(rest[1:end])
+			if err != nil {
+				return nil, err
+			}
+			rest = rest[end+1:]
+		}
+
+		if strings.HasPrefix(rest, "/") {
+			nested, err := parseExpand(rest[1:])
+			if err != nil {
+				return nil, err
+			}
+			e.options.expand = append(e.options.expand, nested...)
+		} else if rest != "" {
+			return nil, fmt.Errorf("%w: invalid $expand %s", errInvalidQuery, item)
+		}
+
+		items = append(items, e)
+	}
+
+	return items, nil
+}
+
+// splitTopLevel splits s at every sep outside of parentheses and quotes.
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	var depth int
+	var quoted bool
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\'':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// matchingParen returns the index of the parenthesis closing the one at the
+// start of s or -1.
+func matchingParen(s string) int {
+	var depth int
+	var quoted bool
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\'':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// limit returns the number of entities of a page.
+func (q queryOptions) limit() int {
+	if q.top < 0 {
+		return defaultTop
+	}
+	return q.top
+}
+
+// apply filters, sorts and pages entities in memory. It returns the page,
+// the number of entities matching the filter and whether there are more.
+func (q queryOptions) apply(entities []entity) ([]entity, int, bool) {
+	var filtered []entity
+	for _, e := range entities {
+		if q.filter == nil {
+			filtered = append(filtered, e)
+			continue
+		}
+		if b, _ := evaluate(q.filter, e.view()).(bool); b {
+			filtered = append(filtered, e)
+		}
+	}
+
+	if len(q.orderBy) > 0 {
+		sort.SliceStable(filtered, func(i, j int) bool {
+			a, b := filtered[i].view(), filtered[j].view()
+			for _, o := range q.orderBy {
+				c, ok := compare(lookup(a, o.Property), lookup(b, o.Property))
+				if !ok || c == 0 {
+					continue
+				}
+				return (c < 0) != o.Descending
+			}
+			return false
+		})
+	}
+
+	count := len(filtered)
+	if q.skip >= len(filtered) {
+		return nil, count, false
+	}
+	filtered = filtered[q.skip:]
+
+	if len(filtered) > q.limit() {
+		return filtered[:q.limit()], count, true
+	}
+	return filtered, count, false
+}
@@ -0,0 +1,92 @@
+package sta
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseQueryOptions(t *testing.T) {
+	q, err := parseQueryOptions("$top=5&$skip=10&$count=true&$orderby=phenomenonTime%20desc,id&$select=id,%20result&$filter=result%20gt%2020")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if q.top != 5 || q.skip != 10 || !q.count {
+		t.Errorf("top, skip, count = %d, %d, %v, want 5, 10, true", q.top, q.skip, q.count)
+	}
+	if want := []OrderBy{{Property: "phenomenonTime", Descending: true}, {Property: "id"}}; !reflect.DeepEqual(q.orderBy, want) {
+		t.Errorf("orderBy = %+v, want %+v", q.orderBy, want)
+	}
+	if want := []string{"id", "result"}; !reflect.DeepEqual(q.selects, want) {
+		t.Errorf("selects = %v, want %v", q.selects, want)
+	}
+	if q.filter == nil {
+		t.Error("filter is missing")
+	}
+
+	q, err = parseQueryOptions("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.top != -1 || q.limit() != defaultTop {
+		t.Errorf("top, limit() = %d, %d, want -1, %d", q.top, q.limit(), defaultTop)
+	}
+}
+
+func TestParseQueryOptionsInvalid(t *testing.T) {
+	tests := []struct {
+		query string
+		err   error
+	}{
+		{query: "$top=-1", err: errInvalidQuery},
+		{query: "$top=10001", err: errInvalidQuery},
+		{query: "$top=many", err: errInvalidQuery},
+		{query: "$skip=-1", err: errInvalidQuery},
+		{query: "$count=maybe", err: errInvalidQuery},
+		{query: "$orderby=id%20up", err: errInvalidQuery},
+		{query: "$orderby=", err: errInvalidQuery},
+		{query: "$search=temperature", err: errInvalidQuery},
+		{query: "$top=%zz", err: errInvalidQuery},
+		{query: "$expand=Datastreams(", err: errInvalidQuery},
+		{query: "$expand=Datastreams($top)", err: errInvalidQuery},
+		{query: "$filter=result%20gt", err: errInvalidFilter},
+		{query: "$expand=Datastreams($filter=name%20eq)", err: errInvalidFilter},
+	}
+
+	for _, tt := range tests {
+		if _, err := parseQueryOptions(tt.query); !errors.Is(err, tt.err) {
+			t.Errorf("parseQueryOptions(%q) error = %v, want %v", tt.query, err, tt.err)
+		}
+	}
+}
+
+func TestParseExpand(t *testing.T) {
+	items, err := parseExpand("Datastreams($top=1;$filter=name eq 'a;b';$expand=Observations($orderby=id)),Locations/HistoricalLocations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 2 {
+		t.Fatalf("parseExpand() returned %d items, want 2", len(items))
+	}
+
+	datastreams := items[0]
+	if datastreams.name != "Datastreams" || datastreams.options.top != 1 || datastreams.options.filter == nil {
+		t.Errorf("Datastreams = %+v", datastreams)
+	}
+	if len(datastreams.options.expand) != 1 || datastreams.options.expand[0].name != "Observations" {
+		t.Fatalf("Datastreams expand = %+v, want Observations", datastreams.options.expand)
+	}
+	if want := []OrderBy{{Property: "id"}}; !reflect.DeepEqual(datastreams.options.expand[0].options.orderBy, want) {
+		t.Errorf("Observations orderBy = %+v, want %+v", datastreams.options.expand[0].options.orderBy, want)
+	}
+
+	locations := items[1]
+	if locations.name != "Locations" || locations.options.top != -1 {
+		t.Errorf("Locations = %+v", locations)
+	}
+	if len(locations.options.expand) != 1 || locations.options.expand[0].name != "HistoricalLocations" {
+		t.Errorf("Locations expand = %+v, want HistoricalLocations", locations.options.expand)
+	}
+}
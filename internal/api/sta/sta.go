@@ -0,0 +1,488 @@
+package sta
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/mdma-backend/mdma-backend/internal/api/auth"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+	"github.com/mdma-backend/mdma-backend/internal/types/permission"
+)
+
+// Datastream is the data of a data type measured by a mesh node.
+type Datastream struct {
+	MeshNodeUUID    types.UUID
+	DataTypeID      types.DataTypeID
+	FirstMeasuredAt time.Time
+	LastMeasuredAt  time.Time
+}
+
+// Observation is a single measurement.
+type Observation struct {
+	UUID         string
+	MeshNodeUUID types.UUID
+	DataTypeID   types.DataTypeID
+	MeasuredAt   time.Time
+	Value        string
+}
+
+// ObservationQuery selects observations, optionally of a single datastream.
+// The filter and order may only use the properties @iot.id, phenomenonTime,
+// resultTime and result of Observations and the ids of their Datastream,
+// Thing and ObservedProperty. Stores return types.ErrInvalidValue for other
+// properties. Observations are limited to the mesh nodes in MeshNodeUUIDs
+// unless it is nil.
+type ObservationQuery struct {
+	MeshNodeUUID  *types.UUID
+	MeshNodeUUIDs []types.UUID
+	DataTypeID    *types.DataTypeID
+	Filter        Expr
+	OrderBy       []OrderBy
+	Limit         int
+	Offset        int
+}
+
+type Store interface {
+	MeshNodes() ([]types.MeshNode, error)
+	DataTypes() ([]types.DataType, error)
+	Datastreams() ([]Datastream, error)
+	Observations(ObservationQuery) ([]Observation, error)
+	CountObservations(ObservationQuery) (int, error)
+}
+
+type service struct {
+	handler http.Handler
+	store   Store
+}
+
+// NewService returns a read-only implementation of the sensing part of the
+// OGC SensorThings API 1.1 without HistoricalLocations and
+// FeaturesOfInterest.
+func NewService(store Store) http.Handler {
+	r := chi.NewRouter()
+	s := service{
+		handler: r,
+		store:   store,
+	}
+
+	r.Get("/", auth.RestrictHandlerFunc(s.getRoot(), permission.MeshNodeRead, permission.DataRead))
+	r.Get("/*", auth.RestrictHandlerFunc(s.getResource(), permission.MeshNodeRead, permission.DataRead))
+
+	return s
+}
+
+func (s service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// baseURL returns the absolute URL the service is mounted at.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	// The route path is escaped if the path of the request is
+	path := r.URL.RawPath
+	if path == "" {
+		path = r.URL.Path
+	}
+
+	mountPath := strings.TrimSuffix(path, chi.RouteContext(r.Context()).RoutePath)
+	return scheme + "://" + r.Host + strings.TrimSuffix(mountPath, "/")
+}
+
+// resourcePath returns the unescaped resource path of a request, like
+// Things('id')/Datastreams.
+func resourcePath(r *http.Request) string {
+	path := chi.URLParam(r, "*")
+	if unescaped, err := url.PathUnescape(path); err == nil {
+		return unescaped
+	}
+	return path
+}
+
+func (s service) getRoot() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		base := baseURL(r)
+
+		sets := make([]map[string]string, 0, len(entitySets))
+		for _, set := range entitySets {
+			sets = append(sets, map[string]string{
+				"name": set,
+				"url":  base + "/" + set,
+			})
+		}
+
+		render.JSON(w, r, map[string]interface{}{
+			"value": sets,
+			"serverSettings": map[string]interface{}{
+				"conformance": []string{
+					"http://www.opengis.net/spec/iot_sensing/1.1/req/datamodel",
+					"http://www.opengis.net/spec/iot_sensing/1.1/req/resource-path/resource-path-to-entities",
+					"http://www.opengis.net/spec/iot_sensing/1.1/req/request-data",
+				},
+			},
+		})
+	}
+}
+
+// collection is a set of entities, optionally related to a parent entity.
+type collection struct {
+	set    string
+	parent *entity
+}
+
+var errNotFound = errors.New("not found")
+
+func (s service) getResource() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		options, err := parseQueryOptions(r.URL.RawQuery)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		m := &model{
+			store:   s.store,
+			baseURL: baseURL(r),
+		}
+		if scope, scoped := auth.MeshNodesInScope(r); scoped {
+			m.scope = scope
+		}
+
+		c, single, err := m.resolve(resourcePath(r))
+		if errors.Is(err, errNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if errors.Is(err, types.ErrInvalidValue) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var response interface{}
+		if single != nil {
+			err = m.expand(single, options.expand)
+			response = selectProps(*single, options)
+		} else {
+			response, err = m.collectionResponse(r, c, options)
+		}
+		if errors.Is(err, types.ErrInvalidValue) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// resolve resolves a resource path like Things('id')/Datastreams to a
+// collection or a single entity.
+func (m *model) resolve(path string) (collection, *entity, error) {
+	var c collection
+	var single *entity
+	for i, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		name, key, hasKey, err := parseSegment(segment)
+		if err != nil {
+			return collection{}, nil, err
+		}
+
+		if i == 0 {
+			c = collection{set: name}
+			if !isEntitySet(name) {
+				return collection{}, nil, fmt.Errorf("%w: %s", errNotFound, name)
+			}
+		} else {
+			if single == nil {
+				return collection{}, nil, fmt.Errorf("%w: %s", errNotFound, segment)
+			}
+
+			nav, ok := navigations[single.set][name]
+			if !ok {
+				return collection{}, nil, fmt.Errorf("%w: %s has no %s", errNotFound, single.set, name)
+			}
+			c = collection{set: nav.set, parent: single}
+			single = nil
+
+			if !nav.many {
+				if hasKey {
+					return collection{}, nil, fmt.Errorf("%w: %s", errNotFound, segment)
+				}
+
+				entities, _, _, err := m.list(c, queryOptions{top: 1})
+				if err != nil {
+					return collection{}, nil, err
+				}
+				if len(entities) == 0 {
+					return collection{}, nil, fmt.Errorf("%w: %s", errNotFound, segment)
+				}
+				single = &entities[0]
+				continue
+			}
+		}
+
+		if hasKey {
+			e, err := m.get(c, key)
+			if err != nil {
+				return collection{}, nil, err
+			}
+			single = &e
+		}
+	}
+
+	return c, single, nil
+}
+
+func isEntitySet(name string) bool {
+	for _, set := range entitySets {
+		if set == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSegment parses a path segment like Things, Things('id') or
+// ObservedProperties(1).
+func parseSegment(segment string) (string, string, bool, error) {
+	name, key, ok := strings.Cut(segment, "(")
+	if !ok {
+		return segment, "", false, nil
+	}
+
+	if !strings.HasSuffix(key, ")") {
+		return "", "", false, fmt.Errorf("%w: invalid segment %s", errNotFound, segment)
+	}
+	key = strings.TrimSuffix(key, ")")
+
+	if len(key) >= 2 && key[0] == '\'' && key[len(key)-1] == '\'' {
+		return name, strings.ReplaceAll(key[1:len(key)-1], "''", "'"), true, nil
+	}
+
+	if _, err := strconv.ParseUint(key, 10, 64); err != nil {
+		return "", "", false, fmt.Errorf("%w: invalid key %s", errNotFound, key)
+	}
+	return name, key, true, nil
+}
+
+// list returns a page of the entities of a collection, the number of
+// entities matching the filter if counted and whether there are more.
+func (m *model) list(c collection, options queryOptions) ([]entity, int, bool, error) {
+	if c.set == observationsSet {
+		return m.listObservations(c, options)
+	}
+
+	all, err := m.entities(c.set)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	var entities []entity
+	for _, e := range all {
+		if c.parent == nil || c.parent.relatedTo(e) {
+			entities = append(entities, e)
+		}
+	}
+
+	page, count, more := options.apply(entities)
+	return page, count, more, nil
+}
+
+func (m *model) listObservations(c collection, options queryOptions) ([]entity, int, bool, error) {
+	if err := m.loadDataTypes(); err != nil {
+		return nil, 0, false, err
+	}
+
+	q := ObservationQuery{
+		Filter:  options.filter,
+		OrderBy: options.orderBy,
+		// One more observation than requested tells if there are more
+		Limit:  options.limit() + 1,
+		Offset: options.skip,
+	}
+	if m.scope != nil {
+		q.MeshNodeUUIDs = []types.UUID{}
+		for uuid := range m.scope {
+			meshNodeUUID, err := types.UUIDFromString(uuid)
+			if err != nil {
+				return nil, 0, false, err
+			}
+			q.MeshNodeUUIDs = append(q.MeshNodeUUIDs, meshNodeUUID)
+		}
+	}
+	if c.parent != nil {
+		meshNodeUUID, err := types.UUIDFromString(c.parent.meshNodeUUID)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		dataTypeID := c.parent.dataTypeID
+		q.MeshNodeUUID = &meshNodeUUID
+		q.DataTypeID = &dataTypeID
+	}
+
+	observations, err := m.store.Observations(q)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	var count int
+	if options.count {
+		count, err = m.store.CountObservations(q)
+		if err != nil {
+			return nil, 0, false, err
+		}
+	}
+
+	more := len(observations) > options.limit()
+	if more {
+		observations = observations[:options.limit()]
+	}
+
+	entities := make([]entity, 0, len(observations))
+	for _, o := range observations {
+		entities = append(entities, m.observationEntity(o))
+	}
+
+	return entities, count, more, nil
+}
+
+// get returns the entity of a collection with an id.
+func (m *model) get(c collection, id string) (entity, error) {
+	options := queryOptions{top: -1, filter: BinaryExpr{
+		Op:    "eq",
+		Left:  Property{Path: "@iot.id"},
+		Right: Literal{Value: id},
+	}}
+	if c.set == observedPropertiesSet {
+		n, _ := strconv.ParseFloat(id, 64)
+		options.filter = BinaryExpr{Op: "eq", Left: Property{Path: "@iot.id"}, Right: Literal{Value: n}}
+	}
+
+	entities, _, _, err := m.list(c, options)
+	if err != nil {
+		return entity{}, err
+	}
+	if len(entities) == 0 {
+		return entity{}, fmt.Errorf("%w: %s(%s)", errNotFound, c.set, keyLiteral(c.set, id))
+	}
+
+	return entities[0], nil
+}
+
+// expand adds the expanded navigation properties to an entity.
+func (m *model) expand(e *entity, items []expandItem) error {
+	for _, item := range items {
+		nav, ok := navigations[e.set][item.name]
+		if !ok {
+			return fmt.Errorf("%w: %s has no %s", types.ErrInvalidValue, e.set, item.name)
+		}
+
+		c := collection{set: nav.set, parent: e}
+		entities, count, more, err := m.list(c, item.options)
+		if err != nil {
+			return err
+		}
+
+		values := make([]map[string]interface{}, 0, len(entities))
+		for i := range entities {
+			if err := m.expand(&entities[i], item.options.expand); err != nil {
+				return err
+			}
+			values = append(values, selectProps(entities[i], item.options))
+		}
+
+		if !nav.many {
+			if len(values) > 0 {
+				e.props[item.name] = values[0]
+			}
+			continue
+		}
+
+		e.props[item.name] = values
+		if item.options.count {
+			e.props[item.name+"@iot.count"] = count
+		}
+		if more {
+			e.props[item.name+"@iot.nextLink"] = nextLink(m.selfLink(e.set, e.id)+"/"+item.name, item.options)
+		}
+	}
+
+	return nil
+}
+
+func (m *model) collectionResponse(r *http.Request, c collection, options queryOptions) (map[string]interface{}, error) {
+	entities, count, more, err := m.list(c, options)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]map[string]interface{}, 0, len(entities))
+	for i := range entities {
+		if err := m.expand(&entities[i], options.expand); err != nil {
+			return nil, err
+		}
+		values = append(values, selectProps(entities[i], options))
+	}
+
+	response := map[string]interface{}{
+		"value": values,
+	}
+	if options.count {
+		response["@iot.count"] = count
+	}
+	if more {
+		response["@iot.nextLink"] = nextLink(m.baseURL+"/"+resourcePath(r), options)
+	}
+
+	return response, nil
+}
+
+// nextLink returns the link to the next page of entities with their query
+// options.
+func nextLink(link string, options queryOptions) string {
+	query := url.Values{}
+	for key, value := range options.raw {
+		query.Set(key, value)
+	}
+	query.Set("$skip", strconv.Itoa(options.skip+options.limit()))
+	return link + "?" + query.Encode()
+}
+
+// selectProps returns the selected and expanded properties of an entity.
+func selectProps(e entity, options queryOptions) map[string]interface{} {
+	if len(options.selects) == 0 {
+		return e.props
+	}
+
+	props := map[string]interface{}{}
+	for _, name := range options.selects {
+		if name == "id" {
+			name = "@iot.id"
+		}
+		if v, ok := e.props[name]; ok {
+			props[name] = v
+		}
+	}
+	for _, item := range options.expand {
+		for _, name := range []string{item.name, item.name + "@iot.count", item.name + "@iot.nextLink"} {
+			if v, ok := e.props[name]; ok {
+				props[name] = v
+			}
+		}
+	}
+
+	return props
+}
@@ -0,0 +1,90 @@
+package hub
+
+import (
+	"sync"
+
+	"github.com/mdma-backend/mdma-backend/internal/api/data"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	hubSubscribers = promauto.NewGauge(
+		prometheus.GaugeOpts{Name: "hub_subscribers"},
+	)
+	hubDropped = promauto.NewCounter(
+		prometheus.CounterOpts{Name: "hub_dropped"},
+	)
+)
+
+// Hub fans newly ingested data out to all subscribers in this process.
+// Publishing never blocks: data is dropped for subscribers that do not keep
+// up.
+type Hub struct {
+	mu          sync.RWMutex
+	subscribers map[*subscriber]struct{}
+	bufferSize  int
+}
+
+type subscriber struct {
+	c      chan data.Data
+	filter func(data.Data) bool
+}
+
+func New(bufferSize int) *Hub {
+	return &Hub{
+		subscribers: map[*subscriber]struct{}{},
+		bufferSize:  bufferSize,
+	}
+}
+
+// Publish sends data to all matching subscribers. Duplicates of already
+// stored data were published before and are skipped.
+func (h *Hub) Publish(dd ...data.Data) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for s := range h.subscribers {
+		for _, d := range dd {
+			if d.Duplicate {
+				continue
+			}
+
+			if s.filter != nil && !s.filter(d) {
+				continue
+			}
+
+			select {
+			case s.c <- d:
+			default:
+				hubDropped.Inc()
+			}
+		}
+	}
+}
+
+// Subscribe returns a channel that receives all published data that matches
+// filter. A nil filter matches all data. The returned function unsubscribes
+// and closes the channel.
+func (h *Hub) Subscribe(filter func(data.Data) bool) (<-chan data.Data, func()) {
+	s := &subscriber{
+		c:      make(chan data.Data, h.bufferSize),
+		filter: filter,
+	}
+
+	h.mu.Lock()
+	h.subscribers[s] = struct{}{}
+	h.mu.Unlock()
+	hubSubscribers.Inc()
+
+	var once sync.Once
+	return s.c, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subscribers, s)
+			h.mu.Unlock()
+			hubSubscribers.Dec()
+			close(s.c)
+		})
+	}
+}
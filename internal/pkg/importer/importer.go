@@ -0,0 +1,367 @@
+// Package importer loads CSV files of historical measurements into the
+// store. Imports are processed in batches that each advance the progress of
+// the import job, so an interrupted import resumes after its last batch.
+package importer
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+// batchSize is the number of rows that are validated and stored at once.
+const batchSize = 5000
+
+var (
+	ErrRunning   = errors.New("data import is already running")
+	ErrCompleted = errors.New("data import is already completed")
+)
+
+type Store interface {
+	DataImportByID(types.DataImportID) (types.DataImport, error)
+	DataImports() ([]types.DataImport, error)
+	SetDataImportStatus(id types.DataImportID, status types.DataImportStatus, message string) error
+	// LockDataImport claims the import for this process until unlock is
+	// called. It fails with types.ErrConflict if another process holds it.
+	LockDataImport(types.DataImportID) (unlock func(), err error)
+	// ImportData stores a batch and advances the progress of the import from
+	// fromRows to processedRows. It fails with types.ErrConflict if the
+	// progress is no longer fromRows.
+	ImportData(id types.DataImportID, rows []types.DataImportRow, rejected []types.DataImportError, fromRows int, processedRows int) error
+}
+
+type Importer struct {
+	store  Store
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	running map[types.DataImportID]func()
+}
+
+func New(store Store) *Importer {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Importer{
+		store:   store,
+		ctx:     ctx,
+		cancel:  cancel,
+		running: map[types.DataImportID]func(){},
+	}
+}
+
+// Start runs the import in the background.
+func (i *Importer) Start(id types.DataImportID) error {
+	if err := i.acquire(id); err != nil {
+		return err
+	}
+
+	i.wg.Add(1)
+	go func() {
+		defer i.wg.Done()
+		defer i.release(id)
+
+		if err := i.run(i.ctx, id); err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("data import %d: %s", id, err)
+		}
+	}()
+
+	return nil
+}
+
+// Run runs the import until it is completed, failed or ctx is canceled.
+func (i *Importer) Run(ctx context.Context, id types.DataImportID) error {
+	if err := i.acquire(id); err != nil {
+		return err
+	}
+	defer i.release(id)
+
+	return i.run(ctx, id)
+}
+
+// Resume starts all imports that are pending or were interrupted while
+// running.
+func (i *Importer) Resume() error {
+	imports, err := i.store.DataImports()
+	if err != nil {
+		return err
+	}
+
+	for _, dataImport := range imports {
+		if dataImport.Status != types.DataImportPending && dataImport.Status != types.DataImportRunning {
+			continue
+		}
+
+		if err := i.Start(dataImport.ID); err != nil && !errors.Is(err, ErrRunning) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stop interrupts all running imports and waits for their current batch.
+// They are pending again and resumed on the next start.
+func (i *Importer) Stop() {
+	i.cancel()
+	i.wg.Wait()
+}
+
+// acquire claims the import in the store, so it is not run twice even by
+// several instances of the backend.
+func (i *Importer) acquire(id types.DataImportID) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, ok := i.running[id]; ok {
+		return ErrRunning
+	}
+
+	unlock, err := i.store.LockDataImport(id)
+	if errors.Is(err, types.ErrConflict) {
+		return ErrRunning
+	} else if err != nil {
+		return err
+	}
+	i.running[id] = unlock
+
+	return nil
+}
+
+func (i *Importer) release(id types.DataImportID) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if unlock, ok := i.running[id]; ok {
+		unlock()
+		delete(i.running, id)
+	}
+}
+
+func (i *Importer) run(ctx context.Context, id types.DataImportID) error {
+	dataImport, err := i.store.DataImportByID(id)
+	if err != nil {
+		return err
+	}
+
+	if dataImport.Status == types.DataImportCompleted {
+		return ErrCompleted
+	}
+
+	if err := i.store.SetDataImportStatus(id, types.DataImportRunning, ""); err != nil {
+		return err
+	}
+
+	err = i.importRows(ctx, dataImport)
+	switch {
+	case errors.Is(err, types.ErrConflict):
+		// Another process runs the import and keeps its status
+		return err
+	case errors.Is(err, context.Canceled):
+		if err := i.store.SetDataImportStatus(id, types.DataImportPending, ""); err != nil {
+			return err
+		}
+		return err
+	case err != nil:
+		if err := i.store.SetDataImportStatus(id, types.DataImportFailed, err.Error()); err != nil {
+			return err
+		}
+		return err
+	}
+
+	return i.store.SetDataImportStatus(id, types.DataImportCompleted, "")
+}
+
+func (i *Importer) importRows(ctx context.Context, dataImport types.DataImport) error {
+	mapping := dataImport.Mapping
+	if err := mapping.Validate(); err != nil {
+		return err
+	}
+
+	timeFormat := mapping.TimeFormat
+	if timeFormat == "" {
+		timeFormat = time.RFC3339
+	}
+
+	reader := csv.NewReader(bytes.NewReader(dataImport.Data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	columns, err := mapColumns(reader, mapping)
+	if err != nil {
+		return err
+	}
+
+	processedRows := 0
+	committedRows := dataImport.ProcessedRows
+	var rows []types.DataImportRow
+	var rejected []types.DataImportError
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		processedRows++
+		// Rows of earlier runs were already imported or rejected
+		if processedRows <= dataImport.ProcessedRows {
+			continue
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rejected = append(rejected, types.DataImportError{Line: parseErr.StartLine, Message: parseErr.Err.Error()})
+		} else if err != nil {
+			return err
+		} else {
+			line, _ := reader.FieldPos(0)
+			row, err := parseRow(record, columns, timeFormat)
+			if err != nil {
+				rejected = append(rejected, types.DataImportError{Line: line, Message: err.Error()})
+			} else {
+				row.Line = line
+				rows = append(rows, row)
+			}
+		}
+
+		if len(rows)+len(rejected) < batchSize {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := i.store.ImportData(dataImport.ID, rows, rejected, committedRows, processedRows); err != nil {
+			return err
+		}
+		committedRows = processedRows
+		rows, rejected = rows[:0], rejected[:0]
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return i.store.ImportData(dataImport.ID, rows, rejected, committedRows, processedRows)
+}
+
+// columns are the indices of the mapped fields in a record.
+type columns struct {
+	meshNodeUUID int
+	dataType     int
+	measuredAt   int
+	value        int
+}
+
+// mapColumns resolves the mapping to column indices, reading the header if
+// the file has one.
+func mapColumns(reader *csv.Reader, mapping types.DataImportMapping) (columns, error) {
+	if !mapping.Header {
+		// Validate ensures the columns are numbers starting at 1
+		index := func(column string) int {
+			n, _ := strconv.Atoi(column)
+			return n - 1
+		}
+
+		return columns{
+			meshNodeUUID: index(mapping.MeshNodeUUID),
+			dataType:     index(mapping.Type),
+			measuredAt:   index(mapping.MeasuredAt),
+			value:        index(mapping.Value),
+		}, nil
+	}
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return columns{}, errors.New("missing header")
+	} else if err != nil {
+		return columns{}, fmt.Errorf("reading header: %w", err)
+	}
+
+	indices := map[string]int{}
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		indices[strings.TrimSpace(name)] = i
+	}
+
+	index := func(column string) (int, error) {
+		i, ok := indices[column]
+		if !ok {
+			return 0, fmt.Errorf("header has no column %q", column)
+		}
+		return i, nil
+	}
+
+	var c columns
+	if c.meshNodeUUID, err = index(mapping.MeshNodeUUID); err != nil {
+		return c, err
+	}
+	if c.dataType, err = index(mapping.Type); err != nil {
+		return c, err
+	}
+	if c.measuredAt, err = index(mapping.MeasuredAt); err != nil {
+		return c, err
+	}
+	if c.value, err = index(mapping.Value); err != nil {
+		return c, err
+	}
+
+	return c, nil
+}
+
+// parseRow checks the format of a record. Whether its mesh node and type
+// exist and its value is valid is checked by the store.
+func parseRow(record []string, c columns, timeFormat string) (types.DataImportRow, error) {
+	field := func(i int) (string, error) {
+		if i >= len(record) {
+			return "", fmt.Errorf("row has %d columns, expected at least %d", len(record), i+1)
+		}
+		return strings.TrimSpace(record[i]), nil
+	}
+
+	var row types.DataImportRow
+
+	meshNodeUUID, err := field(c.meshNodeUUID)
+	if err != nil {
+		return row, err
+	}
+	if row.MeshNodeUUID, err = types.UUIDFromString(meshNodeUUID); err != nil {
+		return row, fmt.Errorf("mesh node uuid %q: %w", meshNodeUUID, err)
+	}
+
+	if row.Type, err = field(c.dataType); err != nil {
+		return row, err
+	}
+	if row.Type == "" {
+		return row, errors.New("type is empty")
+	}
+
+	measuredAt, err := field(c.measuredAt)
+	if err != nil {
+		return row, err
+	}
+	if row.MeasuredAt, err = time.Parse(timeFormat, measuredAt); err != nil {
+		return row, fmt.Errorf("measured at %q does not match %q", measuredAt, timeFormat)
+	}
+
+	if row.Value, err = field(c.value); err != nil {
+		return row, err
+	}
+
+	return row, nil
+}
@@ -0,0 +1,202 @@
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+const meshNodeUUID = "0cc56633-05ae-4cc3-8f71-801f429caeca"
+
+// batch is a call of ImportData.
+type batch struct {
+	rows          []types.DataImportRow
+	rejected      []types.DataImportError
+	fromRows      int
+	processedRows int
+}
+
+// fakeStore records the batches of an import.
+type fakeStore struct {
+	Store
+	batches []batch
+}
+
+func (s *fakeStore) ImportData(_ types.DataImportID, rows []types.DataImportRow, rejected []types.DataImportError, fromRows int, processedRows int) error {
+	// The importer reuses the slices of a batch
+	s.batches = append(s.batches, batch{
+		rows:          append([]types.DataImportRow(nil), rows...),
+		rejected:      append([]types.DataImportError(nil), rejected...),
+		fromRows:      fromRows,
+		processedRows: processedRows,
+	})
+	return nil
+}
+
+func TestImportRows(t *testing.T) {
+	uuid, err := types.UUIDFromString(meshNodeUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := "\ufeffmesh_node_uuid,type,measured_at,value\n" +
+		meshNodeUUID + ",temperature,2023-11-14T22:13:20Z,21.5\n" +
+		"invalid,temperature,2023-11-14T22:13:20Z,21.5\n" +
+		meshNodeUUID + ",temperature,2023-11-14 22:13:20,21.5\n" +
+		meshNodeUUID + `,"status",2023-11-14T22:13:20Z,"a "quoted" value"` + "\n" +
+		meshNodeUUID + ",,2023-11-14T22:13:20Z,1\n" +
+		meshNodeUUID + ",temperature\n" +
+		meshNodeUUID + ", humidity , 2023-11-14T22:13:21Z , 60 \n"
+
+	tests := []struct {
+		name          string
+		processedRows int
+		want          batch
+	}{
+		{
+			name: "from the start",
+			want: batch{
+				rows: []types.DataImportRow{
+					{Line: 2, MeshNodeUUID: uuid, Type: "temperature", MeasuredAt: time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC), Value: "21.5"},
+					{Line: 8, MeshNodeUUID: uuid, Type: "humidity", MeasuredAt: time.Date(2023, 11, 14, 22, 13, 21, 0, time.UTC), Value: "60"},
+				},
+				rejected: []types.DataImportError{
+					{Line: 3, Message: `mesh node uuid "invalid": uuid: incorrect UUID length 7 in string "invalid"`},
+					{Line: 4, Message: `measured at "2023-11-14 22:13:20" does not match "2006-01-02T15:04:05Z07:00"`},
+					{Line: 5, Message: csv.ErrQuote.Error()},
+					{Line: 6, Message: "type is empty"},
+					{Line: 7, Message: "row has 2 columns, expected at least 3"},
+				},
+				processedRows: 7,
+			},
+		},
+		{
+			name:          "resumed",
+			processedRows: 6,
+			want: batch{
+				rows: []types.DataImportRow{
+					{Line: 8, MeshNodeUUID: uuid, Type: "humidity", MeasuredAt: time.Date(2023, 11, 14, 22, 13, 21, 0, time.UTC), Value: "60"},
+				},
+				fromRows:      6,
+				processedRows: 7,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeStore{}
+			i := New(store)
+			defer i.Stop()
+
+			err := i.importRows(context.Background(), types.DataImport{
+				Mapping:       types.DefaultDataImportMapping(),
+				ProcessedRows: tt.processedRows,
+				Data:          []byte(data),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(store.batches, []batch{tt.want}) {
+				t.Errorf("importRows() stored %+v, want %+v", store.batches, []batch{tt.want})
+			}
+		})
+	}
+}
+
+func TestImportRowsBatches(t *testing.T) {
+	var b strings.Builder
+	for n := 0; n < batchSize+1; n++ {
+		fmt.Fprintf(&b, "%d,%s,1700000000,%d\n", n, meshNodeUUID, n)
+	}
+
+	store := &fakeStore{}
+	i := New(store)
+	defer i.Stop()
+
+	mapping := types.DataImportMapping{MeshNodeUUID: "2", Type: "1", MeasuredAt: "3", Value: "4", TimeFormat: "2006-01-02"}
+	err := i.importRows(context.Background(), types.DataImport{Mapping: mapping, Data: []byte(b.String())})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.batches) != 2 {
+		t.Fatalf("importRows() stored %d batches, want 2", len(store.batches))
+	}
+	first, last := store.batches[0], store.batches[1]
+	if len(first.rejected) != batchSize || first.fromRows != 0 || first.processedRows != batchSize {
+		t.Errorf("first batch rejected %d rows from %d to %d", len(first.rejected), first.fromRows, first.processedRows)
+	}
+	if len(last.rejected) != 1 || last.fromRows != batchSize || last.processedRows != batchSize+1 {
+		t.Errorf("last batch rejected %d rows from %d to %d", len(last.rejected), last.fromRows, last.processedRows)
+	}
+	if line := last.rejected[0].Line; line != batchSize+1 {
+		t.Errorf("last batch rejected line %d, want %d", line, batchSize+1)
+	}
+}
+
+func TestImportRowsCanceled(t *testing.T) {
+	store := &fakeStore{}
+	i := New(store)
+	defer i.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := i.importRows(ctx, types.DataImport{Mapping: types.DefaultDataImportMapping(), Data: []byte("mesh_node_uuid,type,measured_at,value\n")})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("importRows() error = %v, want %v", err, context.Canceled)
+	}
+	if len(store.batches) != 0 {
+		t.Errorf("importRows() stored %d batches after cancellation", len(store.batches))
+	}
+}
+
+func TestMapColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		mapping types.DataImportMapping
+		want    columns
+		err     bool
+	}{
+		{
+			name:    "header",
+			data:    "value, measured_at,type,mesh_node_uuid\n",
+			mapping: types.DefaultDataImportMapping(),
+			want:    columns{meshNodeUUID: 3, dataType: 2, measuredAt: 1, value: 0},
+		},
+		{
+			name:    "numbered columns",
+			data:    "",
+			mapping: types.DataImportMapping{MeshNodeUUID: "1", Type: "3", MeasuredAt: "2", Value: "5"},
+			want:    columns{meshNodeUUID: 0, dataType: 2, measuredAt: 1, value: 4},
+		},
+		{name: "missing header", data: "", mapping: types.DefaultDataImportMapping(), err: true},
+		{name: "missing column", data: "mesh_node_uuid,type,value\n", mapping: types.DefaultDataImportMapping(), err: true},
+		{name: "invalid header", data: "mesh_node_uuid,\"type\n", mapping: types.DefaultDataImportMapping(), err: true},
+	}
+
+	for _, tt := range tests {
+		reader := csv.NewReader(strings.NewReader(tt.data))
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+
+		got, err := mapColumns(reader, tt.mapping)
+		if (err != nil) != tt.err {
+			t.Errorf("mapColumns() of %s error = %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		if !tt.err && got != tt.want {
+			t.Errorf("mapColumns() of %s = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package mqtt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/mdma-backend/mdma-backend/internal/types"
+	"github.com/mdma-backend/mdma-backend/internal/types/permission"
+	mqttserver "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/rs/zerolog"
+)
+
+type RoleStore interface {
+	RoleByServiceAccountID(types.ServiceAccountID) (types.Role, error)
+}
+
+type BrokerConfig struct {
+	// Address is the TCP address the broker listens on, like :1883.
+	Address string
+	// Topic is the pattern of the topics mesh nodes publish their data to.
+	// It must contain the placeholder {nodeUUID} and may contain {type}.
+	Topic string
+}
+
+// sessionCheckInterval is the interval in which the tokens of clients are
+// validated again and their roles are reloaded.
+const sessionCheckInterval = time.Minute
+
+// Broker is an MQTT broker that stores the data that mesh nodes publish to
+// it. Clients authenticate with the token of a service account as password
+// and an arbitrary username.
+// Publishing requires the permission to create data and subscribing the
+// permission to read data. Roles scoped to areas may only publish and
+// subscribe to the topics of their mesh nodes.
+// Clients are disconnected once their token is no longer valid, and clients
+// with subscriptions also once their role changed, so they subscribe again
+// with the new role.
+type Broker struct {
+	server *mqttserver.Server
+	hook   *brokerHook
+	done   chan struct{}
+}
+
+func NewBroker(config BrokerConfig, store MeshNodeDataStore, publisher DataPublisher, tokenService types.TokenService, roleStore RoleStore) (*Broker, error) {
+	topic, err := parseTopicPattern(config.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := zerolog.New(os.Stderr).Level(zerolog.WarnLevel).With().Timestamp().Logger()
+	server := mqttserver.New(&mqttserver.Options{
+		Logger: &logger,
+	})
+
+	hook := &brokerHook{
+		store:        store,
+		publisher:    publisher,
+		topic:        topic,
+		tokenService: tokenService,
+		roleStore:    roleStore,
+		sessions:     map[*mqttserver.Client]*brokerSession{},
+	}
+	if err := server.AddHook(hook, nil); err != nil {
+		return nil, err
+	}
+
+	if err := server.AddListener(listeners.NewTCP("tcp", config.Address, nil)); err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", config.Address, err)
+	}
+
+	return &Broker{
+		server: server,
+		hook:   hook,
+		done:   make(chan struct{}),
+	}, nil
+}
+
+func (b *Broker) Start() error {
+	if err := b.server.Serve(); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(sessionCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				b.hook.checkSessions()
+			case <-b.done:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (b *Broker) Stop() error {
+	close(b.done)
+	return b.server.Close()
+}
+
+type brokerHook struct {
+	mqttserver.HookBase
+
+	store        MeshNodeDataStore
+	publisher    DataPublisher
+	topic        topicPattern
+	tokenService types.TokenService
+	roleStore    RoleStore
+
+	mu       sync.RWMutex
+	sessions map[*mqttserver.Client]*brokerSession
+}
+
+// brokerSession is the token a client authenticated with and its role when
+// the token was last checked.
+type brokerSession struct {
+	token     []byte
+	role      types.Role
+	checkedAt time.Time
+}
+
+func (h *brokerHook) ID() string {
+	return "mdma"
+}
+
+func (h *brokerHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqttserver.OnConnectAuthenticate,
+		mqttserver.OnACLCheck,
+		mqttserver.OnDisconnect,
+		mqttserver.OnPublish,
+	}, []byte{b})
+}
+
+func (h *brokerHook) OnConnectAuthenticate(cl *mqttserver.Client, pk packets.Packet) bool {
+	// MQTT 3.1.1 only allows a password together with a username, so clients
+	// without a username may send the token as username instead
+	token := pk.Connect.Password
+	if len(token) == 0 {
+		token = pk.Connect.Username
+	}
+
+	role, err := h.authenticate(token)
+	if err != nil {
+		log.Printf("mqtt client %s failed to authenticate: %s", cl.ID, err)
+		return false
+	}
+
+	h.mu.Lock()
+	h.sessions[cl] = &brokerSession{token: token, role: role, checkedAt: time.Now()}
+	h.mu.Unlock()
+
+	return true
+}
+
+func (h *brokerHook) authenticate(token []byte) (types.Role, error) {
+	claims, err := h.tokenService.Validate(string(token))
+	if err != nil {
+		return types.Role{}, errors.New("invalid token")
+	}
+
+	if claims.AccountType != types.ServiceAccountType {
+		return types.Role{}, errors.New("not a service account")
+	}
+
+	return h.roleStore.RoleByServiceAccountID(types.ServiceAccountID(claims.AccountID))
+}
+
+// checkSessions validates the tokens of all clients again and reloads their
+// roles.
+func (h *brokerHook) checkSessions() {
+	h.mu.RLock()
+	clients := make([]*mqttserver.Client, 0, len(h.sessions))
+	for cl := range h.sessions {
+		clients = append(clients, cl)
+	}
+	h.mu.RUnlock()
+
+	for _, cl := range clients {
+		h.checkSession(cl)
+	}
+}
+
+// checkSession validates the token of the client again and returns its
+// reloaded role. Clients whose token is no longer valid are disconnected,
+// clients with subscriptions also if their role changed.
+func (h *brokerHook) checkSession(cl *mqttserver.Client) (types.Role, bool) {
+	h.mu.RLock()
+	session, ok := h.sessions[cl]
+	h.mu.RUnlock()
+	if !ok {
+		return types.Role{}, false
+	}
+
+	role, err := h.authenticate(session.token)
+	if err != nil {
+		log.Printf("disconnecting mqtt client %s: %s", cl.ID, err)
+		h.removeSession(cl)
+		cl.Stop(err)
+		return types.Role{}, false
+	}
+
+	if !reflect.DeepEqual(role, session.role) && cl.State.Subscriptions.Len() > 0 {
+		log.Printf("disconnecting mqtt client %s: role changed", cl.ID)
+		h.removeSession(cl)
+		cl.Stop(errors.New("role changed"))
+		return types.Role{}, false
+	}
+
+	h.mu.Lock()
+	h.sessions[cl] = &brokerSession{token: session.token, role: role, checkedAt: time.Now()}
+	h.mu.Unlock()
+
+	return role, true
+}
+
+// role returns the role of the client, checked within the last
+// sessionCheckInterval.
+func (h *brokerHook) role(cl *mqttserver.Client) (types.Role, bool) {
+	h.mu.RLock()
+	session, ok := h.sessions[cl]
+	h.mu.RUnlock()
+	if !ok {
+		return types.Role{}, false
+	}
+
+	if time.Since(session.checkedAt) < sessionCheckInterval {
+		return session.role, true
+	}
+
+	return h.checkSession(cl)
+}
+
+func (h *brokerHook) removeSession(cl *mqttserver.Client) {
+	h.mu.Lock()
+	delete(h.sessions, cl)
+	h.mu.Unlock()
+}
+
+// OnACLCheck checks the permission of the client for publishing to a topic
+// or subscribing to a filter. Clients with a role scoped to areas may only
+// use topics and filters of a single mesh node of their areas.
+func (h *brokerHook) OnACLCheck(cl *mqttserver.Client, topic string, write bool) bool {
+	required := permission.DataRead
+	if write {
+		required = permission.DataCreate
+	}
+
+	role, ok := h.role(cl)
+	if !ok {
+		return false
+	}
+
+	var hasPermission bool
+	for _, p := range role.Permissions {
+		if p == required {
+			hasPermission = true
+			break
+		}
+	}
+
+	if !hasPermission {
+		return false
+	}
+
+	if !role.IsScoped() {
+		return true
+	}
+
+	// Filters with a wildcard in place of the mesh node do not parse
+	meshNodeUUID, _, err := h.topic.parse(topic)
+	if err != nil {
+		return false
+	}
+
+	return role.AppliesTo(meshNodeUUID)
+}
+
+func (h *brokerHook) OnDisconnect(cl *mqttserver.Client, _ error, _ bool) {
+	h.removeSession(cl)
+}
+
+// OnPublish stores the data of every message before it is acknowledged.
+// Messages that failed to be stored are not acknowledged, so the client
+// publishes them again.
+func (h *brokerHook) OnPublish(cl *mqttserver.Client, pk packets.Packet) (packets.Packet, error) {
+	if cl.Net.Inline {
+		return pk, nil
+	}
+
+	switch ingest(h.store, h.publisher, h.topic, pk.TopicName, pk.Payload) {
+	case statusFailed:
+		return pk, packets.ErrRejectPacket
+	case statusRejected:
+		return pk, packets.CodeSuccessIgnore
+	default:
+		return pk, nil
+	}
+}
@@ -0,0 +1,127 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/mdma-backend/mdma-backend/internal/api/data"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	statusLabel = "status"
+
+	statusStored   = "stored"
+	statusRejected = "rejected"
+	statusFailed   = "failed"
+)
+
+var (
+	mqttMessages = promauto.NewCounterVec(
+		prometheus.CounterOpts{Name: "mqtt_messages"},
+		[]string{statusLabel},
+	)
+	mqttMeasurements = promauto.NewCounter(
+		prometheus.CounterOpts{Name: "mqtt_measurements"},
+	)
+)
+
+type MeshNodeDataStore interface {
+	CreateManyMeshNodeData(types.UUID, []data.Data) error
+}
+
+// DataPublisher is notified about all data ingested over MQTT.
+type DataPublisher interface {
+	Publish(...data.Data)
+}
+
+// ingest stores the data of a message and returns whether it was stored,
+// rejected because it is invalid or failed to be stored. Only failed
+// messages should be delivered again.
+func ingest(store MeshNodeDataStore, publisher DataPublisher, pattern topicPattern, topic string, payload []byte) string {
+	status := ingestMessage(store, publisher, pattern, topic, payload)
+	mqttMessages.With(prometheus.Labels{statusLabel: status}).Inc()
+	return status
+}
+
+func ingestMessage(store MeshNodeDataStore, publisher DataPublisher, pattern topicPattern, topic string, payload []byte) string {
+	meshNodeUUID, meshNodeData, err := decodeMessage(pattern, topic, payload)
+	if err != nil {
+		log.Printf("rejecting mqtt message on %s: %s", topic, err)
+		return statusRejected
+	}
+
+	err = store.CreateManyMeshNodeData(meshNodeUUID, meshNodeData)
+	if errors.Is(err, types.ErrInvalidValue) || errors.Is(err, types.ErrNotFound) {
+		log.Printf("rejecting mqtt message on %s: %s", topic, err)
+		return statusRejected
+	} else if err != nil {
+		log.Printf("storing mqtt message on %s: %s", topic, err)
+		return statusFailed
+	}
+
+	for _, d := range meshNodeData {
+		if !d.Duplicate {
+			mqttMeasurements.Inc()
+		}
+	}
+	publisher.Publish(meshNodeData...)
+	return statusStored
+}
+
+// decodeMessage decodes the payload of a message. The payload is either a
+// single data object, a list of data objects or a plain value. The data type
+// of the topic takes precedence over the type in the payload and data without
+// a measurement time is measured at the time it was received.
+func decodeMessage(pattern topicPattern, topic string, payload []byte) (types.UUID, []data.Data, error) {
+	meshNodeUUID, dataType, err := pattern.parse(topic)
+	if err != nil {
+		return types.UUID{}, nil, err
+	}
+
+	payload = bytes.TrimSpace(payload)
+
+	var meshNodeData []data.Data
+	switch {
+	case len(payload) == 0:
+		return types.UUID{}, nil, errors.New("empty payload")
+	case payload[0] == '[':
+		if err := json.Unmarshal(payload, &meshNodeData); err != nil {
+			return types.UUID{}, nil, err
+		}
+	case payload[0] == '{':
+		var d data.Data
+		if err := json.Unmarshal(payload, &d); err != nil {
+			return types.UUID{}, nil, err
+		}
+		meshNodeData = append(meshNodeData, d)
+	default:
+		meshNodeData = append(meshNodeData, data.Data{
+			Value: string(payload),
+		})
+	}
+
+	receivedAt := time.Now().Format(time.RFC3339)
+	for i := range meshNodeData {
+		if dataType != "" {
+			meshNodeData[i].Type = dataType
+		}
+
+		if meshNodeData[i].Type == "" {
+			return types.UUID{}, nil, errors.New("data without type")
+		}
+
+		if meshNodeData[i].MeasuredAt == "" {
+			meshNodeData[i].MeasuredAt = receivedAt
+		}
+
+		meshNodeData[i].MeshNodeUUID = meshNodeUUID.String()
+	}
+
+	return meshNodeUUID, meshNodeData, nil
+}
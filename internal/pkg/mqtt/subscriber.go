@@ -0,0 +1,168 @@
+package mqtt
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	mqttConnected = promauto.NewGauge(
+		prometheus.GaugeOpts{Name: "mqtt_connected"},
+	)
+	mqttReconnects = promauto.NewCounter(
+		prometheus.CounterOpts{Name: "mqtt_reconnects"},
+	)
+)
+
+type SubscriberConfig struct {
+	// Broker is the URL of the broker, like tcp://localhost:1883.
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// Topic is the pattern of the topics mesh nodes publish their data to.
+	// It must contain the placeholder {nodeUUID} and may contain {type}.
+	Topic string
+	// Group subscribes as a shared subscription, so multiple backend
+	// instances with the same group share the messages between them.
+	Group string
+	QoS   byte
+	// MaxReconnectInterval caps the exponential backoff between reconnects.
+	MaxReconnectInterval time.Duration
+}
+
+// Subscriber stores the data that mesh nodes publish to an MQTT broker.
+//
+// Storing the data of a message is retried with backoff a few times. Messages
+// published with QoS 1 or 2 are only acknowledged after their data has been
+// stored or was rejected as invalid. Brokers only redeliver unacknowledged
+// messages after a reconnect, so the data of a message that still failed is
+// lost until the subscriber reconnects, and for good with QoS 0.
+type Subscriber struct {
+	config    SubscriberConfig
+	store     MeshNodeDataStore
+	publisher DataPublisher
+	topic     topicPattern
+	client    paho.Client
+}
+
+func NewSubscriber(config SubscriberConfig, store MeshNodeDataStore, publisher DataPublisher) (*Subscriber, error) {
+	topic, err := parseTopicPattern(config.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.QoS > 2 {
+		return nil, fmt.Errorf("invalid qos %d", config.QoS)
+	}
+
+	if config.MaxReconnectInterval <= 0 {
+		config.MaxReconnectInterval = time.Minute
+	}
+
+	s := &Subscriber{
+		config:    config,
+		store:     store,
+		publisher: publisher,
+		topic:     topic,
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetCleanSession(false).
+		SetOrderMatters(false).
+		SetAutoAckDisabled(true).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(config.MaxReconnectInterval).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5 * time.Second).
+		SetOnConnectHandler(s.onConnect).
+		SetConnectionLostHandler(s.onConnectionLost).
+		SetReconnectingHandler(s.onReconnecting)
+
+	s.client = paho.NewClient(opts)
+
+	return s, nil
+}
+
+// Start connects to the broker in the background. The subscriber keeps
+// retrying until the connection is established.
+func (s *Subscriber) Start() {
+	s.client.Connect()
+}
+
+// Stop disconnects from the broker and waits up to timeout for messages in
+// flight.
+func (s *Subscriber) Stop(timeout time.Duration) {
+	s.client.Disconnect(uint(timeout.Milliseconds()))
+	mqttConnected.Set(0)
+}
+
+func (s *Subscriber) filter() string {
+	filter := s.topic.filter()
+	if s.config.Group != "" {
+		filter = "$share/" + s.config.Group + "/" + filter
+	}
+
+	return filter
+}
+
+func (s *Subscriber) onConnect(client paho.Client) {
+	mqttConnected.Set(1)
+	log.Printf("connected to mqtt broker %s", s.config.Broker)
+
+	filter := s.filter()
+	token := client.Subscribe(filter, s.config.QoS, s.handleMessage)
+	go func() {
+		if token.Wait(); token.Error() != nil {
+			log.Printf("subscribing to %s: %s", filter, token.Error())
+		}
+	}()
+}
+
+func (s *Subscriber) onConnectionLost(_ paho.Client, err error) {
+	mqttConnected.Set(0)
+	log.Printf("lost connection to mqtt broker %s: %s", s.config.Broker, err)
+}
+
+func (s *Subscriber) onReconnecting(_ paho.Client, _ *paho.ClientOptions) {
+	mqttReconnects.Inc()
+}
+
+// Storing the data of a message is attempted up to ingestAttempts times. The
+// interval between the attempts starts at ingestRetryInterval and doubles.
+const (
+	ingestAttempts      = 5
+	ingestRetryInterval = 100 * time.Millisecond
+)
+
+// handleMessage runs in its own goroutine, since the order of messages does
+// not matter, so retries do not block other messages.
+func (s *Subscriber) handleMessage(_ paho.Client, msg paho.Message) {
+	interval := ingestRetryInterval
+	for attempt := 1; ; attempt++ {
+		status := ingest(s.store, s.publisher, s.topic, msg.Topic(), msg.Payload())
+		if status != statusFailed {
+			break
+		}
+
+		if attempt == ingestAttempts {
+			// The broker redelivers the message after the next reconnect
+			log.Printf("giving up on message of topic %s after %d attempts", msg.Topic(), attempt)
+			return
+		}
+
+		time.Sleep(interval)
+		interval *= 2
+	}
+
+	msg.Ack()
+}
@@ -0,0 +1,84 @@
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+const (
+	nodeUUIDPlaceholder = "{nodeUUID}"
+	typePlaceholder     = "{type}"
+)
+
+// topicPattern is a topic with placeholders for the mesh node UUID and the
+// data type, like mdma/{nodeUUID}/{type}.
+type topicPattern struct {
+	levels        []string
+	nodeUUIDLevel int
+	typeLevel     int
+}
+
+func parseTopicPattern(pattern string) (topicPattern, error) {
+	p := topicPattern{
+		levels:        strings.Split(pattern, "/"),
+		nodeUUIDLevel: -1,
+		typeLevel:     -1,
+	}
+
+	for i, level := range p.levels {
+		switch level {
+		case nodeUUIDPlaceholder:
+			p.nodeUUIDLevel = i
+		case typePlaceholder:
+			p.typeLevel = i
+		case "+", "#":
+			return p, fmt.Errorf("topic pattern %q must not contain wildcards", pattern)
+		}
+	}
+
+	if p.nodeUUIDLevel < 0 {
+		return p, fmt.Errorf("topic pattern %q has no %s placeholder", pattern, nodeUUIDPlaceholder)
+	}
+
+	return p, nil
+}
+
+// filter returns the topic filter that matches every topic of the pattern.
+func (p topicPattern) filter() string {
+	levels := make([]string, len(p.levels))
+	for i, level := range p.levels {
+		if i == p.nodeUUIDLevel || i == p.typeLevel {
+			level = "+"
+		}
+		levels[i] = level
+	}
+
+	return strings.Join(levels, "/")
+}
+
+// parse returns the mesh node UUID and the data type of topic. The data type
+// is empty if the pattern has no type placeholder.
+func (p topicPattern) parse(topic string) (types.UUID, string, error) {
+	levels := strings.Split(topic, "/")
+	if len(levels) != len(p.levels) {
+		return types.UUID{}, "", fmt.Errorf("topic %q does not match the pattern", topic)
+	}
+
+	nodeUUID, err := types.UUIDFromString(levels[p.nodeUUIDLevel])
+	if err != nil {
+		return types.UUID{}, "", fmt.Errorf("topic %q: %w", topic, err)
+	}
+
+	var dataType string
+	if p.typeLevel >= 0 {
+		dataType = levels[p.typeLevel]
+		if dataType == "" {
+			return types.UUID{}, "", errors.New("empty data type")
+		}
+	}
+
+	return nodeUUID, dataType, nil
+}
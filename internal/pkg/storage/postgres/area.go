@@ -0,0 +1,332 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+// areaColumns selects an area a with the members of it and its descendants
+// and its overrides as JSON arrays.
+const areaColumns = `a.id, a.created_at, a.updated_at, a.name, a.description, a.boundary, a.parent_id,
+	COALESCE((SELECT json_agg(DISTINCT m.mesh_node_id) FROM area_descendant d JOIN area_member m ON m.area_id = d.descendant_id WHERE d.area_id = a.id), '[]'),
+	COALESCE((SELECT json_agg(o.mesh_node_id) FROM area_mesh_node_override o WHERE o.area_id = a.id AND o.included), '[]'),
+	COALESCE((SELECT json_agg(o.mesh_node_id) FROM area_mesh_node_override o WHERE o.area_id = a.id AND NOT o.included), '[]')`
+
+type areaScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanArea(row areaScanner) (types.Area, error) {
+	var a types.Area
+	var boundary, members, included, excluded []byte
+	if err := row.Scan(&a.ID, &a.CreatedAt, &a.UpdatedAt, &a.Name, &a.Description, &boundary, &a.ParentID, &members, &included, &excluded); err != nil {
+		return a, err
+	}
+
+	if err := json.Unmarshal(boundary, &a.Boundary); err != nil {
+		return a, err
+	}
+
+	if err := json.Unmarshal(members, &a.MeshNodeUUIDs); err != nil {
+		return a, err
+	}
+
+	if err := json.Unmarshal(included, &a.IncludedMeshNodeUUIDs); err != nil {
+		return a, err
+	}
+
+	return a, json.Unmarshal(excluded, &a.ExcludedMeshNodeUUIDs)
+}
+
+func (db DB) AreaByID(id types.AreaID) (types.Area, error) {
+	a, err := scanArea(db.pool.QueryRow(`
+SELECT `+areaColumns+`
+FROM area a
+WHERE a.id = $1;
+`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, types.ErrNotFound
+	} else if err != nil {
+		return a, err
+	}
+
+	return a, nil
+}
+
+func (db DB) Areas() ([]types.Area, error) {
+	rows, err := db.pool.Query(`
+SELECT ` + areaColumns + `
+FROM area a
+ORDER BY a.name;
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var areas []types.Area
+	for rows.Next() {
+		a, err := scanArea(rows)
+		if err != nil {
+			return nil, err
+		}
+		areas = append(areas, a)
+	}
+
+	return areas, rows.Err()
+}
+
+// DescendantAreas returns all areas below the area ordered by name.
+func (db DB) DescendantAreas(id types.AreaID) ([]types.Area, error) {
+	var exists bool
+	if err := db.pool.QueryRow(`
+SELECT EXISTS (SELECT 1 FROM area WHERE id = $1);
+`, id).Scan(&exists); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, types.ErrNotFound
+	}
+
+	rows, err := db.pool.Query(`
+SELECT `+areaColumns+`
+FROM area a
+JOIN area_descendant d ON a.id = d.descendant_id
+WHERE d.area_id = $1
+AND a.id <> $1
+ORDER BY a.name;
+`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var areas []types.Area
+	for rows.Next() {
+		a, err := scanArea(rows)
+		if err != nil {
+			return nil, err
+		}
+		areas = append(areas, a)
+	}
+
+	return areas, rows.Err()
+}
+
+func (db DB) CreateArea(a *types.Area) error {
+	boundary, err := json.Marshal(a.Boundary)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.pool.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow(`
+INSERT INTO area (name, description, boundary, parent_id)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at;
+`, a.Name, a.Description, boundary, a.ParentID).Scan(&a.ID, &a.CreatedAt); isUniqueViolation(err) {
+		return fmt.Errorf("%w: area %q already exists", types.ErrInvalidValue, a.Name)
+	} else if isForeignKeyViolation(err) {
+		return fmt.Errorf("%w: unknown parent area %d", types.ErrInvalidValue, *a.ParentID)
+	} else if err != nil {
+		return err
+	}
+
+	if err := updateAreaMembers(tx, a.ID, a); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db DB) UpdateArea(id types.AreaID, a *types.Area) error {
+	boundary, err := json.Marshal(a.Boundary)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.pool.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if a.ParentID != nil {
+		// An area can not be moved below itself
+		var isDescendant bool
+		if err := tx.QueryRow(`
+SELECT EXISTS (SELECT 1 FROM area_descendant WHERE area_id = $1 AND descendant_id = $2);
+`, id, *a.ParentID).Scan(&isDescendant); err != nil {
+			return err
+		} else if isDescendant {
+			return fmt.Errorf("%w: parent area %d is the area itself or below it", types.ErrInvalidValue, *a.ParentID)
+		}
+	}
+
+	if err := tx.QueryRow(`
+UPDATE area
+SET name = $1, updated_at = now(), description = $2, boundary = $3, parent_id = $4
+WHERE id = $5
+RETURNING created_at, updated_at;
+`, a.Name, a.Description, boundary, a.ParentID, id).Scan(&a.CreatedAt, &a.UpdatedAt); errors.Is(err, sql.ErrNoRows) {
+		return types.ErrNotFound
+	} else if isUniqueViolation(err) {
+		return fmt.Errorf("%w: area %q already exists", types.ErrInvalidValue, a.Name)
+	} else if isForeignKeyViolation(err) {
+		return fmt.Errorf("%w: unknown parent area %d", types.ErrInvalidValue, *a.ParentID)
+	} else if err != nil {
+		return err
+	}
+
+	if err := updateAreaMembers(tx, id, a); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db DB) DeleteArea(id types.AreaID) error {
+	res, err := db.pool.Exec(`
+DELETE FROM area
+WHERE id = $1;
+`, id)
+	if isForeignKeyViolation(err) {
+		return fmt.Errorf("%w: area %d has child areas or roles scoped to it", types.ErrInvalidValue, id)
+	} else if err != nil {
+		return err
+	}
+
+	if num, err := res.RowsAffected(); err == nil && num == 0 {
+		return types.ErrNotFound
+	}
+
+	return nil
+}
+
+// updateAreaMembers replaces the overrides of the area with the ones of a,
+// recomputes which mesh nodes lie within its boundary and sets the resulting
+// members of a.
+func updateAreaMembers(tx *sql.Tx, id types.AreaID, a *types.Area) error {
+	if _, err := tx.Exec(`
+DELETE FROM area_mesh_node_override
+WHERE area_id = $1;
+`, id); err != nil {
+		return err
+	}
+
+	if err := insertAreaOverrides(tx, id, a.IncludedMeshNodeUUIDs, true); err != nil {
+		return err
+	}
+
+	if err := insertAreaOverrides(tx, id, a.ExcludedMeshNodeUUIDs, false); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+DELETE FROM area_mesh_node
+WHERE area_id = $1;
+`, id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+INSERT INTO area_mesh_node (area_id, mesh_node_id)
+SELECT a.id, n.id
+FROM area a, mesh_node n
+WHERE a.id = $1
+AND polygon_contains(a.boundary, n.longitude, n.latitude);
+`, id); err != nil {
+		return err
+	}
+
+	var members []byte
+	if err := tx.QueryRow(`
+SELECT COALESCE(json_agg(DISTINCT m.mesh_node_id), '[]')
+FROM area_descendant d
+JOIN area_member m ON m.area_id = d.descendant_id
+WHERE d.area_id = $1;
+`, id).Scan(&members); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(members, &a.MeshNodeUUIDs)
+}
+
+// insertAreaOverrides includes or excludes the mesh nodes from the area. All
+// mesh nodes must exist.
+func insertAreaOverrides(tx *sql.Tx, id types.AreaID, meshNodeUUIDs []types.UUID, included bool) error {
+	if len(meshNodeUUIDs) == 0 {
+		return nil
+	}
+
+	uuids := make([]string, 0, len(meshNodeUUIDs))
+	for _, u := range meshNodeUUIDs {
+		uuids = append(uuids, u.String())
+	}
+
+	if _, err := tx.Exec(`
+INSERT INTO area_mesh_node_override (area_id, mesh_node_id, included)
+SELECT $1, u, $3
+FROM unnest($2::uuid[]) AS u
+ON CONFLICT DO NOTHING;
+`, id, pq.Array(uuids), included); isForeignKeyViolation(err) {
+		return fmt.Errorf("%w: unknown mesh node", types.ErrInvalidValue)
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AreasContain reports whether the location lies within one of the areas or
+// one of the areas below them.
+func (db DB) AreasContain(ids []types.AreaID, latitude float32, longitude float32) (bool, error) {
+	areaIDs := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		areaIDs = append(areaIDs, int64(id))
+	}
+
+	var contains bool
+	if err := db.pool.QueryRow(`
+SELECT EXISTS (
+	SELECT 1
+	FROM area_descendant d
+	JOIN area a ON a.id = d.descendant_id
+	WHERE d.area_id = ANY($1::bigint[])
+	AND polygon_contains(a.boundary, $2, $3)
+);
+`, pq.Array(areaIDs), longitude, latitude).Scan(&contains); err != nil {
+		return false, err
+	}
+
+	return contains, nil
+}
+
+// locateMeshNode recomputes the areas the mesh node lies within.
+func locateMeshNode(tx *sql.Tx, id types.UUID) error {
+	if _, err := tx.Exec(`
+DELETE FROM area_mesh_node
+WHERE mesh_node_id = $1;
+`, id); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`
+INSERT INTO area_mesh_node (area_id, mesh_node_id)
+SELECT a.id, n.id
+FROM area a, mesh_node n
+WHERE n.id = $1
+AND polygon_contains(a.boundary, n.longitude, n.latitude);
+`, id)
+
+	return err
+}
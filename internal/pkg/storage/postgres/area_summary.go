@@ -0,0 +1,64 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mdma-backend/mdma-backend/internal/api/area"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+// AreaSummary summarizes the data measured between startTime and endTime by
+// the mesh nodes of the area and of all areas below it per data type. If
+// meshNodeUUIDs is not nil, only the data of those mesh nodes is included.
+func (db DB) AreaSummary(id types.AreaID, meshNodeUUIDs []string, startTime time.Time, endTime time.Time) ([]area.DataSummary, error) {
+	var exists bool
+	if err := db.pool.QueryRow(`
+SELECT EXISTS (SELECT 1 FROM area WHERE id = $1);
+`, id).Scan(&exists); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, types.ErrNotFound
+	}
+
+	rows, err := db.pool.Query(`
+WITH member AS (
+	SELECT DISTINCT m.mesh_node_id
+	FROM area_descendant ad
+	JOIN area_member m ON m.area_id = ad.descendant_id
+	WHERE ad.area_id = $1
+	AND ($4::uuid[] IS NULL OR m.mesh_node_id = ANY($4::uuid[]))
+), measurement AS (
+	SELECT dt.name, d.id, d.mesh_node_id, d.measured_at, `+valueText+` AS text, `+valueNumber+` AS number
+	FROM data d
+	JOIN data_type dt ON d.data_type_id = dt.id
+	JOIN member ON d.mesh_node_id = member.mesh_node_id
+	WHERE d.measured_at >= $2
+	AND d.measured_at < $3
+)
+SELECT name, COUNT(*), MIN(number), MAX(number), AVG(number),
+	(array_agg(mesh_node_id::text ORDER BY measured_at DESC, id DESC))[1],
+	(array_agg(measured_at ORDER BY measured_at DESC, id DESC))[1],
+	(array_agg(text ORDER BY measured_at DESC, id DESC))[1]
+FROM measurement
+GROUP BY name
+ORDER BY name;
+`, id, startTime, endTime, pq.Array(meshNodeUUIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []area.DataSummary
+	for rows.Next() {
+		var s area.DataSummary
+		var measuredAt time.Time
+		if err := rows.Scan(&s.DataType, &s.Count, &s.Minimum, &s.Maximum, &s.Average, &s.Latest.MeshNodeUUID, &measuredAt, &s.Latest.Value); err != nil {
+			return nil, err
+		}
+		s.Latest.MeasuredAt = measuredAt.Format(time.RFC3339Nano)
+		summaries = append(summaries, s)
+	}
+
+	return summaries, rows.Err()
+}
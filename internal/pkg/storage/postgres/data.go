@@ -2,25 +2,24 @@ package postgres
 
 import (
 	"database/sql"
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/mdma-backend/mdma-backend/internal/api/data"
 )
 
 func (db DB) GetAggregatedData(dataType string, meshNodeUUIDs []string, startTime time.Time, endTime time.Time, sampleTime time.Duration, sampleCount int, aggregateFunction string) (data.AggregatedData, error) {
-	timeStamps, err := identifyTimeStamps(startTime, endTime, sampleTime, sampleCount)
-	if err != nil {
-		return data.AggregatedData{}, err
-	}
+	sampleTime, sampleCount = identifyIntervals(startTime, endTime, sampleTime, sampleCount)
 
-	query, params, err := createQuery(dataType, aggregateFunction)
+	query, params, err := createQuery(dataType, aggregateFunction, meshNodeUUIDs, startTime, sampleTime, sampleCount)
 	if err != nil {
 		return data.AggregatedData{}, err
 	}
 
-	aggregatedData, err := db.getAggregatedDataSamples(timeStamps, query, params, meshNodeUUIDs)
+	aggregatedData, err := db.getAggregatedDataSamples(query, params, startTime, sampleTime)
 	if err != nil {
 		return data.AggregatedData{}, err
 	}
@@ -32,139 +31,267 @@ func (db DB) GetAggregatedData(dataType string, meshNodeUUIDs []string, startTim
 	return aggregatedData, nil
 }
 
-func identifyTimeStamps(startTime time.Time, endTime time.Time, sampleTime time.Duration, sampleCount int) ([]time.Time, error) {
+// GetAggregatedDataByMeshNode aggregates the data of each of the mesh nodes
+// on its own in a single query. Mesh nodes without data in the time window
+// are left out.
+func (db DB) GetAggregatedDataByMeshNode(dataType string, meshNodeUUIDs []string, startTime time.Time, endTime time.Time, sampleTime time.Duration, sampleCount int, aggregateFunction string) (map[string]data.AggregatedData, error) {
+	sampleTime, sampleCount = identifyIntervals(startTime, endTime, sampleTime, sampleCount)
+
+	aggregateExpression, err := aggregateExpression(aggregateFunction)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.pool.Query(`
+WITH measurement AS (
+	SELECT FLOOR(EXTRACT(EPOCH FROM d.measured_at - $2::timestamp)::double precision / $3::double precision)::bigint AS bucket, d.id, d.mesh_node_id, `+valueNumber+` AS value
+	FROM data d
+	JOIN data_type dt ON d.data_type_id = dt.id
+	WHERE dt.name = $1
+	AND d.measured_at >= $2::timestamp
+	AND d.measured_at < $4::timestamp
+	AND d.mesh_node_id = ANY($6::uuid[])
+), node AS (
+	SELECT DISTINCT mesh_node_id
+	FROM measurement
+)
+SELECT n.mesh_node_id, b.bucket, `+aggregateExpression+`
+FROM node n
+CROSS JOIN generate_series(0, $5::bigint - 1) AS b(bucket)
+LEFT JOIN measurement m ON m.mesh_node_id = n.mesh_node_id AND m.bucket = b.bucket
+GROUP BY n.mesh_node_id, b.bucket
+ORDER BY n.mesh_node_id, b.bucket;
+`, dataType, startTime, sampleTime.Seconds(), startTime.Add(sampleTime*time.Duration(sampleCount)), sampleCount, pq.Array(meshNodeUUIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aggregatedData := map[string]data.AggregatedData{}
+	for rows.Next() {
+		var meshNodeUUID string
+		var bucket int64
+		var nullableSampleValue sql.NullString
+		if err := rows.Scan(&meshNodeUUID, &bucket, &nullableSampleValue); err != nil {
+			return nil, err
+		}
+
+		var sampleValue *string
+		if nullableSampleValue.Valid {
+			sampleValue = &nullableSampleValue.String
+		}
+
+		intervalStartAt := startTime.Add(sampleTime * time.Duration(bucket))
+		meshNodeData, ok := aggregatedData[meshNodeUUID]
+		if !ok {
+			meshNodeData = data.AggregatedData{
+				AggregateFunction: aggregateFunction,
+				DataType:          dataType,
+				MeshNodeUUIDs:     []string{meshNodeUUID},
+			}
+		}
+		meshNodeData.Samples = append(meshNodeData.Samples, data.Sample{
+			Value:           sampleValue,
+			IntervalStartAt: intervalStartAt.String(),
+			IntervalEndAt:   intervalStartAt.Add(sampleTime).String(),
+		})
+		aggregatedData[meshNodeUUID] = meshNodeData
+	}
+
+	return aggregatedData, rows.Err()
+}
+
+// identifyIntervals returns the length and the number of the intervals
+// between startTime and endTime. If sampleTime is given, a trailing interval
+// that would end after endTime is dropped.
+func identifyIntervals(startTime time.Time, endTime time.Time, sampleTime time.Duration, sampleCount int) (time.Duration, int) {
 	if endTime == time.Unix(0, 0) {
 		endTime = time.Now()
 	}
 
 	duration := endTime.Sub(startTime)
-	intervals := sampleCount
-
 	if sampleTime != time.Duration(0) {
-		intervals = int(duration / sampleTime)
-	} else {
-		sampleTime = duration / time.Duration(sampleCount)
+		return sampleTime, int(duration / sampleTime)
 	}
 
-	var timeStamps []time.Time
-	for i := 0; i <= intervals; i++ {
-		timestamp := startTime.Add(sampleTime * time.Duration(i))
-		timeStamps = append(timeStamps, timestamp)
+	return duration / time.Duration(sampleCount), sampleCount
+}
+
+// createQuery builds a query that aggregates all measurements of the whole
+// time window in a single pass. Each measurement is assigned to the bucket of
+// its interval and the buckets are joined against a generated series, so
+// intervals without any measurement are still part of the result.
+func createQuery(dataType string, aggregateFunction string, meshNodeUUIDs []string, startTime time.Time, sampleTime time.Duration, sampleCount int) (string, []interface{}, error) {
+	aggregateExpression, err := aggregateExpression(aggregateFunction)
+	if err != nil {
+		return "", nil, err
 	}
 
-	return timeStamps, nil
-}
+	endTime := startTime.Add(sampleTime * time.Duration(sampleCount))
+	params := []interface{}{dataType, startTime, sampleTime.Seconds(), endTime, sampleCount}
+
+	query := `
+WITH measurement AS (
+	SELECT FLOOR(EXTRACT(EPOCH FROM d.measured_at - $2::timestamp)::double precision / $3::double precision)::bigint AS bucket, d.id, ` + valueNumber + ` AS value
+	FROM data d
+	JOIN data_type dt ON d.data_type_id = dt.id
+	WHERE dt.name = $1
+	AND d.measured_at >= $2::timestamp
+	AND d.measured_at < $4::timestamp
+`
+
+	if len(meshNodeUUIDs) > 0 {
+		query += `	AND d.mesh_node_id IN (`
+		for i, uuid := range meshNodeUUIDs {
+			if i != 0 {
+				query += `, `
+			}
+			query += `$` + strconv.Itoa(len(params)+1)
+			params = append(params, uuid)
+		}
+		query += `)
+`
+	}
 
-func createQuery(dataType string, aggregateFunction string) (string, []interface{}, error) {
-	aggregateFunction = strings.ToLower(aggregateFunction)
-	query := "SELECT "
+	query += `)
+SELECT b.bucket, ` + aggregateExpression + `
+FROM generate_series(0, $5::bigint - 1) AS b(bucket)
+LEFT JOIN measurement m ON m.bucket = b.bucket
+GROUP BY b.bucket
+ORDER BY b.bucket;
+`
 
-	switch aggregateFunction {
+	return query, params, nil
+}
+
+func aggregateExpression(aggregateFunction string) (string, error) {
+	switch strings.ToLower(aggregateFunction) {
 	case "count":
-		query += "COUNT(value)"
+		return "COUNT(m.id)", nil
 	case "sum":
-		query += "SUM(value::numeric)"
+		return "SUM(m.value)", nil
 	case "minimum":
-		query += "MIN(value)"
+		return "MIN(m.value)", nil
 	case "maximum":
-		query += "MAX(value)"
+		return "MAX(m.value)", nil
 	case "average":
-		query += "AVG(value::numeric)"
+		return "AVG(m.value)", nil
 	case "range":
-		query += "MAX(value::numeric) - MIN(value::numeric)"
+		return "MAX(m.value) - MIN(m.value)", nil
 	case "median":
-		query += "PERCENTILE_DISC(0.5) WITHIN GROUP (ORDER BY d.value) AS value"
+		return "PERCENTILE_DISC(0.5) WITHIN GROUP (ORDER BY m.value)", nil
+	default:
+		return "", fmt.Errorf("unknown aggregate function %s", aggregateFunction)
 	}
-
-	query += `
-	FROM data d
-	JOIN data_type dt ON d.data_type_id = dt.id
-	WHERE dt.name = $1
-	`
-
-	params := []interface{}{dataType}
-
-	return query, params, nil
 }
 
-func (db DB) getAggregatedDataSamples(timeStamps []time.Time, baseQuery string, baseParams []interface{}, meshNodeUUIDs []string) (data.AggregatedData, error) {
-	var aggregatedData data.AggregatedData
-	for currentStartTime := 0; currentStartTime < len(timeStamps)-1; currentStartTime++ {
+func (db DB) getAggregatedDataSamples(query string, params []interface{}, startTime time.Time, sampleTime time.Duration) (data.AggregatedData, error) {
+	rows, err := db.pool.Query(query, params...)
+	if err != nil {
+		return data.AggregatedData{}, err
+	}
+	defer rows.Close()
 
-		query := baseQuery
-		params := baseParams
-		query += " AND d.measured_at >= $" + strconv.Itoa(len(params)+1)
-		params = append(params, timeStamps[currentStartTime])
+	var aggregatedData data.AggregatedData
+	for rows.Next() {
+		var bucket int64
+		var nullableSampleValue sql.NullString
+		if err := rows.Scan(&bucket, &nullableSampleValue); err != nil {
+			return data.AggregatedData{}, err
+		}
 
-		query += " AND d.measured_at < $" + strconv.Itoa(len(params)+1)
-		params = append(params, timeStamps[currentStartTime+1])
-
-		if len(meshNodeUUIDs) > 0 {
-			query += ` AND mesh_node_id IN (`
-			for controllerNumber, uuid := range meshNodeUUIDs {
-				if controllerNumber != 0 {
-					query += `, `
-				}
-				query += `$` + strconv.Itoa(len(params)+1)
-				params = append(params, uuid)
-			}
-			query += `)`
+		var sampleValue *string
+		if nullableSampleValue.Valid {
+			sampleValue = &nullableSampleValue.String
 		}
 
-		rows, err := db.pool.Query(query, params...)
-		if err != nil {
-			println("error in query")
-			return data.AggregatedData{}, err
+		intervalStartAt := startTime.Add(sampleTime * time.Duration(bucket))
+		sample := data.Sample{
+			Value:           sampleValue,
+			IntervalStartAt: intervalStartAt.String(),
+			IntervalEndAt:   intervalStartAt.Add(sampleTime).String(),
 		}
-		defer rows.Close()
 
-		foundRows := false
+		aggregatedData.Samples = append(aggregatedData.Samples, sample)
+	}
 
-		for rows.Next() {
-			var nullableSampleValue sql.NullString
-			err := rows.Scan(&nullableSampleValue)
-			if err != nil {
-				return data.AggregatedData{}, err
-			}
+	if err := rows.Err(); err != nil {
+		return data.AggregatedData{}, err
+	}
 
-			foundRows = true
+	return aggregatedData, nil
+}
 
-			var sampleValue string
-			if nullableSampleValue.Valid {
-				sampleValue = nullableSampleValue.String
-			} else {
-				sampleValue = "0"
-			}
+func (db DB) GetManyData(dataType string, meshNodeUUIDs []string, startTime time.Time, endTime time.Time, page data.Page) (data.ManyData, *data.Cursor, error) {
+	// One more row than requested tells if there is a next page
+	query, params := manyDataQuery(dataType, meshNodeUUIDs, startTime, endTime, data.Page{
+		After:      page.After,
+		Limit:      page.Limit + 1,
+		Descending: page.Descending,
+	})
 
-			sample := data.Sample{
-				Value:           sampleValue,
-				IntervalStartAt: timeStamps[currentStartTime].String(),
-				IntervalEndAt:   timeStamps[currentStartTime+1].String(),
-			}
+	rows, err := db.pool.Query(query, params...)
+	if err != nil {
+		return data.ManyData{}, nil, err
+	}
+	defer rows.Close()
 
-			aggregatedData.Samples = append(aggregatedData.Samples, sample)
+	var result data.ManyData
+	var last data.Cursor
+	var hasNext bool
+	// Index of the measured data of each mesh node in the result
+	measuredDataIndex := map[string]int{}
+
+	result.DataType = dataType
+
+	for count := 0; rows.Next(); count++ {
+		var controllerUUID string
+		var measuredAt time.Time
+		var measurement data.Measurement
+
+		err := rows.Scan(&measurement.UUID, &controllerUUID, &measuredAt, &measurement.Value)
+		if err != nil {
+			return data.ManyData{}, nil, err
 		}
 
-		if err := rows.Err(); err != nil {
-			return data.AggregatedData{}, err
+		if count == page.Limit {
+			hasNext = true
+			break
 		}
+		measurement.MeasuredAt = measuredAt.Format(time.RFC3339Nano)
 
-		if !foundRows {
-			sample := data.Sample{
-				Value:           "0",
-				IntervalStartAt: timeStamps[currentStartTime].String(),
-				IntervalEndAt:   timeStamps[currentStartTime+1].String(),
-			}
-			aggregatedData.Samples = append(aggregatedData.Samples, sample)
+		i, ok := measuredDataIndex[controllerUUID]
+		if !ok {
+			i = len(result.MeasuredDatas)
+			measuredDataIndex[controllerUUID] = i
+			result.MeasuredDatas = append(result.MeasuredDatas, data.MeasuredData{
+				MeshnodeUUID: controllerUUID,
+			})
+		}
+
+		result.MeasuredDatas[i].Measurements = append(result.MeasuredDatas[i].Measurements, measurement)
+		last = data.Cursor{
+			MeasuredAt: measuredAt,
+			UUID:       measurement.UUID,
 		}
 	}
 
-	return aggregatedData, nil
+	if err := rows.Err(); err != nil {
+		return data.ManyData{}, nil, err
+	}
+
+	if !hasNext {
+		return result, nil, nil
+	}
+
+	return result, &last, nil
 }
 
-func (db DB) GetManyData(dataType string, meshNodeUUIDs []string, startTime time.Time, endTime time.Time) (data.ManyData, error) {
+// manyDataQuery returns the query for the measurements of a data type in
+// the order of the page. A page limit of 0 selects all remaining rows.
+func manyDataQuery(dataType string, meshNodeUUIDs []string, startTime time.Time, endTime time.Time, page data.Page) (string, []interface{}) {
 	var query = `
-	SELECT d.id, d.mesh_node_id, d.measured_at, d.value
+	SELECT d.id, d.mesh_node_id, d.measured_at, ` + valueText + `
 	FROM data d
 	JOIN data_type dt ON d.data_type_id = dt.id
 	WHERE dt.name = $1
@@ -193,52 +320,59 @@ func (db DB) GetManyData(dataType string, meshNodeUUIDs []string, startTime time
 		params = append(params, endTime)
 	}
 
+	order, comparison := "ASC", ">"
+	if page.Descending {
+		order, comparison = "DESC", "<"
+	}
+
+	if page.After != nil {
+		query += fmt.Sprintf(" AND (d.measured_at, d.id) %s ($%d, $%d)", comparison, len(params)+1, len(params)+2)
+		params = append(params, page.After.MeasuredAt, page.After.UUID)
+	}
+
+	query += fmt.Sprintf(" ORDER BY d.measured_at %s, d.id %s", order, order)
+
+	if page.Limit > 0 {
+		query += " LIMIT $" + strconv.Itoa(len(params)+1)
+		params = append(params, page.Limit)
+	}
+
+	return query, params
+}
+
+// StreamManyData calls fn for every measurement of a data type in the order
+// of the page without collecting them in memory. It stops at the first
+// error returned by fn.
+func (db DB) StreamManyData(dataType string, meshNodeUUIDs []string, startTime time.Time, endTime time.Time, page data.Page, fn func(meshNodeUUID string, measuredAt time.Time, measurement data.Measurement) error) error {
+	query, params := manyDataQuery(dataType, meshNodeUUIDs, startTime, endTime, page)
+
 	rows, err := db.pool.Query(query, params...)
 	if err != nil {
-		return data.ManyData{}, err
+		return err
 	}
 	defer rows.Close()
 
-	var result data.ManyData
-	var currentMeasuredData *data.MeasuredData
-
-	result.DataType = dataType
-
 	for rows.Next() {
-		var controllerUUID string
+		var meshNodeUUID string
+		var measuredAt time.Time
 		var measurement data.Measurement
 
-		err := rows.Scan(&measurement.UUID, &controllerUUID, &measurement.MeasuredAt, &measurement.Value)
-		if err != nil {
-			return data.ManyData{}, err
+		if err := rows.Scan(&measurement.UUID, &meshNodeUUID, &measuredAt, &measurement.Value); err != nil {
+			return err
 		}
+		measurement.MeasuredAt = measuredAt.Format(time.RFC3339Nano)
 
-		if currentMeasuredData == nil || currentMeasuredData.MeshnodeUUID != controllerUUID {
-			if currentMeasuredData != nil {
-				result.MeasuredDatas = append(result.MeasuredDatas, *currentMeasuredData)
-			}
-
-			currentMeasuredData = &data.MeasuredData{
-				MeshnodeUUID: controllerUUID,
-			}
+		if err := fn(meshNodeUUID, measuredAt, measurement); err != nil {
+			return err
 		}
-
-		currentMeasuredData.Measurements = append(currentMeasuredData.Measurements, measurement)
-	}
-
-	if currentMeasuredData != nil {
-		result.MeasuredDatas = append(result.MeasuredDatas, *currentMeasuredData)
 	}
 
-	if err := rows.Err(); err != nil {
-		return data.ManyData{}, err
-	}
-	return result, nil
+	return rows.Err()
 }
 
 func (db DB) GetData(uuid string) (data.Data, error) {
 	query := `
-	SELECT d.mesh_node_id, dt.name, d.created_at, d.measured_at, d.value 
+	SELECT d.mesh_node_id, dt.name, d.created_at, d.measured_at, ` + valueText + `
 	FROM data AS d
 	JOIN data_type AS dt 
 	ON d.data_type_id = dt.id
@@ -268,35 +402,6 @@ func (db DB) GetData(uuid string) (data.Data, error) {
 	return d, nil
 }
 
-func (db DB) GetTypes() ([]string, error) {
-	query := `
-	SELECT name FROM data_type;
-	`
-
-	rows, err := db.pool.Query(query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	dataTypes := []string{}
-
-	for rows.Next() {
-		var dataType string
-		err := rows.Scan(&dataType)
-		if err != nil {
-			return nil, err
-		}
-		dataTypes = append(dataTypes, dataType)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-
-	return dataTypes, nil
-}
-
 func (db DB) DeleteData(uuid string) error {
 	query := `
 	DELETE FROM data WHERE id = $1;
@@ -0,0 +1,417 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/gofrs/uuid"
+	"github.com/lib/pq"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+func (db DB) DataImportByID(id types.DataImportID) (types.DataImport, error) {
+	var i types.DataImport
+	var mapping []byte
+	var message sql.NullString
+	var areaIDs []int64
+	if err := db.pool.QueryRow(`
+SELECT id, created_at, updated_at, name, status, mapping, processed_rows, imported_rows, rejected_rows, message, area_ids, data
+FROM data_import
+WHERE id = $1;
+`, id).Scan(&i.ID, &i.CreatedAt, &i.UpdatedAt, &i.Name, &i.Status, &mapping, &i.ProcessedRows, &i.ImportedRows, &i.RejectedRows, &message, pq.Array(&areaIDs), &i.Data); errors.Is(err, sql.ErrNoRows) {
+		return i, types.ErrNotFound
+	} else if err != nil {
+		return i, err
+	}
+	i.AreaIDs = toAreaIDs(areaIDs)
+
+	if err := json.Unmarshal(mapping, &i.Mapping); err != nil {
+		return i, err
+	}
+	i.Message = message.String
+
+	return i, nil
+}
+
+// DataImports returns all data imports without their files.
+func (db DB) DataImports() ([]types.DataImport, error) {
+	rows, err := db.pool.Query(`
+SELECT id, created_at, updated_at, name, status, mapping, processed_rows, imported_rows, rejected_rows, message, area_ids
+FROM data_import
+ORDER BY id;
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var imports []types.DataImport
+	for rows.Next() {
+		var i types.DataImport
+		var mapping []byte
+		var message sql.NullString
+		var areaIDs []int64
+		if err := rows.Scan(&i.ID, &i.CreatedAt, &i.UpdatedAt, &i.Name, &i.Status, &mapping, &i.ProcessedRows, &i.ImportedRows, &i.RejectedRows, &message, pq.Array(&areaIDs)); err != nil {
+			return nil, err
+		}
+		i.AreaIDs = toAreaIDs(areaIDs)
+
+		if err := json.Unmarshal(mapping, &i.Mapping); err != nil {
+			return nil, err
+		}
+		i.Message = message.String
+
+		imports = append(imports, i)
+	}
+
+	return imports, rows.Err()
+}
+
+func (db DB) CreateDataImport(i *types.DataImport) error {
+	mapping, err := json.Marshal(i.Mapping)
+	if err != nil {
+		return err
+	}
+
+	// Imports without areas are not scoped and keep a null array
+	var areaIDs []int64
+	for _, id := range i.AreaIDs {
+		areaIDs = append(areaIDs, int64(id))
+	}
+
+	if err := db.pool.QueryRow(`
+INSERT INTO data_import (name, mapping, data, area_ids)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at, status;
+`, i.Name, mapping, i.Data, pq.Array(areaIDs)).Scan(&i.ID, &i.CreatedAt, &i.Status); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db DB) SetDataImportStatus(id types.DataImportID, status types.DataImportStatus, message string) error {
+	res, err := db.pool.Exec(`
+UPDATE data_import
+SET status = $1, message = NULLIF($2, ''), updated_at = now()
+WHERE id = $3;
+`, status, message, id)
+	if err != nil {
+		return err
+	}
+
+	if num, err := res.RowsAffected(); err == nil && num == 0 {
+		return types.ErrNotFound
+	}
+
+	return nil
+}
+
+// DeleteDataImport deletes the import with its file and rejected rows. The
+// imported data is kept. Imports that are run by any process are not deleted.
+func (db DB) DeleteDataImport(id types.DataImportID) error {
+	tx, err := db.pool.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRow(`
+SELECT pg_try_advisory_xact_lock(`+dataImportLockClass+`, $1);
+`, id).Scan(&locked); err != nil {
+		return err
+	} else if !locked {
+		return fmt.Errorf("%w: data import %d is running", types.ErrConflict, id)
+	}
+
+	res, err := tx.Exec(`
+DELETE FROM data_import
+WHERE id = $1;
+`, id)
+	if err != nil {
+		return err
+	}
+
+	if num, err := res.RowsAffected(); err == nil && num == 0 {
+		return types.ErrNotFound
+	}
+
+	return tx.Commit()
+}
+
+// dataImportLockClass is the first key of the advisory locks of imports,
+// which keeps them apart from other advisory locks.
+const dataImportLockClass = `'data_import'::regclass::oid::integer`
+
+// LockDataImport takes a session level advisory lock of the import on its own
+// connection. The lock is released by unlock or when the connection is lost,
+// so imports of a crashed process can be resumed.
+func (db DB) LockDataImport(id types.DataImportID) (func(), error) {
+	ctx := context.Background()
+	conn, err := db.pool.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `
+SELECT pg_try_advisory_lock(`+dataImportLockClass+`, $1);
+`, id).Scan(&locked); err != nil {
+		conn.Close()
+		return nil, err
+	} else if !locked {
+		conn.Close()
+		return nil, fmt.Errorf("%w: data import %d is locked by another process", types.ErrConflict, id)
+	}
+
+	unlock := func() {
+		if _, err := conn.ExecContext(ctx, `
+SELECT pg_advisory_unlock(`+dataImportLockClass+`, $1);
+`, id); err != nil {
+			log.Printf("unlocking data import %d: %s", id, err)
+		}
+		conn.Close()
+	}
+
+	return unlock, nil
+}
+
+func (db DB) DataImportErrors(id types.DataImportID) ([]types.DataImportError, error) {
+	rows, err := db.pool.Query(`
+SELECT line, message
+FROM data_import_error
+WHERE data_import_id = $1
+ORDER BY line;
+`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var importErrors []types.DataImportError
+	for rows.Next() {
+		var e types.DataImportError
+		if err := rows.Scan(&e.Line, &e.Message); err != nil {
+			return nil, err
+		}
+		importErrors = append(importErrors, e)
+	}
+
+	return importErrors, rows.Err()
+}
+
+// ImportData validates rows against the stored mesh nodes and data types,
+// loads the valid ones with COPY and records why the others were rejected.
+// The progress of the import is advanced from fromRows to processedRows in the
+// same transaction, so a resumed import neither skips nor repeats rows, and a
+// batch that another process already imported is rolled back.
+func (db DB) ImportData(id types.DataImportID, rows []types.DataImportRow, rejected []types.DataImportError, fromRows int, processedRows int) error {
+	tx, err := db.pool.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	meshNodeUUIDs := make([]string, 0, len(rows))
+	for _, row := range rows {
+		meshNodeUUIDs = append(meshNodeUUIDs, row.MeshNodeUUID.String())
+	}
+
+	meshNodes, err := existingMeshNodes(tx, meshNodeUUIDs)
+	if err != nil {
+		return err
+	}
+
+	scope, scoped, err := importScope(tx, id, meshNodeUUIDs)
+	if err != nil {
+		return err
+	}
+
+	// Types are resolved once per batch and all rows validated before the
+	// COPY, since no other statement can run on the transaction during it
+	dataTypes := map[string]types.DataType{}
+	var valid []importedRow
+	for _, row := range rows {
+		if !meshNodes[row.MeshNodeUUID.String()] {
+			rejected = append(rejected, types.DataImportError{
+				Line:    row.Line,
+				Message: fmt.Sprintf("mesh node %s: %s", row.MeshNodeUUID, types.ErrNotFound),
+			})
+			continue
+		}
+
+		if scoped && !scope[row.MeshNodeUUID.String()] {
+			rejected = append(rejected, types.DataImportError{
+				Line:    row.Line,
+				Message: fmt.Sprintf("mesh node %s is not in the areas of the import", row.MeshNodeUUID),
+			})
+			continue
+		}
+
+		dataType, ok := dataTypes[row.Type]
+		if !ok {
+			dataType, err = db.dataTypeByName(tx, row.Type, row.Value)
+			if errors.Is(err, types.ErrInvalidValue) {
+				rejected = append(rejected, types.DataImportError{Line: row.Line, Message: err.Error()})
+				continue
+			} else if err != nil {
+				return err
+			}
+			dataTypes[row.Type] = dataType
+		}
+
+		value, err := parseValue(dataType, row.Value)
+		if err != nil {
+			rejected = append(rejected, types.DataImportError{Line: row.Line, Message: err.Error()})
+			continue
+		}
+
+		valid = append(valid, importedRow{
+			row:        row,
+			dataTypeID: dataType.ID,
+			value:      value,
+		})
+	}
+
+	if err := copyData(tx, valid); err != nil {
+		return err
+	}
+
+	for _, e := range rejected {
+		if _, err := tx.Exec(`
+INSERT INTO data_import_error (data_import_id, line, message)
+VALUES ($1, $2, $3)
+ON CONFLICT (data_import_id, line) DO NOTHING;
+`, id, e.Line, e.Message); err != nil {
+			return err
+		}
+	}
+
+	res, err := tx.Exec(`
+UPDATE data_import
+SET processed_rows = $1, imported_rows = imported_rows + $2, rejected_rows = rejected_rows + $3, updated_at = now()
+WHERE id = $4
+AND processed_rows = $5;
+`, processedRows, len(valid), len(rejected), id, fromRows)
+	if err != nil {
+		return err
+	}
+
+	if num, err := res.RowsAffected(); err != nil {
+		return err
+	} else if num == 0 {
+		return fmt.Errorf("%w: data import %d was advanced by another process", types.ErrConflict, id)
+	}
+
+	return tx.Commit()
+}
+
+type importedRow struct {
+	row        types.DataImportRow
+	dataTypeID types.DataTypeID
+	value      typedValue
+}
+
+// copyData loads validated rows into the data table with COPY.
+func copyData(tx *sql.Tx, rows []importedRow) error {
+	stmt, err := tx.Prepare(pq.CopyIn("data", "id", "mesh_node_id", "data_type_id", "measured_at", "value_float", "value_integer", "value_boolean", "value_string", "value_json"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range rows {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return err
+		}
+
+		if _, err := stmt.Exec(id.String(), r.row.MeshNodeUUID.String(), r.dataTypeID, r.row.MeasuredAt, r.value.Float, r.value.Integer, r.value.Boolean, r.value.String, r.value.JSON); err != nil {
+			return err
+		}
+	}
+
+	if _, err := stmt.Exec(); err != nil {
+		return err
+	}
+
+	return stmt.Close()
+}
+
+// importScope returns which of the given mesh nodes belong to the areas of
+// the import. It returns false if the import is not scoped to areas.
+func importScope(tx *sql.Tx, id types.DataImportID, meshNodeUUIDs []string) (map[string]bool, bool, error) {
+	var areaIDs []int64
+	if err := tx.QueryRow(`
+SELECT area_ids
+FROM data_import
+WHERE id = $1;
+`, id).Scan(pq.Array(&areaIDs)); err != nil {
+		return nil, false, err
+	}
+
+	if areaIDs == nil {
+		return nil, false, nil
+	}
+
+	rows, err := tx.Query(`
+SELECT DISTINCT m.mesh_node_id
+FROM area_descendant d
+JOIN area_member m ON m.area_id = d.descendant_id
+WHERE d.area_id = ANY($1::bigint[])
+AND m.mesh_node_id = ANY($2::uuid[]);
+`, pq.Array(areaIDs), pq.Array(meshNodeUUIDs))
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	scope := map[string]bool{}
+	for rows.Next() {
+		var meshNodeUUID string
+		if err := rows.Scan(&meshNodeUUID); err != nil {
+			return nil, false, err
+		}
+		scope[meshNodeUUID] = true
+	}
+
+	return scope, true, rows.Err()
+}
+
+func toAreaIDs(ids []int64) []types.AreaID {
+	var areaIDs []types.AreaID
+	for _, id := range ids {
+		areaIDs = append(areaIDs, types.AreaID(id))
+	}
+
+	return areaIDs
+}
+
+// existingMeshNodes returns which of the given mesh nodes exist.
+func existingMeshNodes(tx *sql.Tx, meshNodeUUIDs []string) (map[string]bool, error) {
+	rows, err := tx.Query(`
+SELECT id
+FROM mesh_node
+WHERE id = ANY($1::uuid[]);
+`, pq.Array(meshNodeUUIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	meshNodes := map[string]bool{}
+	for rows.Next() {
+		var meshNodeUUID string
+		if err := rows.Scan(&meshNodeUUID); err != nil {
+			return nil, err
+		}
+		meshNodes[meshNodeUUID] = true
+	}
+
+	return meshNodes, rows.Err()
+}
@@ -0,0 +1,144 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+func (db DB) DataTypeByID(id types.DataTypeID) (types.DataType, error) {
+	var t types.DataType
+	if err := db.pool.QueryRow(`
+SELECT id, created_at, updated_at, name, kind, unit, description, min_value, max_value, value_precision, auto_created, auto_create_allowed
+FROM data_type
+WHERE id = $1;
+`, id).Scan(&t.ID, &t.CreatedAt, &t.UpdatedAt, &t.Name, &t.Kind, &t.Unit, &t.Description, &t.Min, &t.Max, &t.Precision, &t.AutoCreated, &t.AutoCreateAllowed); errors.Is(err, sql.ErrNoRows) {
+		return t, types.ErrNotFound
+	} else if err != nil {
+		return t, err
+	}
+
+	return t, nil
+}
+
+func (db DB) DataTypes() ([]types.DataType, error) {
+	rows, err := db.pool.Query(`
+SELECT id, created_at, updated_at, name, kind, unit, description, min_value, max_value, value_precision, auto_created, auto_create_allowed
+FROM data_type
+ORDER BY name;
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dataTypes []types.DataType
+	for rows.Next() {
+		var t types.DataType
+		if err := rows.Scan(&t.ID, &t.CreatedAt, &t.UpdatedAt, &t.Name, &t.Kind, &t.Unit, &t.Description, &t.Min, &t.Max, &t.Precision, &t.AutoCreated, &t.AutoCreateAllowed); err != nil {
+			return nil, err
+		}
+		dataTypes = append(dataTypes, t)
+	}
+
+	return dataTypes, rows.Err()
+}
+
+func (db DB) CreateDataType(t *types.DataType) error {
+	if err := db.pool.QueryRow(`
+INSERT INTO data_type (name, kind, unit, description, min_value, max_value, value_precision, auto_create_allowed)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id, created_at;
+`, t.Name, t.Kind, t.Unit, t.Description, t.Min, t.Max, t.Precision, t.AutoCreateAllowed).Scan(&t.ID, &t.CreatedAt); isUniqueViolation(err) {
+		return fmt.Errorf("%w: data type %q already exists", types.ErrConflict, t.Name)
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateDataType updates the metadata of a data type. The kind of a data type
+// can not be changed, since its values are stored in the column of the kind.
+func (db DB) UpdateDataType(id types.DataTypeID, t *types.DataType) error {
+	if err := db.pool.QueryRow(`
+UPDATE data_type
+SET name = $1, updated_at = now(), unit = $2, description = $3, min_value = $4, max_value = $5, value_precision = $6, auto_create_allowed = $7
+WHERE id = $8
+RETURNING created_at, updated_at, kind, auto_created;
+`, t.Name, t.Unit, t.Description, t.Min, t.Max, t.Precision, t.AutoCreateAllowed, id).Scan(&t.CreatedAt, &t.UpdatedAt, &t.Kind, &t.AutoCreated); errors.Is(err, sql.ErrNoRows) {
+		return types.ErrNotFound
+	} else if isUniqueViolation(err) {
+		return fmt.Errorf("%w: data type %q already exists", types.ErrConflict, t.Name)
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteDataType deletes a data type and all data of it. Unless force is set,
+// types that still have data are not deleted.
+func (db DB) DeleteDataType(id types.DataTypeID, force bool) error {
+	if !force {
+		var inUse bool
+		if err := db.pool.QueryRow(`
+SELECT EXISTS (SELECT 1 FROM data WHERE data_type_id = $1);
+`, id).Scan(&inUse); err != nil {
+			return err
+		} else if inUse {
+			return fmt.Errorf("%w: data type %d still has data", types.ErrConflict, id)
+		}
+	}
+
+	res, err := db.pool.Exec(`
+DELETE FROM data_type
+WHERE id = $1;
+`, id)
+	if err != nil {
+		return err
+	}
+
+	if num, err := res.RowsAffected(); err == nil && num == 0 {
+		return types.ErrNotFound
+	}
+
+	return nil
+}
+
+// dataTypeByName returns the data type with the given name. Unknown data
+// types are only created, with the kind inferred from value, if the database
+// is configured to do so. Auto-created types are rejected once they no longer
+// allow auto-creation.
+func (db DB) dataTypeByName(tx *sql.Tx, name string, value string) (types.DataType, error) {
+	var t types.DataType
+	if err := tx.QueryRow(`
+SELECT id, created_at, updated_at, name, kind, unit, description, min_value, max_value, value_precision, auto_created, auto_create_allowed
+FROM data_type
+WHERE name = $1;
+`, name).Scan(&t.ID, &t.CreatedAt, &t.UpdatedAt, &t.Name, &t.Kind, &t.Unit, &t.Description, &t.Min, &t.Max, &t.Precision, &t.AutoCreated, &t.AutoCreateAllowed); errors.Is(err, sql.ErrNoRows) {
+		if !db.AutoCreateDataTypes {
+			return t, fmt.Errorf("%w: unknown data type %q", types.ErrInvalidValue, name)
+		}
+
+		t.Name = name
+		t.Kind = inferValueKind(value)
+		t.AutoCreated = true
+		t.AutoCreateAllowed = true
+		if err := tx.QueryRow(`
+INSERT INTO data_type (name, kind, auto_created)
+VALUES ($1, $2, TRUE)
+RETURNING id, created_at;
+`, t.Name, t.Kind).Scan(&t.ID, &t.CreatedAt); err != nil {
+			return t, err
+		}
+	} else if err != nil {
+		return t, err
+	} else if t.AutoCreated && !t.AutoCreateAllowed {
+		return t, fmt.Errorf("%w: data type %q is not allowed", types.ErrInvalidValue, name)
+	}
+
+	return t, nil
+}
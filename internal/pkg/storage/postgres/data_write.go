@@ -0,0 +1,226 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/gofrs/uuid"
+	"github.com/lib/pq"
+	"github.com/mdma-backend/mdma-backend/internal/api/data"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+// CreateData stores data of any mesh nodes in one transaction. It fails
+// without storing anything if a mesh node does not exist or a value is
+// invalid.
+func (db DB) CreateData(dd []data.Data) error {
+	if len(dd) == 0 {
+		return nil
+	}
+
+	tx, err := db.pool.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := db.createData(tx, dd); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// dataColumns are the columns of a batch of data passed as arrays to a
+// single multi-row INSERT.
+type dataColumns struct {
+	ids           []string
+	meshNodeUUIDs []string
+	dataTypeIDs   []int64
+	measuredAts   []sql.NullString
+	floats        []sql.NullFloat64
+	integers      []sql.NullInt64
+	booleans      []sql.NullBool
+	strings       []sql.NullString
+	jsons         []sql.NullString
+}
+
+// createData validates a batch of data, resolving each data type only once,
+// and inserts it with a single statement. Data without UUID gets a new one,
+// data with an already stored UUID is marked as duplicate. Data without
+// measurement time is measured at the time it is stored.
+func (db DB) createData(tx *sql.Tx, dd []data.Data) error {
+	meshNodeUUIDs := make([]string, 0, len(dd))
+	for i := range dd {
+		meshNodeUUID, err := types.UUIDFromString(dd[i].MeshNodeUUID)
+		if err != nil {
+			return fmt.Errorf("%w: mesh node uuid %q: %s", types.ErrInvalidValue, dd[i].MeshNodeUUID, err)
+		}
+		dd[i].MeshNodeUUID = meshNodeUUID.String()
+		meshNodeUUIDs = append(meshNodeUUIDs, dd[i].MeshNodeUUID)
+	}
+
+	meshNodes, err := existingMeshNodes(tx, meshNodeUUIDs)
+	if err != nil {
+		return err
+	}
+
+	dataTypes := map[string]types.DataType{}
+	var c dataColumns
+	for i := range dd {
+		d := &dd[i]
+
+		if !meshNodes[d.MeshNodeUUID] {
+			return fmt.Errorf("mesh node %s: %w", d.MeshNodeUUID, types.ErrNotFound)
+		}
+
+		dataType, ok := dataTypes[d.Type]
+		if !ok {
+			dataType, err = db.dataTypeByName(tx, d.Type, d.Value)
+			if err != nil {
+				return err
+			}
+			dataTypes[d.Type] = dataType
+		}
+
+		value, err := parseValue(dataType, d.Value)
+		if err != nil {
+			return err
+		}
+
+		if d.UUID == "" {
+			id, err := uuid.NewV4()
+			if err != nil {
+				return err
+			}
+			d.UUID = id.String()
+		} else {
+			id, err := types.UUIDFromString(d.UUID)
+			if err != nil {
+				return fmt.Errorf("%w: uuid %q: %s", types.ErrInvalidValue, d.UUID, err)
+			}
+			d.UUID = id.String()
+		}
+
+		c.ids = append(c.ids, d.UUID)
+		c.meshNodeUUIDs = append(c.meshNodeUUIDs, d.MeshNodeUUID)
+		c.dataTypeIDs = append(c.dataTypeIDs, int64(dataType.ID))
+		c.measuredAts = append(c.measuredAts, sql.NullString{String: d.MeasuredAt, Valid: d.MeasuredAt != ""})
+		c.floats = append(c.floats, value.Float)
+		c.integers = append(c.integers, value.Integer)
+		c.booleans = append(c.booleans, value.Boolean)
+		c.strings = append(c.strings, value.String)
+		c.jsons = append(c.jsons, value.JSON)
+	}
+
+	created, err := insertData(tx, c)
+	if err != nil {
+		return err
+	}
+
+	return markDuplicates(tx, dd, created)
+}
+
+// insertData inserts the batch and returns the creation time of every data
+// that was not stored before.
+func insertData(tx *sql.Tx, c dataColumns) (map[string]string, error) {
+	rows, err := tx.Query(`
+INSERT INTO data
+(id, mesh_node_id, data_type_id, measured_at, value_float, value_integer, value_boolean, value_string, value_json)
+SELECT id, mesh_node_id, data_type_id, COALESCE(measured_at, CURRENT_TIMESTAMP), value_float, value_integer, value_boolean, value_string, value_json
+FROM unnest($1::uuid[], $2::uuid[], $3::bigint[], $4::timestamp[], $5::double precision[], $6::bigint[], $7::boolean[], $8::text[], $9::jsonb[])
+AS d (id, mesh_node_id, data_type_id, measured_at, value_float, value_integer, value_boolean, value_string, value_json)
+ON CONFLICT (id) DO NOTHING
+RETURNING id, created_at;
+`, pq.Array(c.ids), pq.Array(c.meshNodeUUIDs), pq.Array(c.dataTypeIDs), pq.Array(c.measuredAts), pq.Array(c.floats), pq.Array(c.integers), pq.Array(c.booleans), pq.Array(c.strings), pq.Array(c.jsons))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	created := map[string]string{}
+	for rows.Next() {
+		var id, createdAt string
+		if err := rows.Scan(&id, &createdAt); err != nil {
+			return nil, err
+		}
+		created[id] = createdAt
+	}
+
+	return created, rows.Err()
+}
+
+// markDuplicates sets the creation time of all data and marks data that was
+// stored before, either by an earlier request or earlier in the batch. A
+// UUID that is already used by another mesh node is rejected.
+func markDuplicates(tx *sql.Tx, dd []data.Data, created map[string]string) error {
+	var stored []string
+	for _, d := range dd {
+		if _, ok := created[d.UUID]; !ok {
+			stored = append(stored, d.UUID)
+		}
+	}
+
+	type storedData struct {
+		meshNodeUUID string
+		createdAt    string
+	}
+	storedByID := map[string]storedData{}
+
+	if len(stored) != 0 {
+		rows, err := tx.Query(`
+SELECT id, mesh_node_id, created_at
+FROM data
+WHERE id = ANY($1::uuid[]);
+`, pq.Array(stored))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id string
+			var s storedData
+			if err := rows.Scan(&id, &s.meshNodeUUID, &s.createdAt); err != nil {
+				return err
+			}
+			storedByID[id] = s
+		}
+
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	// Mesh node of the first data with a UUID that was inserted by the batch
+	inserted := map[string]string{}
+	for i := range dd {
+		d := &dd[i]
+
+		if createdAt, ok := created[d.UUID]; ok {
+			meshNodeUUID, seen := inserted[d.UUID]
+			if seen && meshNodeUUID != d.MeshNodeUUID {
+				return fmt.Errorf("%w: uuid %s is already used by mesh node %s", types.ErrInvalidValue, d.UUID, meshNodeUUID)
+			}
+			inserted[d.UUID] = d.MeshNodeUUID
+
+			d.CreatedAt = createdAt
+			d.Duplicate = seen
+			continue
+		}
+
+		s, ok := storedByID[d.UUID]
+		if !ok {
+			return fmt.Errorf("data %s was neither inserted nor found", d.UUID)
+		}
+
+		if s.meshNodeUUID != d.MeshNodeUUID {
+			return fmt.Errorf("%w: uuid %s is already used by mesh node %s", types.ErrInvalidValue, d.UUID, s.meshNodeUUID)
+		}
+
+		d.CreatedAt = s.createdAt
+		d.Duplicate = true
+	}
+
+	return nil
+}
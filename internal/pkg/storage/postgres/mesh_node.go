@@ -47,96 +47,54 @@ FROM mesh_node;
 
 // PostMeshNode Funktioniert
 func (db DB) CreateMeshNode(n *types.MeshNode) error {
-	if err := db.pool.QueryRow(`
-INSERT INTO mesh_node 
-(id, mesh_node_update_id, latitude, longitude)
-VALUES ($1, $2, $3, $4)
-RETURNING created_at;
-`, n.UUID, n.UpdateID, n.Latitude, n.Longitude).Scan(&n.CreatedAt); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (db DB) CreateMeshNodeData(id types.UUID, data *data.Data) error {
 	tx, err := db.pool.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	var dataTypeID uint
-	if err := db.pool.QueryRow(`
-SELECT id
-FROM data_type
-WHERE name = $1
-`, data.Type).Scan(&dataTypeID); errors.Is(err, sql.ErrNoRows) {
-		if err := tx.QueryRow(`
-INSERT INTO data_type
-(name)
-VALUES ($1)
-RETURNING id;
-`, data.Type).Scan(&dataTypeID); err != nil {
-			return err
-		}
-	} else if err != nil {
+	if err := tx.QueryRow(`
+INSERT INTO mesh_node 
+(id, mesh_node_update_id, latitude, longitude)
+VALUES ($1, $2, $3, $4)
+RETURNING created_at;
+`, n.UUID, n.UpdateID, n.Latitude, n.Longitude).Scan(&n.CreatedAt); err != nil {
 		return err
 	}
 
-	if err = tx.QueryRow(`
-INSERT INTO data 
-(id, mesh_node_id, data_type_id, measured_at, value)
-VALUES (gen_random_uuid(), $1, $2, $3, $4)
-RETURNING id, created_at;
-`, id, dataTypeID, data.MeasuredAt, data.Value).Scan(&data.UUID, &data.CreatedAt); err != nil {
+	if err := locateMeshNode(tx, n.UUID); err != nil {
 		return err
 	}
 
 	return tx.Commit()
 }
 
-func (db DB) CreateManyMeshNodeData(id types.UUID, data []data.Data) error {
-	tx, err := db.pool.Begin()
-	if err != nil {
+func (db DB) CreateMeshNodeData(id types.UUID, d *data.Data) error {
+	meshNodeData := []data.Data{*d}
+	if err := db.CreateManyMeshNodeData(id, meshNodeData); err != nil {
 		return err
 	}
-	defer tx.Rollback()
+	*d = meshNodeData[0]
 
-	for _, d := range data {
-		var dataTypeID uint
-		if err := db.pool.QueryRow(`
-SELECT id
-FROM data_type
-WHERE name = $1
-`, d.Type).Scan(&dataTypeID); errors.Is(err, sql.ErrNoRows) {
-			if err := tx.QueryRow(`
-INSERT INTO data_type
-(name)
-VALUES ($1)
-RETURNING id;
-`, d.Type).Scan(&dataTypeID); err != nil {
-				return err
-			}
-		} else if err != nil {
-			return err
-		}
+	return nil
+}
 
-		if err = tx.QueryRow(`
-INSERT INTO data 
-(id, mesh_node_id, data_type_id, measured_at, value)
-VALUES (gen_random_uuid(), $1, $2, $3, $4)
-RETURNING id, created_at;
-`, id, dataTypeID, d.MeasuredAt, d.Value).Scan(&d.UUID, &d.CreatedAt); err != nil {
-			return err
-		}
+func (db DB) CreateManyMeshNodeData(id types.UUID, meshNodeData []data.Data) error {
+	for i := range meshNodeData {
+		meshNodeData[i].MeshNodeUUID = id.String()
 	}
 
-	return tx.Commit()
+	return db.CreateData(meshNodeData)
 }
 
 func (db DB) UpdateMeshNode(id types.UUID, n *types.MeshNode) error {
-	if err := db.pool.QueryRow(`
+	tx, err := db.pool.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow(`
 UPDATE mesh_node 
 SET mesh_node_update_id = $1,  updated_at = now(),  latitude = $2, longitude = $3
 WHERE id = $4
@@ -147,7 +105,11 @@ RETURNING created_at, updated_at;
 		return err
 	}
 
-	return nil
+	if err := locateMeshNode(tx, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (db DB) DeleteMeshNode(id types.UUID) error {
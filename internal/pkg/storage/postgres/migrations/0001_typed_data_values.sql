@@ -0,0 +1,39 @@
+-- Every data type declares the kind of its values and every value is stored
+-- in the column matching that kind.
+
+CREATE TYPE value_kind AS ENUM (
+    'float',
+    'integer',
+    'boolean',
+    'string',
+    'json'
+);
+
+ALTER TABLE data_type ADD COLUMN kind value_kind NOT NULL DEFAULT 'float';
+
+-- Types with values that are not numeric can only be kept as strings
+UPDATE data_type dt
+SET kind = 'string'
+WHERE EXISTS (
+    SELECT 1
+    FROM data d
+    WHERE d.data_type_id = dt.id
+    AND d.value !~ '^\s*[-+]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][-+]?[0-9]+)?\s*$'
+);
+
+ALTER TABLE data
+    ADD COLUMN value_float DOUBLE PRECISION,
+    ADD COLUMN value_integer BIGINT,
+    ADD COLUMN value_boolean BOOLEAN,
+    ADD COLUMN value_string TEXT,
+    ADD COLUMN value_json JSONB;
+
+UPDATE data d
+SET value_float = CASE WHEN dt.kind = 'float' THEN d.value::double precision END,
+    value_string = CASE WHEN dt.kind = 'string' THEN d.value END
+FROM data_type dt
+WHERE d.data_type_id = dt.id;
+
+ALTER TABLE data
+    DROP COLUMN value,
+    ADD CONSTRAINT data_single_value CHECK (num_nonnulls(value_float, value_integer, value_boolean, value_string, value_json) = 1);
@@ -0,0 +1,19 @@
+-- Data types are managed resources with metadata that is used to validate
+-- incoming values.
+
+ALTER TYPE permission ADD VALUE IF NOT EXISTS 'data_type_create';
+ALTER TYPE permission ADD VALUE IF NOT EXISTS 'data_type_update';
+ALTER TYPE permission ADD VALUE IF NOT EXISTS 'data_type_delete';
+
+ALTER TABLE data_type
+    ADD COLUMN updated_at TIMESTAMP,
+    ADD COLUMN unit VARCHAR(120) NOT NULL DEFAULT '',
+    ADD COLUMN description TEXT NOT NULL DEFAULT '',
+    ADD COLUMN min_value DOUBLE PRECISION,
+    ADD COLUMN max_value DOUBLE PRECISION,
+    ADD COLUMN value_precision SMALLINT CHECK (value_precision >= 0),
+    ADD COLUMN auto_created BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD CONSTRAINT data_type_min_max CHECK (min_value IS NULL OR max_value IS NULL OR min_value <= max_value);
+
+-- All existing types were created by ingesting data
+UPDATE data_type SET auto_created = TRUE;
@@ -0,0 +1,10 @@
+-- Data is paginated by measured_at and id, which requires every measurement
+-- to have a measurement time.
+
+UPDATE data SET measured_at = created_at WHERE measured_at IS NULL;
+
+ALTER TABLE data
+    ALTER COLUMN measured_at SET DEFAULT CURRENT_TIMESTAMP,
+    ALTER COLUMN measured_at SET NOT NULL;
+
+CREATE INDEX idx_data_data_type_id_measured_at_id ON data (data_type_id, measured_at, id);
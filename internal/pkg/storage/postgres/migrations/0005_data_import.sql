@@ -0,0 +1,30 @@
+-- Bulk imports of CSV files are tracked as jobs, which keep their file so
+-- they can be resumed after the last processed row.
+
+CREATE TYPE data_import_status AS ENUM (
+    'pending',
+    'running',
+    'completed',
+    'failed'
+);
+
+CREATE TABLE data_import (
+    id BIGSERIAL NOT NULL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP,
+    name VARCHAR(120) NOT NULL,
+    status data_import_status NOT NULL DEFAULT 'pending',
+    mapping JSONB NOT NULL,
+    data BYTEA NOT NULL,
+    processed_rows BIGINT NOT NULL DEFAULT 0,
+    imported_rows BIGINT NOT NULL DEFAULT 0,
+    rejected_rows BIGINT NOT NULL DEFAULT 0,
+    message TEXT
+);
+
+CREATE TABLE data_import_error (
+    data_import_id BIGINT NOT NULL REFERENCES data_import(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    line BIGINT NOT NULL,
+    message TEXT NOT NULL,
+    PRIMARY KEY (data_import_id, line)
+);
@@ -0,0 +1,21 @@
+-- Areas are stored with a GeoJSON polygon as their boundary instead of being
+-- part of the code.
+
+ALTER TYPE permission ADD VALUE IF NOT EXISTS 'area_create';
+ALTER TYPE permission ADD VALUE IF NOT EXISTS 'area_update';
+ALTER TYPE permission ADD VALUE IF NOT EXISTS 'area_delete';
+
+CREATE TABLE area (
+    id BIGSERIAL NOT NULL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP,
+    name VARCHAR(120) UNIQUE NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    boundary JSONB NOT NULL
+);
+
+CREATE TABLE area_mesh_node (
+    area_id BIGINT NOT NULL REFERENCES area(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    mesh_node_id UUID NOT NULL REFERENCES mesh_node(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    PRIMARY KEY (area_id, mesh_node_id)
+);
@@ -0,0 +1,7 @@
+-- New enum values can only be used after the migration that added them has
+-- been committed.
+
+INSERT INTO role_permission (role_id, permission)
+SELECT id, p.permission::permission
+FROM role, (VALUES ('area_create'), ('area_update'), ('area_delete')) AS p(permission)
+WHERE name = 'admin';
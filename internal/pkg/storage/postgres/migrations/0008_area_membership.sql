@@ -0,0 +1,84 @@
+-- Mesh nodes belong to the areas their location lies in. The membership is
+-- stored in area_mesh_node and recomputed whenever an area or the location
+-- of a mesh node changes. Overrides include or exclude single mesh nodes
+-- regardless of their location.
+
+-- polygon_contains reports whether the point lies within the GeoJSON polygon,
+-- that is within its exterior ring and not within any of its holes.
+CREATE FUNCTION polygon_contains(polygon JSONB, longitude DOUBLE PRECISION, latitude DOUBLE PRECISION)
+RETURNS BOOLEAN AS $$
+DECLARE
+    ring JSONB;
+    ring_index BIGINT;
+    inside BOOLEAN;
+    n INTEGER;
+    i INTEGER;
+    j INTEGER;
+    xi DOUBLE PRECISION;
+    yi DOUBLE PRECISION;
+    xj DOUBLE PRECISION;
+    yj DOUBLE PRECISION;
+BEGIN
+    FOR ring, ring_index IN SELECT * FROM jsonb_array_elements(polygon->'coordinates') WITH ORDINALITY LOOP
+        inside := FALSE;
+        n := jsonb_array_length(ring);
+        j := n - 1;
+        FOR i IN 0..n - 1 LOOP
+            xi := (ring->i->>0)::DOUBLE PRECISION;
+            yi := (ring->i->>1)::DOUBLE PRECISION;
+            xj := (ring->j->>0)::DOUBLE PRECISION;
+            yj := (ring->j->>1)::DOUBLE PRECISION;
+            -- Nested, since AND does not guarantee that yi <> yj is checked
+            -- before the division
+            IF (yi > latitude) <> (yj > latitude) THEN
+                IF longitude < (xj - xi) * (latitude - yi) / (yj - yi) + xi THEN
+                    inside := NOT inside;
+                END IF;
+            END IF;
+            j := i;
+        END LOOP;
+
+        -- The first ring is the exterior, all others are holes
+        IF inside = (ring_index > 1) THEN
+            RETURN FALSE;
+        END IF;
+    END LOOP;
+
+    RETURN TRUE;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+
+CREATE TABLE area_mesh_node_override (
+    area_id BIGINT NOT NULL REFERENCES area(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    mesh_node_id UUID NOT NULL REFERENCES mesh_node(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    included BOOLEAN NOT NULL,
+    PRIMARY KEY (area_id, mesh_node_id)
+);
+
+-- Mesh nodes that were listed manually stay members of their areas
+INSERT INTO area_mesh_node_override (area_id, mesh_node_id, included)
+SELECT area_id, mesh_node_id, TRUE
+FROM area_mesh_node;
+
+DELETE FROM area_mesh_node;
+
+INSERT INTO area_mesh_node (area_id, mesh_node_id)
+SELECT a.id, n.id
+FROM area a, mesh_node n
+WHERE polygon_contains(a.boundary, n.longitude, n.latitude);
+
+-- area_member combines the membership by location with the overrides
+CREATE VIEW area_member AS
+SELECT am.area_id, am.mesh_node_id
+FROM area_mesh_node am
+WHERE NOT EXISTS (
+    SELECT 1
+    FROM area_mesh_node_override o
+    WHERE o.area_id = am.area_id
+    AND o.mesh_node_id = am.mesh_node_id
+    AND NOT o.included
+)
+UNION
+SELECT o.area_id, o.mesh_node_id
+FROM area_mesh_node_override o
+WHERE o.included;
@@ -0,0 +1,14 @@
+-- Areas can be nested, like stands within a forest district. An area can only
+-- be deleted after its child areas.
+
+ALTER TABLE area
+    ADD COLUMN parent_id BIGINT REFERENCES area(id) ON DELETE RESTRICT ON UPDATE CASCADE;
+
+-- area_descendant relates every area to itself and to all areas below it
+CREATE RECURSIVE VIEW area_descendant (area_id, descendant_id) AS
+SELECT id, id
+FROM area
+UNION
+SELECT d.area_id, a.id
+FROM area a
+JOIN area_descendant d ON a.parent_id = d.descendant_id;
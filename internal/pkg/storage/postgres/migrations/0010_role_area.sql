@@ -0,0 +1,9 @@
+-- Roles can be scoped to areas, so their permissions only apply to the mesh
+-- nodes of these areas. Areas can not be deleted while roles are scoped to
+-- them, since that would widen the scope of the roles.
+
+CREATE TABLE role_area (
+    role_id BIGINT NOT NULL REFERENCES role(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    area_id BIGINT NOT NULL REFERENCES area(id) ON DELETE RESTRICT ON UPDATE CASCADE,
+    PRIMARY KEY (role_id, area_id)
+);
@@ -0,0 +1,5 @@
+-- Ingestion only stores data of an auto-created type while the type allows
+-- it, so types created by a typo can be blocked without deleting them.
+
+ALTER TABLE data_type
+    ADD COLUMN auto_create_allowed BOOLEAN NOT NULL DEFAULT TRUE;
@@ -0,0 +1,4 @@
+-- The first and last measurement of a mesh node and data type are looked up
+-- without scanning all data of the type.
+
+CREATE INDEX idx_data_mesh_node_id_data_type_id_measured_at ON data (mesh_node_id, data_type_id, measured_at);
@@ -0,0 +1,5 @@
+-- Imports of accounts with a role scoped to areas only import data of the
+-- mesh nodes of these areas.
+
+ALTER TABLE data_import
+    ADD COLUMN area_ids BIGINT[];
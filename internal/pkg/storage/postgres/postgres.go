@@ -2,19 +2,31 @@ package postgres
 
 import (
 	"database/sql"
+	"embed"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
+	"sort"
 
-	_ "embed"
-
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 //go:embed schema.sql
 var schema string
 
+// migrations are applied in lexical order of their file names on top of the
+// initial schema. Every migration is only applied once.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
 type DB struct {
 	pool *sql.DB
+
+	// AutoCreateDataTypes allows the ingestion of data with an unknown type
+	// to create that type.
+	AutoCreateDataTypes bool
 }
 
 func New(dsn string) (*DB, error) {
@@ -36,6 +48,16 @@ func New(dsn string) (*DB, error) {
 	}, nil
 }
 
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
 func migrateDatabase(pool *sql.DB) error {
 	var tableCount uint32
 	if err := pool.QueryRow(`
@@ -48,14 +70,72 @@ WHERE table_schema = 'public'
 
 	if tableCount > 0 {
 		log.Printf("database already populated with %d tables", tableCount)
-		return nil
+	} else {
+		log.Println("initializing new database")
+
+		if _, err := pool.Exec(schema); err != nil {
+			return err
+		}
 	}
 
-	log.Println("initializing new database")
+	return applyMigrations(pool)
+}
+
+func applyMigrations(pool *sql.DB) error {
+	if _, err := pool.Exec(`
+CREATE TABLE IF NOT EXISTS schema_migration (
+    name VARCHAR(120) NOT NULL PRIMARY KEY,
+    applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+`); err != nil {
+		return err
+	}
 
-	if _, err := pool.Exec(schema); err != nil {
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
 		return err
 	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := applyMigration(pool, name); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
 
 	return nil
 }
+
+func applyMigration(pool *sql.DB, name string) error {
+	tx, err := pool.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+INSERT INTO schema_migration (name)
+VALUES ($1)
+ON CONFLICT (name) DO NOTHING;
+`, name)
+	if err != nil {
+		return err
+	}
+
+	if num, err := res.RowsAffected(); err != nil || num == 0 {
+		return err
+	}
+
+	migration, err := migrations.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("applying migration %s", name)
+
+	if _, err := tx.Exec(string(migration)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
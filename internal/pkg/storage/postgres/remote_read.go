@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mdma-backend/mdma-backend/internal/api/remote_read"
+)
+
+// LabelValues returns the names of all data types or the UUIDs of all mesh
+// nodes.
+func (db DB) LabelValues(name string) ([]string, error) {
+	var query string
+	switch name {
+	case "__name__":
+		query = "SELECT name FROM data_type ORDER BY name;"
+	case "mesh_node":
+		query = "SELECT id::text FROM mesh_node ORDER BY id;"
+	default:
+		return nil, fmt.Errorf("unknown label %s", name)
+	}
+
+	rows, err := db.pool.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
+
+func (db DB) ReadSeries(dataTypes []string, meshNodeUUIDs []string, start time.Time, end time.Time, limit int) ([]remote_read.Series, error) {
+	query := `
+	SELECT dt.name, d.mesh_node_id, d.measured_at, ` + valueNumber + `
+	FROM data d
+	JOIN data_type dt ON d.data_type_id = dt.id
+	WHERE d.measured_at >= $1
+	AND d.measured_at <= $2
+	AND ` + valueNumber + ` IS NOT NULL
+	`
+	params := []interface{}{start, end}
+
+	if dataTypes != nil {
+		query += " AND dt.name = ANY($" + strconv.Itoa(len(params)+1) + "::text[])"
+		params = append(params, pq.Array(dataTypes))
+	}
+
+	if meshNodeUUIDs != nil {
+		query += " AND d.mesh_node_id = ANY($" + strconv.Itoa(len(params)+1) + "::uuid[])"
+		params = append(params, pq.Array(meshNodeUUIDs))
+	}
+
+	query += " ORDER BY dt.name, d.mesh_node_id, d.measured_at LIMIT $" + strconv.Itoa(len(params)+1)
+	// One more row than allowed tells if there are too many samples
+	params = append(params, limit+1)
+
+	rows, err := db.pool.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var series []remote_read.Series
+	for count := 0; rows.Next(); count++ {
+		if count == limit {
+			return nil, remote_read.ErrTooManySamples
+		}
+
+		var dataType, meshNodeUUID string
+		var measuredAt time.Time
+		var value float64
+		if err := rows.Scan(&dataType, &meshNodeUUID, &measuredAt, &value); err != nil {
+			return nil, err
+		}
+
+		if i := len(series) - 1; i < 0 || series[i].DataType != dataType || series[i].MeshNodeUUID != meshNodeUUID {
+			series = append(series, remote_read.Series{
+				DataType:     dataType,
+				MeshNodeUUID: meshNodeUUID,
+			})
+		}
+
+		i := len(series) - 1
+		series[i].Samples = append(series[i].Samples, remote_read.Sample{
+			Timestamp: measuredAt.UnixMilli(),
+			Value:     value,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return series, nil
+}
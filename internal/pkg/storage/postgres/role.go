@@ -6,20 +6,26 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/lib/pq"
 	"github.com/mdma-backend/mdma-backend/internal/types"
 )
 
+// roleAreaColumns selects the areas of a role r and the mesh nodes of these
+// areas and of all areas below them as JSON arrays.
+const roleAreaColumns = `COALESCE((SELECT json_agg(ra.area_id) FROM role_area ra WHERE ra.role_id = r.id), '[]'),
+	COALESCE((SELECT json_agg(DISTINCT m.mesh_node_id) FROM role_area ra JOIN area_descendant ad ON ad.area_id = ra.area_id JOIN area_member m ON m.area_id = ad.descendant_id WHERE ra.role_id = r.id), '[]')`
+
 func (db DB) RoleByUserAccountID(uaId types.UserAccountID) (types.Role, error) {
 	var r types.Role
-	var perms []byte
+	var perms, areaIDs, meshNodeUUIDs []byte
 	if err := db.pool.QueryRow(`
-SELECT r.id, r.created_at, r.updated_at, r.name, json_agg(rp.permission) AS permissions
+SELECT r.id, r.created_at, r.updated_at, r.name, json_agg(rp.permission) AS permissions, `+roleAreaColumns+`
 FROM role r
 JOIN role_permission rp ON r.id = rp.role_id
 JOIN user_account ua ON r.id = ua.role_id
 WHERE ua.id = $1
 GROUP BY r.id;
-`, uaId).Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt, &r.Name, &perms); errors.Is(err, sql.ErrNoRows) {
+`, uaId).Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt, &r.Name, &perms, &areaIDs, &meshNodeUUIDs); errors.Is(err, sql.ErrNoRows) {
 		return r, types.ErrNotFound
 	} else if err != nil {
 		return r, err
@@ -29,20 +35,28 @@ GROUP BY r.id;
 		return r, err
 	}
 
+	if err := json.Unmarshal(areaIDs, &r.AreaIDs); err != nil {
+		return r, err
+	}
+
+	if err := json.Unmarshal(meshNodeUUIDs, &r.MeshNodeUUIDs); err != nil {
+		return r, err
+	}
+
 	return r, nil
 }
 
 func (db DB) RoleByServiceAccountID(saId types.ServiceAccountID) (types.Role, error) {
 	var r types.Role
-	var perms []byte
+	var perms, areaIDs, meshNodeUUIDs []byte
 	if err := db.pool.QueryRow(`
-SELECT r.id, r.created_at, r.updated_at, r.name, json_agg(rp.permission) AS permissions
+SELECT r.id, r.created_at, r.updated_at, r.name, json_agg(rp.permission) AS permissions, `+roleAreaColumns+`
 FROM role r
 JOIN role_permission rp ON r.id = rp.role_id
 JOIN service_account sa ON r.id = sa.role_id
 WHERE sa.id = $1
 GROUP BY r.id;
-`, saId).Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt, &r.Name, &perms); errors.Is(err, sql.ErrNoRows) {
+`, saId).Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt, &r.Name, &perms, &areaIDs, &meshNodeUUIDs); errors.Is(err, sql.ErrNoRows) {
 		return r, types.ErrNotFound
 	} else if err != nil {
 		return r, err
@@ -52,19 +66,28 @@ GROUP BY r.id;
 		return r, err
 	}
 
+	if err := json.Unmarshal(areaIDs, &r.AreaIDs); err != nil {
+		return r, err
+	}
+
+	if err := json.Unmarshal(meshNodeUUIDs, &r.MeshNodeUUIDs); err != nil {
+		return r, err
+	}
+
 	return r, nil
 }
 
 func (db DB) RoleByID(roleID types.RoleID) (types.Role, error) {
 	var r types.Role
-	var perms []byte
+	var perms, areaIDs []byte
 	if err := db.pool.QueryRow(`
-SELECT r.id, r.created_at, r.updated_at, r.name, json_agg(rp.permission) AS permissions
+SELECT r.id, r.created_at, r.updated_at, r.name, json_agg(rp.permission) AS permissions,
+	COALESCE((SELECT json_agg(ra.area_id) FROM role_area ra WHERE ra.role_id = r.id), '[]')
 FROM role r
 JOIN role_permission rp ON r.id = rp.role_id
 WHERE r.id = $1
 GROUP BY r.id;
-`, roleID).Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt, &r.Name, &perms); errors.Is(err, sql.ErrNoRows) {
+`, roleID).Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt, &r.Name, &perms, &areaIDs); errors.Is(err, sql.ErrNoRows) {
 		return r, types.ErrNotFound
 	} else if err != nil {
 		return r, err
@@ -74,13 +97,18 @@ GROUP BY r.id;
 		return r, err
 	}
 
+	if err := json.Unmarshal(areaIDs, &r.AreaIDs); err != nil {
+		return r, err
+	}
+
 	return r, nil
 }
 
 func (db DB) Roles() ([]types.Role, error) {
 	rows, err := db.pool.Query(`
-SELECT id, created_at, updated_at, name
-FROM role;
+SELECT r.id, r.created_at, r.updated_at, r.name,
+	COALESCE((SELECT json_agg(ra.area_id) FROM role_area ra WHERE ra.role_id = r.id), '[]')
+FROM role r;
 `)
 	if err != nil {
 		return nil, err
@@ -90,7 +118,12 @@ FROM role;
 	var roles []types.Role
 	for rows.Next() {
 		var r types.Role
-		if err := rows.Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt, &r.Name); err != nil {
+		var areaIDs []byte
+		if err := rows.Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt, &r.Name, &areaIDs); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(areaIDs, &r.AreaIDs); err != nil {
 			return nil, err
 		}
 		roles = append(roles, r)
@@ -134,6 +167,10 @@ RETURNING id, created_at;
 		return err
 	}
 
+	if err := insertRoleAreas(tx, role.ID, role.AreaIDs); err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
@@ -142,6 +179,7 @@ func (db DB) UpdateRole(roleID types.RoleID, role *types.Role) error {
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
 	if err = tx.QueryRow(`
 UPDATE role
@@ -162,6 +200,13 @@ WHERE role_id = $1;
 		return err
 	}
 
+	if _, err = tx.Exec(`
+DELETE FROM role_area
+WHERE role_id = $1;
+`, roleID); err != nil {
+		return err
+	}
+
 	query := "INSERT INTO role_permission (role_id, permission) values "
 	var params []interface{}
 
@@ -182,6 +227,10 @@ WHERE role_id = $1;
 		return err
 	}
 
+	if err := insertRoleAreas(tx, roleID, role.AreaIDs); err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
@@ -200,3 +249,28 @@ WHERE id = $1;
 
 	return nil
 }
+
+// insertRoleAreas scopes the role to the areas. All areas must exist.
+func insertRoleAreas(tx *sql.Tx, roleID types.RoleID, areaIDs []types.AreaID) error {
+	if len(areaIDs) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(areaIDs))
+	for _, id := range areaIDs {
+		ids = append(ids, int64(id))
+	}
+
+	if _, err := tx.Exec(`
+INSERT INTO role_area (role_id, area_id)
+SELECT $1, a
+FROM unnest($2::bigint[]) AS a
+ON CONFLICT DO NOTHING;
+`, roleID, pq.Array(ids)); isForeignKeyViolation(err) {
+		return fmt.Errorf("%w: unknown area", types.ErrInvalidValue)
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package postgres
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+// distanceExpression returns the great-circle distance in meters between
+// the mesh node n and the point of the parameters lat and lon, using the
+// haversine formula. Rounding can push the argument of ASIN above 1 for
+// antipodal points, so it is clamped.
+func distanceExpression(lat, lon string) string {
+	return fmt.Sprintf(`(2 * %s * ASIN(LEAST(1, SQRT(
+	POWER(SIN(RADIANS(n.latitude - %[2]s) / 2), 2)
+	+ COS(RADIANS(%[2]s)) * COS(RADIANS(n.latitude)) * POWER(SIN(RADIANS(n.longitude - %[3]s) / 2), 2)
+))))`, strconv.FormatFloat(types.EarthRadius, 'f', -1, 64), lat, lon)
+}
+
+// spatialConditions returns the conditions on the mesh node n of a spatial
+// filter and the distance expression if it has a point. The parameters are
+// numbered after params.
+func spatialConditions(f types.SpatialFilter, params []interface{}) ([]string, string, []interface{}) {
+	param := func(v interface{}) string {
+		params = append(params, v)
+		return "$" + strconv.Itoa(len(params)) + "::double precision"
+	}
+
+	var conditions []string
+	if b := f.BoundingBox; b != nil {
+		conditions = append(conditions, "n.latitude BETWEEN "+param(b.MinLatitude)+" AND "+param(b.MaxLatitude))
+
+		minLon, maxLon := param(b.MinLongitude), param(b.MaxLongitude)
+		if b.MinLongitude <= b.MaxLongitude {
+			conditions = append(conditions, "n.longitude BETWEEN "+minLon+" AND "+maxLon)
+		} else {
+			conditions = append(conditions, "(n.longitude >= "+minLon+" OR n.longitude <= "+maxLon+")")
+		}
+	}
+
+	var distance string
+	if c := f.Near; c != nil {
+		distance = distanceExpression(param(c.Latitude), param(c.Longitude))
+		conditions = append(conditions, distance+" <= "+param(c.Radius))
+	}
+
+	return conditions, distance, params
+}
+
+// MeshNodesWithin returns the mesh nodes matching a spatial filter. If the
+// filter has a point, the mesh nodes are sorted by their distance to it.
+func (db DB) MeshNodesWithin(f types.SpatialFilter) ([]types.MeshNode, error) {
+	conditions, distance, params := spatialConditions(f, nil)
+
+	query := `
+SELECT n.id, n.mesh_node_update_id, n.created_at, n.updated_at, n.latitude, n.longitude, `
+	if distance != "" {
+		query += distance
+	} else {
+		query += "NULL::double precision"
+	}
+	query += `
+FROM mesh_node n`
+	if len(conditions) > 0 {
+		query += `
+WHERE ` + strings.Join(conditions, " AND ")
+	}
+	if distance != "" {
+		query += `
+ORDER BY 7, n.id`
+	}
+
+	rows, err := db.pool.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var meshNodes []types.MeshNode
+	for rows.Next() {
+		var n types.MeshNode
+		if err := rows.Scan(&n.UUID, &n.UpdateID, &n.CreatedAt, &n.UpdatedAt, &n.Latitude, &n.Longitude, &n.Distance); err != nil {
+			return nil, err
+		}
+		meshNodes = append(meshNodes, n)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return meshNodes, nil
+}
@@ -0,0 +1,70 @@
+package postgres
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+func TestSpatialConditions(t *testing.T) {
+	tests := []struct {
+		name       string
+		filter     types.SpatialFilter
+		conditions []string
+		params     []interface{}
+	}{
+		{
+			name:   "bounding box",
+			filter: types.SpatialFilter{BoundingBox: &types.BoundingBox{MinLongitude: 9, MinLatitude: 53, MaxLongitude: 10, MaxLatitude: 54}},
+			conditions: []string{
+				"n.latitude BETWEEN $2::double precision AND $3::double precision",
+				"n.longitude BETWEEN $4::double precision AND $5::double precision",
+			},
+			params: []interface{}{"before", 53.0, 54.0, 9.0, 10.0},
+		},
+		{
+			name:   "bounding box crossing the antimeridian",
+			filter: types.SpatialFilter{BoundingBox: &types.BoundingBox{MinLongitude: 170, MinLatitude: -10, MaxLongitude: -170, MaxLatitude: 10}},
+			conditions: []string{
+				"n.latitude BETWEEN $2::double precision AND $3::double precision",
+				"(n.longitude >= $4::double precision OR n.longitude <= $5::double precision)",
+			},
+			params: []interface{}{"before", -10.0, 10.0, 170.0, -170.0},
+		},
+	}
+
+	for _, tt := range tests {
+		conditions, distance, params := spatialConditions(tt.filter, []interface{}{"before"})
+		if !reflect.DeepEqual(conditions, tt.conditions) {
+			t.Errorf("spatialConditions() of %s = %v, want %v", tt.name, conditions, tt.conditions)
+		}
+		if distance != "" {
+			t.Errorf("spatialConditions() of %s has distance %s", tt.name, distance)
+		}
+		if !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("spatialConditions() params of %s = %v, want %v", tt.name, params, tt.params)
+		}
+	}
+}
+
+func TestSpatialConditionsNear(t *testing.T) {
+	f := types.SpatialFilter{Near: &types.Circle{Latitude: 53.55, Longitude: 9.99, Radius: 500}}
+
+	conditions, distance, params := spatialConditions(f, nil)
+
+	if want := distanceExpression("$1::double precision", "$2::double precision"); distance != want {
+		t.Errorf("spatialConditions() distance = %s, want %s", distance, want)
+	}
+	if want := []string{distance + " <= $3::double precision"}; !reflect.DeepEqual(conditions, want) {
+		t.Errorf("spatialConditions() = %v, want %v", conditions, want)
+	}
+	if want := []interface{}{53.55, 9.99, 500.0}; !reflect.DeepEqual(params, want) {
+		t.Errorf("spatialConditions() params = %v, want %v", params, want)
+	}
+
+	if !strings.Contains(distance, "ASIN(LEAST(1, ") {
+		t.Errorf("distance does not clamp the argument of ASIN: %s", distance)
+	}
+}
@@ -0,0 +1,427 @@
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mdma-backend/mdma-backend/internal/api/sta"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+// Datastreams returns every pair of mesh node and data type with data. The
+// first and last measurement of each pair are read from the index on mesh
+// node, data type and measurement time, pairs without data have none.
+func (db DB) Datastreams() ([]sta.Datastream, error) {
+	rows, err := db.pool.Query(`
+SELECT n.id, dt.id, f.measured_at, l.measured_at
+FROM mesh_node n
+CROSS JOIN data_type dt
+CROSS JOIN LATERAL (
+	SELECT d.measured_at
+	FROM data d
+	WHERE d.mesh_node_id = n.id
+	AND d.data_type_id = dt.id
+	ORDER BY d.measured_at
+	LIMIT 1
+) f
+CROSS JOIN LATERAL (
+	SELECT d.measured_at
+	FROM data d
+	WHERE d.mesh_node_id = n.id
+	AND d.data_type_id = dt.id
+	ORDER BY d.measured_at DESC
+	LIMIT 1
+) l
+ORDER BY n.id, dt.id;
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var datastreams []sta.Datastream
+	for rows.Next() {
+		var ds sta.Datastream
+		var meshNodeUUID string
+		if err := rows.Scan(&meshNodeUUID, &ds.DataTypeID, &ds.FirstMeasuredAt, &ds.LastMeasuredAt); err != nil {
+			return nil, err
+		}
+
+		ds.MeshNodeUUID, err = types.UUIDFromString(meshNodeUUID)
+		if err != nil {
+			return nil, err
+		}
+		datastreams = append(datastreams, ds)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return datastreams, nil
+}
+
+func (db DB) Observations(q sta.ObservationQuery) ([]sta.Observation, error) {
+	where, params, err := observationConditions(q)
+	if err != nil {
+		return nil, err
+	}
+
+	orderBy, err := observationOrder(q.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	SELECT d.id, d.mesh_node_id, d.data_type_id, d.measured_at, ` + valueText + `
+	FROM data d
+	JOIN data_type dt ON d.data_type_id = dt.id
+	WHERE ` + where + `
+	ORDER BY ` + orderBy + `
+	LIMIT $` + strconv.Itoa(len(params)+1) + ` OFFSET $` + strconv.Itoa(len(params)+2)
+	params = append(params, q.Limit, q.Offset)
+
+	rows, err := db.pool.Query(query, params...)
+	if err != nil {
+		return nil, filterError(q, err)
+	}
+	defer rows.Close()
+
+	var observations []sta.Observation
+	for rows.Next() {
+		var o sta.Observation
+		var meshNodeUUID string
+		if err := rows.Scan(&o.UUID, &meshNodeUUID, &o.DataTypeID, &o.MeasuredAt, &o.Value); err != nil {
+			return nil, err
+		}
+
+		o.MeshNodeUUID, err = types.UUIDFromString(meshNodeUUID)
+		if err != nil {
+			return nil, err
+		}
+		observations = append(observations, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, filterError(q, err)
+	}
+
+	return observations, nil
+}
+
+func (db DB) CountObservations(q sta.ObservationQuery) (int, error) {
+	where, params, err := observationConditions(q)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	if err := db.pool.QueryRow(`
+	SELECT COUNT(*)
+	FROM data d
+	JOIN data_type dt ON d.data_type_id = dt.id
+	WHERE `+where, params...).Scan(&count); err != nil {
+		return 0, filterError(q, err)
+	}
+
+	return count, nil
+}
+
+// filterError turns errors of the database caused by the filter of a query,
+// like comparisons of mismatching types or divisions by zero, into
+// types.ErrInvalidValue.
+func filterError(q sta.ObservationQuery, err error) error {
+	var pqErr *pq.Error
+	if q.Filter == nil || !errors.As(err, &pqErr) {
+		return err
+	}
+
+	// Data exceptions and syntax errors or access rule violations
+	if class := pqErr.Code.Class(); class == "22" || class == "42" {
+		return fmt.Errorf("%w: %s", types.ErrInvalidValue, pqErr.Message)
+	}
+
+	return err
+}
+
+func observationConditions(q sta.ObservationQuery) (string, []interface{}, error) {
+	f := observationFilter{}
+	conditions := []string{"TRUE"}
+
+	if q.MeshNodeUUID != nil {
+		conditions = append(conditions, "d.mesh_node_id = "+f.param(q.MeshNodeUUID.String()))
+	}
+	if q.MeshNodeUUIDs != nil {
+		meshNodeUUIDs := make([]string, 0, len(q.MeshNodeUUIDs))
+		for _, uuid := range q.MeshNodeUUIDs {
+			meshNodeUUIDs = append(meshNodeUUIDs, uuid.String())
+		}
+		conditions = append(conditions, "d.mesh_node_id = ANY("+f.param(pq.Array(meshNodeUUIDs))+"::uuid[])")
+	}
+	if q.DataTypeID != nil {
+		conditions = append(conditions, "d.data_type_id = "+f.param(*q.DataTypeID))
+	}
+	if q.Filter != nil {
+		condition, err := f.sql(q.Filter, "")
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+
+	return strings.Join(conditions, " AND "), f.params, nil
+}
+
+// observationOrder returns the order of observations. Observations are
+// always ordered by measured_at and id last, so pages are stable.
+func observationOrder(orderBy []sta.OrderBy) (string, error) {
+	var order []string
+	for _, o := range orderBy {
+		columns := []string{observationColumns[o.Property]}
+		if o.Property == "result" {
+			columns = []string{valueNumber, valueText}
+		} else if o.Property == "Datastream/ObservedProperty/@iot.id" {
+			columns = []string{"d.data_type_id"}
+		} else if columns[0] == "" {
+			return "", fmt.Errorf("%w: can not order observations by %s", types.ErrInvalidValue, o.Property)
+		}
+
+		direction := " ASC"
+		if o.Descending {
+			direction = " DESC"
+		}
+		for _, column := range columns {
+			order = append(order, column+direction)
+		}
+	}
+
+	return strings.Join(append(order, "d.measured_at ASC", "d.id ASC"), ", "), nil
+}
+
+// observationColumns are the columns of the properties of observations.
+// The column of result depends on the value it is compared with.
+var observationColumns = map[string]string{
+	"@iot.id":                             "d.id::text",
+	"id":                                  "d.id::text",
+	"phenomenonTime":                      "d.measured_at",
+	"resultTime":                          "d.measured_at",
+	"Datastream/@iot.id":                  "(d.mesh_node_id::text || ':' || d.data_type_id::text)",
+	"Datastream/Thing/@iot.id":            "d.mesh_node_id::text",
+	"Datastream/ObservedProperty/@iot.id": "d.data_type_id::text",
+	"Datastream/ObservedProperty/name":    "dt.name",
+}
+
+// Kinds of values in filters, used to choose the column of result.
+const (
+	numberValue = "number"
+	textValue   = "text"
+	boolValue   = "bool"
+	timeValue   = "time"
+)
+
+var (
+	comparisonOperators = map[string]string{"eq": "=", "ne": "<>", "gt": ">", "ge": ">=", "lt": "<", "le": "<="}
+	arithmeticOperators = map[string]string{"add": "+", "sub": "-", "mul": "*", "div": "/"}
+	timeFunctions       = map[string]string{"year": "YEAR", "month": "MONTH", "day": "DAY", "hour": "HOUR", "minute": "MINUTE", "second": "SECOND"}
+)
+
+// observationFilter translates filters of observations to SQL conditions
+// with parameters.
+type observationFilter struct {
+	params []interface{}
+}
+
+func (f *observationFilter) param(v interface{}) string {
+	f.params = append(f.params, v)
+	return "$" + strconv.Itoa(len(f.params))
+}
+
+// sql translates an expression to SQL. kind is the kind of value the
+// expression is compared with or used as.
+func (f *observationFilter) sql(e sta.Expr, kind string) (string, error) {
+	switch e := e.(type) {
+	case sta.Literal:
+		switch v := e.Value.(type) {
+		case nil:
+			return "NULL", nil
+		case float64:
+			return f.param(v) + "::double precision", nil
+		case bool:
+			return f.param(v) + "::boolean", nil
+		case time.Time:
+			return f.param(v) + "::timestamp", nil
+		default:
+			return f.param(v) + "::text", nil
+		}
+	case sta.Property:
+		if e.Path == "result" {
+			switch kind {
+			case numberValue:
+				return valueNumber, nil
+			case boolValue:
+				return "d.value_boolean", nil
+			default:
+				return valueText, nil
+			}
+		}
+
+		// The ids of data types are numbers, but may be compared as text
+		// like the other ids
+		if e.Path == "Datastream/ObservedProperty/@iot.id" && kind == numberValue {
+			return "d.data_type_id::double precision", nil
+		}
+
+		column, ok := observationColumns[e.Path]
+		if !ok {
+			return "", fmt.Errorf("%w: can not filter observations by %s", types.ErrInvalidValue, e.Path)
+		}
+		return column, nil
+	case sta.NotExpr:
+		x, err := f.sql(e.X, boolValue)
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + x + ")", nil
+	case sta.Call:
+		return f.call(e)
+	case sta.BinaryExpr:
+		return f.binary(e)
+	default:
+		return "", fmt.Errorf("%w: unsupported expression", types.ErrInvalidValue)
+	}
+}
+
+func (f *observationFilter) binary(e sta.BinaryExpr) (string, error) {
+	if e.Op == "and" || e.Op == "or" {
+		left, err := f.sql(e.Left, boolValue)
+		if err != nil {
+			return "", err
+		}
+		right, err := f.sql(e.Right, boolValue)
+		if err != nil {
+			return "", err
+		}
+		return "(" + left + " " + strings.ToUpper(e.Op) + " " + right + ")", nil
+	}
+
+	if op, ok := arithmeticOperators[e.Op]; ok {
+		left, err := f.sql(e.Left, numberValue)
+		if err != nil {
+			return "", err
+		}
+		right, err := f.sql(e.Right, numberValue)
+		if err != nil {
+			return "", err
+		}
+		return "(" + left + " " + op + " " + right + ")", nil
+	}
+
+	op, ok := comparisonOperators[e.Op]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown operator %s", types.ErrInvalidValue, e.Op)
+	}
+
+	// Comparisons with null test whether the other side is null
+	if l, ok := e.Right.(sta.Literal); ok && l.Value == nil && (e.Op == "eq" || e.Op == "ne") {
+		left, err := f.sql(e.Left, "")
+		if err != nil {
+			return "", err
+		}
+		if e.Op == "eq" {
+			return "(" + left + " IS NULL)", nil
+		}
+		return "(" + left + " IS NOT NULL)", nil
+	}
+
+	left, err := f.sql(e.Left, kindOf(e.Right))
+	if err != nil {
+		return "", err
+	}
+	right, err := f.sql(e.Right, kindOf(e.Left))
+	if err != nil {
+		return "", err
+	}
+
+	return "(" + left + " " + op + " " + right + ")", nil
+}
+
+func (f *observationFilter) call(e sta.Call) (string, error) {
+	argKind := textValue
+	if _, ok := timeFunctions[e.Name]; ok {
+		argKind = timeValue
+	}
+
+	args := make([]string, len(e.Args))
+	for i, arg := range e.Args {
+		var err error
+		args[i], err = f.sql(arg, argKind)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	switch e.Name {
+	case "substringof":
+		return "(strpos(" + args[1] + ", " + args[0] + ") > 0)", nil
+	case "contains":
+		return "(strpos(" + args[0] + ", " + args[1] + ") > 0)", nil
+	case "startswith":
+		return "(left(" + args[0] + ", length(" + args[1] + ")) = " + args[1] + ")", nil
+	case "endswith":
+		return "(right(" + args[0] + ", length(" + args[1] + ")) = " + args[1] + ")", nil
+	case "tolower":
+		return "lower(" + args[0] + ")", nil
+	case "toupper":
+		return "upper(" + args[0] + ")", nil
+	case "length":
+		return "length(" + args[0] + ")::double precision", nil
+	case "now":
+		return "CURRENT_TIMESTAMP::timestamp", nil
+	}
+
+	if field, ok := timeFunctions[e.Name]; ok {
+		return "EXTRACT(" + field + " FROM " + args[0] + ")::double precision", nil
+	}
+
+	return "", fmt.Errorf("%w: unsupported function %s", types.ErrInvalidValue, e.Name)
+}
+
+// kindOf returns the kind of value of an expression if it is known without
+// looking at the data.
+func kindOf(e sta.Expr) string {
+	switch e := e.(type) {
+	case sta.Literal:
+		switch e.Value.(type) {
+		case float64:
+			return numberValue
+		case bool:
+			return boolValue
+		case time.Time:
+			return timeValue
+		case string:
+			return textValue
+		}
+	case sta.BinaryExpr:
+		if _, ok := arithmeticOperators[e.Op]; ok {
+			return numberValue
+		}
+		return boolValue
+	case sta.Call:
+		switch e.Name {
+		case "tolower", "toupper":
+			return textValue
+		case "now":
+			return timeValue
+		case "substringof", "contains", "startswith", "endswith":
+			return boolValue
+		default:
+			return numberValue
+		}
+	}
+
+	return ""
+}
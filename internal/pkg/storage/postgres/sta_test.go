@@ -0,0 +1,159 @@
+package postgres
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mdma-backend/mdma-backend/internal/api/sta"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+func TestObservationConditions(t *testing.T) {
+	at := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+
+	tests := []struct {
+		filter string
+		want   string
+		params []interface{}
+	}{
+		{
+			filter: "result gt 20",
+			want:   "(" + valueNumber + " > $1::double precision)",
+			params: []interface{}{20.0},
+		},
+		{
+			filter: "result eq 'on' or result eq true",
+			want:   "((" + valueText + " = $1::text) OR (d.value_boolean = $2::boolean))",
+			params: []interface{}{"on", true},
+		},
+		{
+			filter: "result ne null",
+			want:   "(" + valueText + " IS NOT NULL)",
+		},
+		{
+			filter: "Datastream/ObservedProperty/@iot.id eq '3'",
+			want:   "(d.data_type_id::text = $1::text)",
+			params: []interface{}{"3"},
+		},
+		{
+			filter: "Datastream/ObservedProperty/@iot.id eq 3",
+			want:   "(d.data_type_id::double precision = $1::double precision)",
+			params: []interface{}{3.0},
+		},
+		{
+			filter: "not startswith(Datastream/ObservedProperty/name,'temp')",
+			want:   "NOT ((left(dt.name, length($1::text)) = $1::text))",
+			params: []interface{}{"temp"},
+		},
+		{
+			filter: "year(phenomenonTime) eq 2023 and phenomenonTime lt 2023-11-14T22:13:20Z",
+			want:   "((EXTRACT(YEAR FROM d.measured_at)::double precision = $1::double precision) AND (d.measured_at < $2::timestamp))",
+			params: []interface{}{2023.0, at},
+		},
+		{
+			filter: "result mul 2 ge length(tolower(Datastream/ObservedProperty/name))",
+			want:   "((" + valueNumber + " * $1::double precision) >= length(lower(dt.name))::double precision)",
+			params: []interface{}{2.0},
+		},
+	}
+
+	for _, tt := range tests {
+		filter, err := sta.ParseFilter(tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, params, err := observationConditions(sta.ObservationQuery{Filter: filter})
+		if err != nil {
+			t.Errorf("observationConditions(%q) error = %v", tt.filter, err)
+			continue
+		}
+		if want := "TRUE AND " + tt.want; got != want {
+			t.Errorf("observationConditions(%q) = %s, want %s", tt.filter, got, want)
+		}
+		if !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("observationConditions(%q) params = %v, want %v", tt.filter, params, tt.params)
+		}
+	}
+}
+
+func TestObservationConditionsScope(t *testing.T) {
+	meshNodeUUID, err := types.UUIDFromString("0cc56633-05ae-4cc3-8f71-801f429caeca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataTypeID := types.DataTypeID(3)
+
+	got, params, err := observationConditions(sta.ObservationQuery{
+		MeshNodeUUID:  &meshNodeUUID,
+		MeshNodeUUIDs: []types.UUID{},
+		DataTypeID:    &dataTypeID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "TRUE AND d.mesh_node_id = $1 AND d.mesh_node_id = ANY($2::uuid[]) AND d.data_type_id = $3"; got != want {
+		t.Errorf("observationConditions() = %s, want %s", got, want)
+	}
+	if want := []interface{}{meshNodeUUID.String(), pq.Array([]string{}), dataTypeID}; !reflect.DeepEqual(params, want) {
+		t.Errorf("observationConditions() params = %v, want %v", params, want)
+	}
+}
+
+func TestObservationConditionsInvalid(t *testing.T) {
+	for _, filter := range []string{
+		"Thing/name eq 'Kitchen'",
+		"result mod 2 eq 0",
+	} {
+		expr, err := sta.ParseFilter(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := observationConditions(sta.ObservationQuery{Filter: expr}); !errors.Is(err, types.ErrInvalidValue) {
+			t.Errorf("observationConditions(%q) error = %v, want %v", filter, err, types.ErrInvalidValue)
+		}
+	}
+}
+
+func TestObservationOrder(t *testing.T) {
+	got, err := observationOrder([]sta.OrderBy{{Property: "result", Descending: true}, {Property: "Datastream/ObservedProperty/@iot.id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := valueNumber + " DESC, " + valueText + " DESC, d.data_type_id ASC, d.measured_at ASC, d.id ASC"; got != want {
+		t.Errorf("observationOrder() = %s, want %s", got, want)
+	}
+
+	if _, err := observationOrder([]sta.OrderBy{{Property: "parameters"}}); !errors.Is(err, types.ErrInvalidValue) {
+		t.Errorf("observationOrder() error = %v, want %v", err, types.ErrInvalidValue)
+	}
+}
+
+func TestFilterError(t *testing.T) {
+	filter := sta.Literal{Value: true}
+	divisionByZero := &pq.Error{Code: "22012", Message: "division by zero"}
+	connectionFailure := &pq.Error{Code: "08006", Message: "connection failure"}
+
+	tests := []struct {
+		name string
+		q    sta.ObservationQuery
+		err  error
+		want error
+	}{
+		{name: "data exception", q: sta.ObservationQuery{Filter: filter}, err: divisionByZero, want: types.ErrInvalidValue},
+		{name: "undefined function", q: sta.ObservationQuery{Filter: filter}, err: &pq.Error{Code: "42883"}, want: types.ErrInvalidValue},
+		{name: "connection failure", q: sta.ObservationQuery{Filter: filter}, err: connectionFailure, want: connectionFailure},
+		{name: "without filter", err: divisionByZero, want: divisionByZero},
+	}
+
+	for _, tt := range tests {
+		if got := filterError(tt.q, tt.err); !errors.Is(got, tt.want) {
+			t.Errorf("filterError() of %s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+const (
+	// valueText selects the value of the data row d as text regardless of
+	// the column it is stored in.
+	valueText = `COALESCE(d.value_float::text, d.value_integer::text, d.value_boolean::text, d.value_string, d.value_json::text)`
+	// valueNumber selects the value of the data row d as a number. Values
+	// that are neither numeric nor boolean are null.
+	valueNumber = `COALESCE(d.value_float, d.value_integer::double precision, d.value_boolean::int::double precision)`
+)
+
+// typedValue holds a value in the column matching the kind of its data type.
+// All other columns are null.
+type typedValue struct {
+	Float   sql.NullFloat64
+	Integer sql.NullInt64
+	Boolean sql.NullBool
+	String  sql.NullString
+	JSON    sql.NullString
+}
+
+// parseValue parses value according to the kind of the data type and
+// validates it against the range of the data type. Floats are rounded to the
+// precision of the data type.
+func parseValue(dataType types.DataType, value string) (typedValue, error) {
+	var v typedValue
+	switch dataType.Kind {
+	case types.FloatValueKind:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return v, fmt.Errorf("%w: %q is not a float", types.ErrInvalidValue, value)
+		}
+		if dataType.Precision != nil {
+			scale := math.Pow10(int(*dataType.Precision))
+			// Values too large to be scaled already have the precision
+			if rounded := math.Round(f*scale) / scale; !math.IsInf(rounded, 0) && !math.IsNaN(rounded) {
+				f = rounded
+			}
+		}
+		if err := validateRange(dataType, f); err != nil {
+			return v, err
+		}
+		v.Float = sql.NullFloat64{Float64: f, Valid: true}
+	case types.IntegerValueKind:
+		i, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return v, fmt.Errorf("%w: %q is not an integer", types.ErrInvalidValue, value)
+		}
+		if err := validateRange(dataType, float64(i)); err != nil {
+			return v, err
+		}
+		v.Integer = sql.NullInt64{Int64: i, Valid: true}
+	case types.BooleanValueKind:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return v, fmt.Errorf("%w: %q is not a boolean", types.ErrInvalidValue, value)
+		}
+		v.Boolean = sql.NullBool{Bool: b, Valid: true}
+	case types.StringValueKind:
+		v.String = sql.NullString{String: value, Valid: true}
+	case types.JSONValueKind:
+		if !json.Valid([]byte(value)) {
+			return v, fmt.Errorf("%w: %q is not valid json", types.ErrInvalidValue, value)
+		}
+		v.JSON = sql.NullString{String: value, Valid: true}
+	default:
+		return v, fmt.Errorf("unknown value kind %s", dataType.Kind)
+	}
+
+	return v, nil
+}
+
+func validateRange(dataType types.DataType, f float64) error {
+	if dataType.Min != nil && f < *dataType.Min {
+		return fmt.Errorf("%w: %v is less than the minimum %v of %s", types.ErrInvalidValue, f, *dataType.Min, dataType.Name)
+	}
+
+	if dataType.Max != nil && f > *dataType.Max {
+		return fmt.Errorf("%w: %v is greater than the maximum %v of %s", types.ErrInvalidValue, f, *dataType.Max, dataType.Name)
+	}
+
+	return nil
+}
+
+// inferValueKind guesses the kind of a data type that does not exist yet
+// from its first value.
+func inferValueKind(value string) types.ValueKind {
+	trimmed := strings.TrimSpace(value)
+	if f, err := strconv.ParseFloat(trimmed, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		return types.FloatValueKind
+	}
+
+	if _, err := strconv.ParseBool(trimmed); err == nil {
+		return types.BooleanValueKind
+	}
+
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		if json.Valid([]byte(trimmed)) {
+			return types.JSONValueKind
+		}
+	}
+
+	return types.StringValueKind
+}
@@ -0,0 +1,79 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/mdma-backend/mdma-backend/internal/types"
+)
+
+func TestParseValue(t *testing.T) {
+	min, max := -10.0, 40.0
+	precision := uint(1)
+	float := types.DataType{Name: "temperature", Kind: types.FloatValueKind, Min: &min, Max: &max, Precision: &precision}
+	integer := types.DataType{Name: "count", Kind: types.IntegerValueKind, Min: &min, Max: &max}
+
+	tests := []struct {
+		name     string
+		dataType types.DataType
+		value    string
+		want     typedValue
+		err      error
+	}{
+		{name: "float", dataType: float, value: " 21.5 ", want: typedValue{Float: sql.NullFloat64{Float64: 21.5, Valid: true}}},
+		{name: "float rounded to precision", dataType: float, value: "21.46", want: typedValue{Float: sql.NullFloat64{Float64: 21.5, Valid: true}}},
+		{name: "float at minimum", dataType: float, value: "-10", want: typedValue{Float: sql.NullFloat64{Float64: -10, Valid: true}}},
+		{name: "float below minimum", dataType: float, value: "-10.5", err: types.ErrInvalidValue},
+		{name: "float above maximum", dataType: float, value: "40.1", err: types.ErrInvalidValue},
+		{name: "float NaN", dataType: float, value: "NaN", err: types.ErrInvalidValue},
+		{name: "float Inf", dataType: float, value: "Inf", err: types.ErrInvalidValue},
+		{name: "float -Inf", dataType: float, value: "-Inf", err: types.ErrInvalidValue},
+		{name: "float NaN without range", dataType: types.DataType{Kind: types.FloatValueKind}, value: "nan", err: types.ErrInvalidValue},
+		{name: "float out of range of float64", dataType: types.DataType{Kind: types.FloatValueKind}, value: "1e400", err: types.ErrInvalidValue},
+		{name: "not a float", dataType: float, value: "warm", err: types.ErrInvalidValue},
+		{name: "integer", dataType: integer, value: "7", want: typedValue{Integer: sql.NullInt64{Int64: 7, Valid: true}}},
+		{name: "integer above maximum", dataType: integer, value: "41", err: types.ErrInvalidValue},
+		{name: "not an integer", dataType: integer, value: "7.5", err: types.ErrInvalidValue},
+		{name: "boolean", dataType: types.DataType{Kind: types.BooleanValueKind}, value: "true", want: typedValue{Boolean: sql.NullBool{Bool: true, Valid: true}}},
+		{name: "not a boolean", dataType: types.DataType{Kind: types.BooleanValueKind}, value: "yes", err: types.ErrInvalidValue},
+		{name: "string", dataType: types.DataType{Kind: types.StringValueKind}, value: " NaN ", want: typedValue{String: sql.NullString{String: " NaN ", Valid: true}}},
+		{name: "json", dataType: types.DataType{Kind: types.JSONValueKind}, value: `{"a":1}`, want: typedValue{JSON: sql.NullString{String: `{"a":1}`, Valid: true}}},
+		{name: "invalid json", dataType: types.DataType{Kind: types.JSONValueKind}, value: `{"a":`, err: types.ErrInvalidValue},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseValue(tt.dataType, tt.value)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseValue(%q) error = %v, want %v", tt.value, err, tt.err)
+			}
+			if tt.err == nil && got != tt.want {
+				t.Errorf("parseValue(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInferValueKind(t *testing.T) {
+	tests := []struct {
+		value string
+		want  types.ValueKind
+	}{
+		{value: "21.5", want: types.FloatValueKind},
+		{value: " 3 ", want: types.FloatValueKind},
+		{value: "NaN", want: types.StringValueKind},
+		{value: "-Inf", want: types.StringValueKind},
+		{value: "true", want: types.BooleanValueKind},
+		{value: `{"a":1}`, want: types.JSONValueKind},
+		{value: `[1, 2]`, want: types.JSONValueKind},
+		{value: `{"a":`, want: types.StringValueKind},
+		{value: "warm", want: types.StringValueKind},
+	}
+
+	for _, tt := range tests {
+		if got := inferValueKind(tt.value); got != tt.want {
+			t.Errorf("inferValueKind(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Package writebuffer collects ingested data in memory and stores it in
+// large batches. Writes are acknowledged before they are stored, so invalid
+// data is only reported by logs and metrics.
+package writebuffer
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/mdma-backend/mdma-backend/internal/api/data"
+	"github.com/mdma-backend/mdma-backend/internal/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ErrFull is returned if the buffer can not take more data until it is
+// flushed. Clients should retry later.
+var ErrFull = errors.New("write buffer is full")
+
+var (
+	writeBufferMeasurements = promauto.NewGauge(
+		prometheus.GaugeOpts{Name: "write_buffer_measurements"},
+	)
+	writeBufferCapacity = promauto.NewGauge(
+		prometheus.GaugeOpts{Name: "write_buffer_capacity"},
+	)
+	writeBufferRejected = promauto.NewCounter(
+		prometheus.CounterOpts{Name: "write_buffer_rejected"},
+	)
+	writeBufferDropped = promauto.NewCounter(
+		prometheus.CounterOpts{Name: "write_buffer_dropped"},
+	)
+	writeBufferFlushSeconds = promauto.NewHistogram(
+		prometheus.HistogramOpts{Name: "write_buffer_flush_seconds"},
+	)
+)
+
+type DataStore interface {
+	CreateData([]data.Data) error
+}
+
+// DataPublisher is notified about all data stored by the buffer.
+type DataPublisher interface {
+	Publish(...data.Data)
+}
+
+type Config struct {
+	// Capacity is the maximum number of buffered measurements.
+	Capacity int
+	// BatchSize is the number of measurements that triggers a flush.
+	BatchSize int
+	// FlushInterval is the maximum time measurements stay in the buffer.
+	FlushInterval time.Duration
+}
+
+type Buffer struct {
+	config    Config
+	store     DataStore
+	publisher DataPublisher
+
+	mu sync.Mutex
+	// Each write is kept as its own group, so an invalid write does not
+	// prevent the others from being stored
+	groups [][]data.Data
+	size   int
+
+	flush chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func New(config Config, store DataStore, publisher DataPublisher) *Buffer {
+	if config.BatchSize <= 0 || config.BatchSize > config.Capacity {
+		config.BatchSize = config.Capacity
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	writeBufferCapacity.Set(float64(config.Capacity))
+
+	return &Buffer{
+		config:    config,
+		store:     store,
+		publisher: publisher,
+		flush:     make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Write adds data to the buffer or returns ErrFull if it would exceed the
+// capacity. Data without UUID gets one, so a flush that is retried after a
+// failure does not store it twice. The buffer keeps a copy of dd, which the
+// caller may still read after Write returns.
+func (b *Buffer) Write(dd []data.Data) error {
+	for i := range dd {
+		if dd[i].UUID != "" {
+			continue
+		}
+
+		id, err := uuid.NewV4()
+		if err != nil {
+			return err
+		}
+		dd[i].UUID = id.String()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.size+len(dd) > b.config.Capacity {
+		writeBufferRejected.Add(float64(len(dd)))
+		return ErrFull
+	}
+
+	b.groups = append(b.groups, append([]data.Data(nil), dd...))
+	b.size += len(dd)
+	writeBufferMeasurements.Set(float64(b.size))
+
+	if b.size >= b.config.BatchSize {
+		select {
+		case b.flush <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+func (b *Buffer) Start() {
+	go b.run()
+}
+
+// Stop flushes the remaining data and stops the buffer.
+func (b *Buffer) Stop() {
+	close(b.stop)
+	<-b.done
+}
+
+func (b *Buffer) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			b.flushGroups()
+			return
+		case <-ticker.C:
+		case <-b.flush:
+		}
+
+		b.flushGroups()
+	}
+}
+
+// flushGroups stores all buffered data in one batch. If the batch contains
+// invalid data, the groups are stored one by one and the invalid ones are
+// dropped. If the store fails otherwise, the data stays in the buffer.
+func (b *Buffer) flushGroups() {
+	b.mu.Lock()
+	groups := b.groups
+	b.mu.Unlock()
+
+	if len(groups) == 0 {
+		return
+	}
+
+	start := time.Now()
+	var dd []data.Data
+	for _, group := range groups {
+		dd = append(dd, group...)
+	}
+
+	err := b.store.CreateData(dd)
+	if isInvalid(err) {
+		dd, err = b.storeGroups(groups)
+	}
+	if err != nil {
+		log.Printf("flushing write buffer: %s", err)
+		return
+	}
+	writeBufferFlushSeconds.Observe(time.Since(start).Seconds())
+
+	b.mu.Lock()
+	b.groups = b.groups[len(groups):]
+	b.size -= countData(groups)
+	writeBufferMeasurements.Set(float64(b.size))
+	b.mu.Unlock()
+
+	b.publisher.Publish(dd...)
+}
+
+// storeGroups stores the groups one by one and returns the stored data.
+func (b *Buffer) storeGroups(groups [][]data.Data) ([]data.Data, error) {
+	var stored []data.Data
+	for _, group := range groups {
+		err := b.store.CreateData(group)
+		if isInvalid(err) {
+			log.Printf("dropping %d measurements from write buffer: %s", len(group), err)
+			writeBufferDropped.Add(float64(len(group)))
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		stored = append(stored, group...)
+	}
+
+	return stored, nil
+}
+
+func isInvalid(err error) bool {
+	return errors.Is(err, types.ErrInvalidValue) || errors.Is(err, types.ErrNotFound)
+}
+
+func countData(groups [][]data.Data) int {
+	n := 0
+	for _, group := range groups {
+		n += len(group)
+	}
+	return n
+}
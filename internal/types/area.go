@@ -0,0 +1,104 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+type AreaID uint
+
+type Area struct {
+	ID          AreaID     `json:"id,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   *time.Time `json:"updatedAt,omitempty"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Boundary    Polygon    `json:"boundary"`
+	ParentID    *AreaID    `json:"parentId,omitempty"`
+	// MeshNodeUUIDs are the mesh nodes that belong to the area or to one of
+	// its descendants. They are computed from the location of the mesh nodes
+	// and the overrides.
+	MeshNodeUUIDs []UUID `json:"meshNodeUUIDs"`
+	// IncludedMeshNodeUUIDs belong to the area regardless of their location.
+	IncludedMeshNodeUUIDs []UUID `json:"includedMeshNodeUUIDs,omitempty"`
+	// ExcludedMeshNodeUUIDs do not belong to the area regardless of their
+	// location.
+	ExcludedMeshNodeUUIDs []UUID `json:"excludedMeshNodeUUIDs,omitempty"`
+}
+
+// Validate checks that the area has a name and a valid boundary and that no
+// mesh node is included and excluded at the same time.
+func (a Area) Validate() error {
+	if a.Name == "" {
+		return fmt.Errorf("%w: area requires a name", ErrInvalidValue)
+	}
+
+	if err := a.Boundary.Validate(); err != nil {
+		return fmt.Errorf("%w: boundary: %s", ErrInvalidValue, err)
+	}
+
+	included := make(map[UUID]bool, len(a.IncludedMeshNodeUUIDs))
+	for _, id := range a.IncludedMeshNodeUUIDs {
+		included[id] = true
+	}
+
+	for _, id := range a.ExcludedMeshNodeUUIDs {
+		if included[id] {
+			return fmt.Errorf("%w: mesh node %s is included and excluded", ErrInvalidValue, id)
+		}
+	}
+
+	return nil
+}
+
+// AreaTree is an area with all areas below it.
+type AreaTree struct {
+	Area
+	Children []AreaTree `json:"children"`
+}
+
+// Polygon is a GeoJSON polygon. The first ring is the exterior of the polygon,
+// all following rings are holes in it. Positions are longitude, latitude.
+type Polygon struct {
+	Type        string        `json:"type"`
+	Coordinates [][][]float64 `json:"coordinates"`
+}
+
+// Validate checks that the polygon has at least one ring and that every ring
+// is closed and consists of at least four valid positions.
+func (p Polygon) Validate() error {
+	if p.Type != "Polygon" {
+		return fmt.Errorf("type must be Polygon, got %q", p.Type)
+	}
+
+	if len(p.Coordinates) == 0 {
+		return fmt.Errorf("polygon requires at least one ring")
+	}
+
+	for i, ring := range p.Coordinates {
+		if len(ring) < 4 {
+			return fmt.Errorf("ring %d must have at least 4 positions", i)
+		}
+
+		for j, position := range ring {
+			if len(position) < 2 {
+				return fmt.Errorf("position %d of ring %d must have a longitude and a latitude", j, i)
+			}
+
+			if position[0] < -180 || position[0] > 180 {
+				return fmt.Errorf("longitude of position %d of ring %d must be between -180 and 180", j, i)
+			}
+
+			if position[1] < -90 || position[1] > 90 {
+				return fmt.Errorf("latitude of position %d of ring %d must be between -90 and 90", j, i)
+			}
+		}
+
+		first, last := ring[0], ring[len(ring)-1]
+		if first[0] != last[0] || first[1] != last[1] {
+			return fmt.Errorf("ring %d must end at its first position", i)
+		}
+	}
+
+	return nil
+}
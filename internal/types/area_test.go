@@ -0,0 +1,86 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestPolygonValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		polygon string
+		err     bool
+	}{
+		{
+			name:    "square",
+			polygon: `{"type": "Polygon", "coordinates": [[[9, 53], [10, 53], [10, 54], [9, 54], [9, 53]]]}`,
+		},
+		{
+			name: "with hole and altitude",
+			polygon: `{"type": "Polygon", "coordinates": [
+				[[-180, -90, 5], [180, -90, 5], [180, 90, 5], [-180, -90, 5]],
+				[[0, 0], [1, 0], [1, 1], [0, 0]]
+			]}`,
+		},
+		{name: "wrong type", polygon: `{"type": "MultiPolygon", "coordinates": [[[9, 53], [10, 53], [10, 54], [9, 53]]]}`, err: true},
+		{name: "without rings", polygon: `{"type": "Polygon", "coordinates": []}`, err: true},
+		{name: "too few positions", polygon: `{"type": "Polygon", "coordinates": [[[9, 53], [10, 53], [9, 53]]]}`, err: true},
+		{name: "position without latitude", polygon: `{"type": "Polygon", "coordinates": [[[9, 53], [10], [10, 54], [9, 53]]]}`, err: true},
+		{name: "longitude out of range", polygon: `{"type": "Polygon", "coordinates": [[[9, 53], [181, 53], [10, 54], [9, 53]]]}`, err: true},
+		{name: "latitude out of range", polygon: `{"type": "Polygon", "coordinates": [[[9, 53], [10, -91], [10, 54], [9, 53]]]}`, err: true},
+		{name: "open ring", polygon: `{"type": "Polygon", "coordinates": [[[9, 53], [10, 53], [10, 54], [9, 54]]]}`, err: true},
+		{
+			name:    "open hole",
+			polygon: `{"type": "Polygon", "coordinates": [[[9, 53], [10, 53], [10, 54], [9, 53]], [[9.1, 53.1], [9.2, 53.1], [9.2, 53.2], [9.1, 53.2]]]}`,
+			err:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		var p Polygon
+		if err := json.Unmarshal([]byte(tt.polygon), &p); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := p.Validate(); (err != nil) != tt.err {
+			t.Errorf("Validate() of %s error = %v, want error %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestAreaValidate(t *testing.T) {
+	boundary := Polygon{Type: "Polygon", Coordinates: [][][]float64{{{9, 53}, {10, 53}, {10, 54}, {9, 53}}}}
+	meshNode, err := UUIDFromString("0cc56633-05ae-4cc3-8f71-801f429caeca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := UUIDFromString("6f1c8f0e-1b5a-4b8e-9d3a-2c7e5f4a1b90")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		area Area
+		err  error
+	}{
+		{
+			name: "valid",
+			area: Area{Name: "Harbour", Boundary: boundary, IncludedMeshNodeUUIDs: []UUID{meshNode}, ExcludedMeshNodeUUIDs: []UUID{other}},
+		},
+		{name: "without name", area: Area{Boundary: boundary}, err: ErrInvalidValue},
+		{name: "without boundary", area: Area{Name: "Harbour"}, err: ErrInvalidValue},
+		{
+			name: "included and excluded",
+			area: Area{Name: "Harbour", Boundary: boundary, IncludedMeshNodeUUIDs: []UUID{meshNode}, ExcludedMeshNodeUUIDs: []UUID{other, meshNode}},
+			err:  ErrInvalidValue,
+		},
+	}
+
+	for _, tt := range tests {
+		if err := tt.area.Validate(); !errors.Is(err, tt.err) {
+			t.Errorf("Validate() of %s error = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+type DataImportID uint
+
+// DataImportStatus is the state of a data import job. Pending and running
+// imports are resumed on start of the backend.
+type DataImportStatus string
+
+const (
+	DataImportPending   DataImportStatus = "pending"
+	DataImportRunning   DataImportStatus = "running"
+	DataImportCompleted DataImportStatus = "completed"
+	DataImportFailed    DataImportStatus = "failed"
+)
+
+// DataImportMapping maps the columns of an imported CSV file to the fields
+// of a measurement. A column is either the name of a header column or, if
+// the file has no header, its number starting at 1.
+type DataImportMapping struct {
+	Header       bool   `json:"header"`
+	MeshNodeUUID string `json:"meshNodeUUID"`
+	Type         string `json:"type"`
+	MeasuredAt   string `json:"measuredAt"`
+	Value        string `json:"value"`
+	// TimeFormat is the layout of measuredAt as described in
+	// https://pkg.go.dev/time#pkg-constants, defaults to RFC 3339.
+	TimeFormat string `json:"timeFormat,omitempty"`
+}
+
+// DefaultDataImportMapping matches the CSV export of the data API.
+func DefaultDataImportMapping() DataImportMapping {
+	return DataImportMapping{
+		Header:       true,
+		MeshNodeUUID: "mesh_node_uuid",
+		Type:         "type",
+		MeasuredAt:   "measured_at",
+		Value:        "value",
+		TimeFormat:   time.RFC3339,
+	}
+}
+
+type DataImport struct {
+	ID        DataImportID      `json:"id"`
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt *time.Time        `json:"updatedAt,omitempty"`
+	Name      string            `json:"name"`
+	Status    DataImportStatus  `json:"status"`
+	Mapping   DataImportMapping `json:"mapping"`
+	// ProcessedRows is the number of rows after the header that are either
+	// imported or rejected. A resumed import continues after them.
+	ProcessedRows int    `json:"processedRows"`
+	ImportedRows  int    `json:"importedRows"`
+	RejectedRows  int    `json:"rejectedRows"`
+	Message       string `json:"message,omitempty"`
+	// AreaIDs are the areas of the role of the account that created the
+	// import. Rows of mesh nodes outside of them are rejected.
+	AreaIDs []AreaID `json:"areaIds,omitempty"`
+	Data    []byte   `json:"-"`
+}
+
+// DataImportRow is a row of an import that passed the checks of its format.
+type DataImportRow struct {
+	Line         int
+	MeshNodeUUID UUID
+	Type         string
+	MeasuredAt   time.Time
+	Value        string
+}
+
+// DataImportError reports why a row of an import was rejected.
+type DataImportError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// Validate checks that every field is mapped to a column and that columns
+// are numbers if the file has no header.
+func (m DataImportMapping) Validate() error {
+	columns := []struct {
+		field  string
+		column string
+	}{
+		{"meshNodeUUID", m.MeshNodeUUID},
+		{"type", m.Type},
+		{"measuredAt", m.MeasuredAt},
+		{"value", m.Value},
+	}
+
+	for _, c := range columns {
+		if c.column == "" {
+			return fmt.Errorf("%w: no column mapped to %s", ErrInvalidValue, c.field)
+		}
+
+		if m.Header {
+			continue
+		}
+
+		if n, err := strconv.Atoi(c.column); err != nil || n < 1 {
+			return fmt.Errorf("%w: column of %s must be a number greater than 0 without header", ErrInvalidValue, c.field)
+		}
+	}
+
+	return nil
+}
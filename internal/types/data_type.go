@@ -0,0 +1,45 @@
+package types
+
+import "time"
+
+type ValueKind string
+
+const (
+	FloatValueKind   ValueKind = "float"
+	IntegerValueKind ValueKind = "integer"
+	BooleanValueKind ValueKind = "boolean"
+	StringValueKind  ValueKind = "string"
+	JSONValueKind    ValueKind = "json"
+)
+
+func (k ValueKind) IsValid() bool {
+	switch k {
+	case FloatValueKind, IntegerValueKind, BooleanValueKind, StringValueKind, JSONValueKind:
+		return true
+	default:
+		return false
+	}
+}
+
+func (k ValueKind) IsNumeric() bool {
+	return k == FloatValueKind || k == IntegerValueKind
+}
+
+type DataTypeID uint
+
+type DataType struct {
+	ID          DataTypeID `json:"id,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   *time.Time `json:"updatedAt,omitempty"`
+	Name        string     `json:"name"`
+	Kind        ValueKind  `json:"kind"`
+	Unit        string     `json:"unit,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Min         *float64   `json:"min,omitempty"`
+	Max         *float64   `json:"max,omitempty"`
+	Precision   *uint      `json:"precision,omitempty"`
+	AutoCreated bool       `json:"autoCreated"`
+	// AutoCreateAllowed allows ingestion to store data of the type while it
+	// is auto-created. Clearing it blocks the name of an unwanted type.
+	AutoCreateAllowed bool `json:"autoCreateAllowed"`
+}
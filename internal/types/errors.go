@@ -2,4 +2,8 @@ package types
 
 import "errors"
 
-var ErrNotFound = errors.New("not found")
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrInvalidValue = errors.New("invalid value")
+	ErrConflict     = errors.New("conflict")
+)
@@ -11,4 +11,7 @@ type MeshNode struct {
 	UpdatedAt *time.Time        `json:"updatedAt,omitempty"`
 	Latitude  float32           `json:"latitude"`
 	Longitude float32           `json:"longitude"`
+	// Distance is the great-circle distance in meters to the point of a
+	// spatial filter.
+	Distance *float64 `json:"distance,omitempty"`
 }
@@ -15,6 +15,10 @@ const (
 	DataRead   Permission = "data_read"
 	DataDelete Permission = "data_delete"
 
+	DataTypeCreate Permission = "data_type_create"
+	DataTypeUpdate Permission = "data_type_update"
+	DataTypeDelete Permission = "data_type_delete"
+
 	UserAccountCreate Permission = "user_account_create"
 	UserAccountRead   Permission = "user_account_read"
 	UserAccountUpdate Permission = "user_account_update"
@@ -30,7 +34,10 @@ const (
 	RoleUpdate Permission = "role_update"
 	RoleDelete Permission = "role_delete"
 
-	AreaRead Permission = "area_read"
+	AreaCreate Permission = "area_create"
+	AreaRead   Permission = "area_read"
+	AreaUpdate Permission = "area_update"
+	AreaDelete Permission = "area_delete"
 )
 
 func Permissions() []Permission {
@@ -47,6 +54,10 @@ func Permissions() []Permission {
 		DataRead,
 		DataDelete,
 
+		DataTypeCreate,
+		DataTypeUpdate,
+		DataTypeDelete,
+
 		UserAccountCreate,
 		UserAccountRead,
 		UserAccountUpdate,
@@ -62,6 +73,9 @@ func Permissions() []Permission {
 		RoleUpdate,
 		RoleDelete,
 
+		AreaCreate,
 		AreaRead,
+		AreaUpdate,
+		AreaDelete,
 	}
 }
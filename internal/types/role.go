@@ -14,4 +14,33 @@ type Role struct {
 	UpdatedAt   *time.Time              `json:"updatedAt,omitempty"`
 	Name        string                  `json:"name"`
 	Permissions []permission.Permission `json:"permissions,omitempty"`
+	// AreaIDs scope the permissions of the role to the mesh nodes of these
+	// areas and of all areas below them. Roles without areas apply to all
+	// mesh nodes.
+	AreaIDs []AreaID `json:"areaIds,omitempty"`
+	// MeshNodeUUIDs are the mesh nodes of AreaIDs. They are only resolved
+	// for the role of an account.
+	MeshNodeUUIDs []UUID `json:"-"`
+}
+
+// IsScoped reports whether the role only applies to the mesh nodes of its
+// areas.
+func (r Role) IsScoped() bool {
+	return len(r.AreaIDs) > 0
+}
+
+// AppliesTo reports whether the permissions of the role apply to the mesh
+// node.
+func (r Role) AppliesTo(meshNodeUUID UUID) bool {
+	if !r.IsScoped() {
+		return true
+	}
+
+	for _, id := range r.MeshNodeUUIDs {
+		if id == meshNodeUUID {
+			return true
+		}
+	}
+
+	return false
 }
@@ -0,0 +1,139 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// EarthRadius is the mean radius of the earth in meters used for
+// great-circle distances.
+const EarthRadius = 6371008.8
+
+// BoundingBox is an area between two longitudes and two latitudes. A box
+// with a minimum longitude greater than its maximum longitude crosses the
+// antimeridian.
+type BoundingBox struct {
+	MinLongitude float64
+	MinLatitude  float64
+	MaxLongitude float64
+	MaxLatitude  float64
+}
+
+// Circle is the area within Radius meters great-circle distance of a point.
+type Circle struct {
+	Latitude  float64
+	Longitude float64
+	Radius    float64
+}
+
+// SpatialFilter selects mesh nodes by their location. Mesh nodes must be
+// within all given areas.
+type SpatialFilter struct {
+	BoundingBox *BoundingBox
+	Near        *Circle
+}
+
+// IsEmpty reports whether the filter selects all mesh nodes.
+func (f SpatialFilter) IsEmpty() bool {
+	return f.BoundingBox == nil && f.Near == nil
+}
+
+// SpatialFilterFromQuery parses the query parameters bbox, as
+// minLon,minLat,maxLon,maxLat, and near, as lat,lon, with radius in meters.
+func SpatialFilterFromQuery(query url.Values) (SpatialFilter, error) {
+	var f SpatialFilter
+
+	if bbox := query.Get("bbox"); bbox != "" {
+		v, err := parseCoordinates(bbox, 4)
+		if err != nil {
+			return f, fmt.Errorf("%w: bbox: %s", ErrInvalidValue, err)
+		}
+
+		f.BoundingBox = &BoundingBox{
+			MinLongitude: v[0],
+			MinLatitude:  v[1],
+			MaxLongitude: v[2],
+			MaxLatitude:  v[3],
+		}
+		if err := validateLatitude(v[1]); err != nil {
+			return f, fmt.Errorf("%w: bbox: %s", ErrInvalidValue, err)
+		}
+		if err := validateLatitude(v[3]); err != nil {
+			return f, fmt.Errorf("%w: bbox: %s", ErrInvalidValue, err)
+		}
+		if err := validateLongitude(v[0]); err != nil {
+			return f, fmt.Errorf("%w: bbox: %s", ErrInvalidValue, err)
+		}
+		if err := validateLongitude(v[2]); err != nil {
+			return f, fmt.Errorf("%w: bbox: %s", ErrInvalidValue, err)
+		}
+		if v[1] > v[3] {
+			return f, fmt.Errorf("%w: bbox: minimum latitude is greater than maximum latitude", ErrInvalidValue)
+		}
+	}
+
+	near, radius := query.Get("near"), query.Get("radius")
+	if near == "" && radius != "" {
+		return f, fmt.Errorf("%w: radius requires near", ErrInvalidValue)
+	}
+	if near != "" {
+		v, err := parseCoordinates(near, 2)
+		if err != nil {
+			return f, fmt.Errorf("%w: near: %s", ErrInvalidValue, err)
+		}
+		if err := validateLatitude(v[0]); err != nil {
+			return f, fmt.Errorf("%w: near: %s", ErrInvalidValue, err)
+		}
+		if err := validateLongitude(v[1]); err != nil {
+			return f, fmt.Errorf("%w: near: %s", ErrInvalidValue, err)
+		}
+
+		r, err := strconv.ParseFloat(radius, 64)
+		if err != nil || r < 0 || math.IsInf(r, 0) || math.IsNaN(r) {
+			return f, fmt.Errorf("%w: near requires a radius in meters", ErrInvalidValue)
+		}
+
+		f.Near = &Circle{
+			Latitude:  v[0],
+			Longitude: v[1],
+			Radius:    r,
+		}
+	}
+
+	return f, nil
+}
+
+func parseCoordinates(s string, n int) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != n {
+		return nil, fmt.Errorf("expected %d comma separated numbers", n)
+	}
+
+	v := make([]float64, n)
+	for i, part := range parts {
+		var err error
+		v[i], err = strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(v[i]) {
+			return nil, fmt.Errorf("invalid number %q", part)
+		}
+	}
+
+	return v, nil
+}
+
+func validateLatitude(lat float64) error {
+	if lat < -90 || lat > 90 {
+		return fmt.Errorf("latitude %g is not between -90 and 90", lat)
+	}
+	return nil
+}
+
+func validateLongitude(lon float64) error {
+	if lon < -180 || lon > 180 {
+		return fmt.Errorf("longitude %g is not between -180 and 180", lon)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package types
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestSpatialFilterFromQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  SpatialFilter
+	}{
+		{query: "", want: SpatialFilter{}},
+		{
+			query: "bbox=9.9,53.5,10.1,53.6",
+			want:  SpatialFilter{BoundingBox: &BoundingBox{MinLongitude: 9.9, MinLatitude: 53.5, MaxLongitude: 10.1, MaxLatitude: 53.6}},
+		},
+		{
+			query: "bbox=170,-10,-170,10",
+			want:  SpatialFilter{BoundingBox: &BoundingBox{MinLongitude: 170, MinLatitude: -10, MaxLongitude: -170, MaxLatitude: 10}},
+		},
+		{
+			query: "bbox=-180,%20-90,%20180,%2090",
+			want:  SpatialFilter{BoundingBox: &BoundingBox{MinLongitude: -180, MinLatitude: -90, MaxLongitude: 180, MaxLatitude: 90}},
+		},
+		{
+			query: "near=53.55,9.99&radius=500",
+			want:  SpatialFilter{Near: &Circle{Latitude: 53.55, Longitude: 9.99, Radius: 500}},
+		},
+		{
+			query: "bbox=9,53,10,54&near=53.55,9.99&radius=0",
+			want: SpatialFilter{
+				BoundingBox: &BoundingBox{MinLongitude: 9, MinLatitude: 53, MaxLongitude: 10, MaxLatitude: 54},
+				Near:        &Circle{Latitude: 53.55, Longitude: 9.99},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		query, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := SpatialFilterFromQuery(query)
+		if err != nil {
+			t.Errorf("SpatialFilterFromQuery(%q) error = %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SpatialFilterFromQuery(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+		if got.IsEmpty() != (tt.query == "") {
+			t.Errorf("SpatialFilterFromQuery(%q).IsEmpty() = %v", tt.query, got.IsEmpty())
+		}
+	}
+}
+
+func TestSpatialFilterFromQueryInvalid(t *testing.T) {
+	for _, query := range []string{
+		"bbox=9,53,10",
+		"bbox=9,53,10,54,1",
+		"bbox=9,53,east,54",
+		"bbox=9,NaN,10,54",
+		"bbox=9,54,10,53",
+		"bbox=9,-91,10,54",
+		"bbox=9,53,10,91",
+		"bbox=-181,53,10,54",
+		"bbox=9,53,181,54",
+		"radius=500",
+		"near=53.55,9.99",
+		"near=53.55,9.99&radius=-1",
+		"near=53.55,9.99&radius=NaN",
+		"near=53.55,9.99&radius=Inf",
+		"near=53.55&radius=500",
+		"near=91,9.99&radius=500",
+		"near=53.55,-181&radius=500",
+	} {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := SpatialFilterFromQuery(values); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("SpatialFilterFromQuery(%q) error = %v, want %v", query, err, ErrInvalidValue)
+		}
+	}
+}